@@ -0,0 +1,326 @@
+// Package archive mounts zip and tar archives stored on any FS as read-only
+// trees and builds archives from trees.
+//
+//	a, _ := archive.New(v, "/s3/uploads/site.zip")
+//	v.Mount("/sites/a", a)
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+type Format int
+
+const (
+	Zip Format = iota + 1
+	Tar
+	// TarGzip has no random access, entries are read by decompressing from the start
+	TarGzip
+)
+
+// FormatOf detects the format from the extension of name
+func FormatOf(name string) (Format, bool) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return Zip, true
+	case strings.HasSuffix(name, ".tar"):
+		return Tar, true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarGzip, true
+	}
+	return 0, false
+}
+
+// entry is a file or directory of the archive
+type entry struct {
+	info     os.FileInfo
+	children []string // sorted names of directories
+	zf       *zip.File
+	offset   int64 // of the data of tar entries
+}
+
+// FS is a read-only FS of the entries of an archive. The archive is indexed
+// on first use, reading only the central directory of zip files and the
+// headers of tar files, and entries are read on demand with ReadAt on the
+// archive, which must not change while mounted.
+type FS struct {
+	fsys   vfs.FS
+	name   string
+	format Format
+
+	mu      sync.Mutex
+	indexed bool
+	closed  bool
+	f       vfs.File
+	size    int64
+	entries map[string]*entry // by rooted path
+}
+
+var _ vfs.FS = (*FS)(nil)
+
+type Option func(a *FS)
+
+// WithFormat overrides the format detected from the name of the archive
+func WithFormat(format Format) Option {
+	return func(a *FS) {
+		a.format = format
+	}
+}
+
+// New returns the FS of the archive name of fsys
+func New(fsys vfs.FS, name string, opts ...Option) (*FS, error) {
+	a := &FS{fsys: fsys, name: name}
+	a.format, _ = FormatOf(name)
+	for _, o := range opts {
+		o(a)
+	}
+	if a.format < Zip || a.format > TarGzip {
+		return nil, &fs.PathError{Op: "archive", Path: name, Err: vfs.ErrNotSupported}
+	}
+	return a, nil
+}
+
+// index reads the index of the archive once it succeeds, failures are retried
+// by the next call
+func (a *FS) index() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return &fs.PathError{Op: "archive", Path: a.name, Err: fs.ErrClosed}
+	}
+	if a.indexed {
+		return nil
+	}
+	if err := a.open(); err != nil {
+		a.f, a.entries = nil, nil
+		return &fs.PathError{Op: "archive", Path: a.name, Err: err}
+	}
+	a.indexed = true
+	return nil
+}
+
+func (a *FS) open() error {
+	f, err := a.fsys.Open(a.name)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, info.Size()
+	a.entries = map[string]*entry{"/": {info: vfs.NewFileInfo("/", true, 0, info.ModTime())}}
+	switch a.format {
+	case Zip:
+		err = a.indexZip()
+	case Tar:
+		err = a.indexTar(io.NewSectionReader(f, 0, a.size))
+	case TarGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(io.NewSectionReader(f, 0, a.size)); err == nil {
+			err = a.indexTar(zr)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, e := range a.entries {
+		sort.Strings(e.children)
+	}
+	return nil
+}
+
+func (a *FS) indexZip() error {
+	zr, err := zip.NewReader(a.f, a.size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		a.add(zf.Name, &entry{info: zf.FileInfo(), zf: zf})
+	}
+	return nil
+}
+
+// countingReader counts the bytes read to locate the data of tar entries
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// seekingReader lets tar.Reader skip the data of entries by seeking
+type seekingReader struct {
+	*countingReader
+}
+
+func (s seekingReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := s.r.(io.Seeker).Seek(offset, whence)
+	s.n = n
+	return n, err
+}
+
+func (a *FS) indexTar(r io.Reader) error {
+	c := &countingReader{r: r}
+	tr := tar.NewReader(c)
+	if _, ok := r.(io.Seeker); ok {
+		tr = tar.NewReader(seekingReader{c})
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// links and special files are skipped
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+			a.add(hdr.Name, &entry{info: hdr.FileInfo(), offset: c.n})
+		}
+	}
+}
+
+// add adds e and its missing parent directories
+func (a *FS) add(name string, e *entry) {
+	name = clean(name)
+	if name == "/" {
+		return
+	}
+	if old, ok := a.entries[name]; ok {
+		// implied directories are replaced, later entries win as in tar extraction
+		e.children = old.children
+	} else {
+		a.addChild(name)
+	}
+	a.entries[name] = e
+}
+
+func (a *FS) addChild(name string) {
+	dir := path.Dir(name)
+	parent, ok := a.entries[dir]
+	if !ok {
+		parent = &entry{info: vfs.NewFileInfo(dir, true, 0, time.Time{})}
+		a.entries[dir] = parent
+		a.addChild(dir)
+	}
+	parent.children = append(parent.children, path.Base(name))
+}
+
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+func (a *FS) lookup(op, name string) (*entry, error) {
+	if err := a.index(); err != nil {
+		return nil, err
+	}
+	e, ok := a.entries[clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: syscall.EROFS}
+}
+
+// Close closes the archive, later operations fail with fs.ErrClosed
+func (a *FS) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+func (a *FS) Create(name string) (vfs.File, error) {
+	return nil, readOnly("create", name)
+}
+
+func (a *FS) Mkdir(name string, perm os.FileMode) error {
+	return readOnly("mkdir", name)
+}
+
+func (a *FS) MkdirAll(path string, perm os.FileMode) error {
+	return readOnly("mkdir", path)
+}
+
+func (a *FS) Open(name string) (vfs.File, error) {
+	e, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return a.newFile(name, e)
+}
+
+func (a *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, readOnly("open", name)
+	}
+	return a.Open(name)
+}
+
+func (a *FS) Remove(name string) error {
+	return readOnly("remove", name)
+}
+
+func (a *FS) RemoveAll(path string) error {
+	return readOnly("removeAll", path)
+}
+
+func (a *FS) Rename(oldname, newname string) error {
+	return readOnly("rename", oldname)
+}
+
+func (a *FS) Stat(name string) (os.FileInfo, error) {
+	e, err := a.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+func (a *FS) Name() string {
+	return "archive"
+}
+
+func (a *FS) Chmod(name string, mode os.FileMode) error {
+	return readOnly("chmod", name)
+}
+
+func (a *FS) Chown(name string, uid, gid int) error {
+	return readOnly("chown", name)
+}
+
+func (a *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
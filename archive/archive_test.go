@@ -0,0 +1,102 @@
+package archive_test
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/archive"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+var large = strings.Repeat("0123456789", 10000)
+
+func newVfs(t *testing.T) *vfs.Vfs {
+	v := vfs.New()
+	root := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(root, "src/a.txt", []byte("a"), 0644))
+	assert.NoError(t, afero.WriteFile(root, "src/dir/b.txt", []byte(large), 0644))
+	assert.NoError(t, root.MkdirAll("store", 0755))
+	assert.NoError(t, v.Mount("/", root))
+	mounted := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(mounted, "c.txt", []byte("c"), 0644))
+	assert.NoError(t, v.Mount("/src/m", mounted))
+	return v
+}
+
+func TestArchive(t *testing.T) {
+	for _, name := range []string{"x.zip", "x.tar", "x.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			v := newVfs(t)
+			assert.NoError(t, archive.Create(v, "/store/"+name, v, "/src"))
+			a, err := archive.New(v, "/store/"+name)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer a.Close()
+			assert.NoError(t, v.Mount("/mnt", a))
+
+			names, err := afero.ReadDir(v, "/mnt")
+			assert.NoError(t, err)
+			if assert.Len(t, names, 3) {
+				assert.Equal(t, "a.txt", names[0].Name())
+				assert.Equal(t, "dir", names[1].Name())
+				assert.True(t, names[1].IsDir())
+				assert.Equal(t, "m", names[2].Name())
+			}
+			b, err := afero.ReadFile(v, "/mnt/m/c.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, "c", string(b))
+			b, err = afero.ReadFile(v, "/mnt/dir/b.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, large, string(b))
+
+			f, err := v.Open("/mnt/dir/b.txt")
+			if assert.NoError(t, err) {
+				p := make([]byte, 4)
+				_, err = f.ReadAt(p, 50003)
+				assert.NoError(t, err)
+				assert.Equal(t, "3456", string(p))
+				_, err = f.Seek(-2, io.SeekEnd)
+				assert.NoError(t, err)
+				_, err = io.ReadFull(f, p[:2])
+				assert.NoError(t, err)
+				assert.Equal(t, "89", string(p[:2]))
+				f.Close()
+			}
+
+			assert.NoError(t, fstest.TestFS(vfs.NewIOFS(a), "a.txt", "dir/b.txt", "m/c.txt"))
+
+			assert.ErrorIs(t, afero.WriteFile(v, "/mnt/d.txt", []byte("d"), 0644), syscall.EROFS)
+			assert.ErrorIs(t, v.Remove("/mnt/a.txt"), syscall.EROFS)
+			_, err = v.Stat("/mnt/missing.txt")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
+
+func TestLazy(t *testing.T) {
+	v := newVfs(t)
+	a, err := archive.New(v, "/store/missing.zip")
+	assert.NoError(t, err, "archives are read on first use")
+	_, err = a.Stat("/")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// failures are not cached
+	assert.NoError(t, archive.Create(v, "/store/missing.zip", v, "/src"))
+	_, err = a.Stat("/a.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, a.Close())
+	_, err = a.Stat("/a.txt")
+	assert.ErrorIs(t, err, fs.ErrClosed)
+
+	_, err = archive.New(v, "/store/x.rar")
+	assert.ErrorIs(t, err, vfs.ErrNotSupported)
+	_, err = archive.New(v, "/store/x", archive.WithFormat(archive.Tar))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,211 @@
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// file is an opened entry. Stored entries are read with ReadAt on the
+// archive, compressed ones are streamed and restarted when seeking backwards.
+type file struct {
+	a    *FS
+	name string
+	e    *entry
+
+	ra     *io.SectionReader         // nil for compressed entries
+	open   func() (io.Reader, error) // streams compressed entries from their start
+	stream io.Reader
+	pos    int64 // of stream
+	offset int64
+	at     io.Reader // stream of ReadAt, reused for increasing offsets
+	atPos  int64
+
+	dir int // offset of Readdir
+}
+
+var _ vfs.File = (*file)(nil)
+
+func (a *FS) newFile(name string, e *entry) (*file, error) {
+	f := &file{a: a, name: name, e: e}
+	if e.info.IsDir() {
+		return f, nil
+	}
+	size := e.info.Size()
+	switch {
+	case e.zf != nil && e.zf.Method == 0:
+		off, err := e.zf.DataOffset()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		f.ra = io.NewSectionReader(a.f, off, size)
+	case e.zf != nil:
+		f.open = func() (io.Reader, error) {
+			return e.zf.Open()
+		}
+	case a.format == Tar:
+		f.ra = io.NewSectionReader(a.f, e.offset, size)
+	default:
+		f.open = func() (io.Reader, error) {
+			zr, err := gzip.NewReader(io.NewSectionReader(a.f, 0, a.size))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(io.Discard, zr, e.offset); err != nil {
+				return nil, err
+			}
+			return io.LimitReader(zr, size), nil
+		}
+	}
+	return f, nil
+}
+
+func (f *file) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *file) Close() error {
+	closeReader(f.stream)
+	closeReader(f.at)
+	f.stream, f.at = nil, nil
+	return nil
+}
+
+func closeReader(r io.Reader) {
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// seek returns s, or a new stream if s is nil or past off, positioned at off
+func (f *file) seek(s io.Reader, pos, off int64) (io.Reader, int64, error) {
+	if s == nil || pos > off {
+		closeReader(s)
+		var err error
+		if s, err = f.open(); err != nil {
+			return nil, 0, f.err("read", err)
+		}
+		pos = 0
+	}
+	n, err := io.CopyN(io.Discard, s, off-pos)
+	return s, pos + n, err
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.e.info.IsDir() {
+		return 0, f.err("read", syscall.EISDIR)
+	}
+	if f.ra != nil {
+		n, err := f.ra.ReadAt(p, f.offset)
+		f.offset += int64(n)
+		return n, err
+	}
+	var err error
+	if f.stream, f.pos, err = f.seek(f.stream, f.pos, f.offset); err != nil {
+		return 0, err
+	}
+	n, err := f.stream.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.e.info.IsDir() {
+		return 0, f.err("read", syscall.EISDIR)
+	}
+	if f.ra != nil {
+		return f.ra.ReadAt(p, off)
+	}
+	if off >= f.e.info.Size() {
+		return 0, io.EOF
+	}
+	var err error
+	if f.at, f.atPos, err = f.seek(f.at, f.atPos, off); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.at, p)
+	f.atPos += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.e.info.Size()
+	}
+	if offset < 0 {
+		return 0, f.err("seek", syscall.EINVAL)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.e.info.IsDir() {
+		return nil, f.err("readdir", syscall.ENOTDIR)
+	}
+	rest := f.e.children[f.dir:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(rest) {
+			rest = rest[:count]
+		}
+	}
+	f.dir += len(rest)
+	dir := clean(f.name)
+	infos := make([]os.FileInfo, len(rest))
+	for i, name := range rest {
+		infos[i] = f.a.entries[clean(dir+"/"+name)].info
+	}
+	return infos, nil
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	infos, err := f.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.e.info, nil
+}
+
+func (f *file) Sync() error {
+	return nil
+}
+
+func (f *file) Truncate(size int64) error {
+	return f.err("truncate", syscall.EROFS)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, f.err("write", syscall.EROFS)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	return 0, f.err("write", syscall.EROFS)
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return 0, f.err("write", syscall.EROFS)
+}
@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+// Write writes the tree of fsys below root to w as an archive of format, with
+// names relative to root. Mount points below root of a Vfs are included.
+func Write(w io.Writer, format Format, fsys vfs.FS, root string) error {
+	var add func(name string, info os.FileInfo, body io.Reader) error
+	var closers []io.Closer
+	switch format {
+	case Zip:
+		zw := zip.NewWriter(w)
+		closers = append(closers, zw)
+		add = func(name string, info os.FileInfo, body io.Reader) error {
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			hdr.Name, hdr.Modified = name, modTime(info)
+			if !info.IsDir() {
+				hdr.Method = zip.Deflate
+			}
+			fw, err := zw.CreateHeader(hdr)
+			if err == nil && body != nil {
+				_, err = io.Copy(fw, body)
+			}
+			return err
+		}
+	case Tar, TarGzip:
+		if format == TarGzip {
+			zw := gzip.NewWriter(w)
+			closers = append(closers, zw)
+			w = zw
+		}
+		tw := tar.NewWriter(w)
+		closers = append([]io.Closer{tw}, closers...)
+		add = func(name string, info os.FileInfo, body io.Reader) error {
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name, hdr.ModTime = name, modTime(info)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if body != nil {
+				_, err = io.Copy(tw, body)
+			}
+			return err
+		}
+	default:
+		return &fs.PathError{Op: "archive", Path: root, Err: vfs.ErrNotSupported}
+	}
+
+	root = path.Clean("/" + filepath.ToSlash(root))
+	err := afero.Walk(fsys, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(p), root), "/")
+		if name == "" {
+			return nil
+		}
+		if info.IsDir() {
+			return add(name+"/", info, nil)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return add(name, info, f)
+	})
+	for _, c := range closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// modTime returns the modification time of info, directories leading to
+// mount points have none
+func modTime(info os.FileInfo) time.Time {
+	if info.ModTime().IsZero() {
+		return time.Unix(0, 0)
+	}
+	return info.ModTime()
+}
+
+// Create writes the tree of src below root to the archive name of dst, the
+// format is detected from the extension of name
+func Create(dst vfs.FS, name string, src vfs.FS, root string) error {
+	format, ok := FormatOf(name)
+	if !ok {
+		return &fs.PathError{Op: "archive", Path: name, Err: vfs.ErrNotSupported}
+	}
+	f, err := dst.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, format, src, root); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,186 @@
+// Package audit records mutating Vfs operations to a Sink
+package audit
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// Outcomes of a Record
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Record of an audited operation
+type Record struct {
+	Time     time.Time `json:"time"`
+	Identity string    `json:"identity,omitempty"`
+	Groups   []string  `json:"groups,omitempty"`
+	Op       string    `json:"op"`
+	Path     string    `json:"path"`
+	Mount    string    `json:"mount,omitempty"`
+	NewPath  string    `json:"newPath,omitempty"`
+	NewMount string    `json:"newMount,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	// Completed is false for the record of opening a file for writing, which
+	// is recorded again once completed when the file is closed
+	Completed bool `json:"completed"`
+	// Bytes written to files opened by OpCreate and OpOpenFile
+	Bytes int64 `json:"bytes,omitempty"`
+	// ExpiresAt of urls returned by OpPreSignedURL
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Sink stores records
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+}
+
+type skipKey struct{}
+
+// skip returns a copy of ctx whose operations are not audited. The context
+// passed to Sink.Write is marked so, for sinks storing records on the audited
+// Vfs not to audit themselves, and no other caller can turn auditing off.
+func skip(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipKey{}, true)
+}
+
+type Option func(l *Logger)
+
+// WithErrorHandler receives errors of the sink, they are dropped by default
+func WithErrorHandler(fn func(error)) Option {
+	return func(l *Logger) {
+		l.onError = fn
+	}
+}
+
+// Logger audits operations
+type Logger struct {
+	sink    Sink
+	onError func(error)
+	now     func() time.Time
+}
+
+func New(sink Sink, opts ...Option) *Logger {
+	l := &Logger{sink: sink, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Interceptor records mutating operations. Files opened for writing are
+// recorded when opened, and again when closed with the number of bytes written.
+func (l *Logger) Interceptor() vfs.Interceptor {
+	return func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		if !mutating(op) || ctx.Value(skipKey{}) != nil {
+			return next(ctx, op)
+		}
+		r := &Record{Time: l.now().UTC(), Op: op.Name, Path: op.Path}
+		if id, ok := vfs.IdentityFromContext(ctx); ok {
+			r.Identity = id.ID
+			r.Groups = id.Groups
+		}
+		if op.MountPoint != nil {
+			r.Mount = op.MountPoint.GetPrefix()
+		}
+		if op.Name == vfs.OpRename || op.Name == vfs.OpCopy {
+			r.NewPath = op.NewPath
+			if op.NewMountPoint != nil {
+				r.NewMount = op.NewMountPoint.GetPrefix()
+			}
+		}
+		err := next(ctx, op)
+		if op.Name == vfs.OpPreSignedURL {
+			r.ExpiresAt = expiresAt(r.Time, op)
+		}
+		if err == nil && op.File != nil {
+			opened := *r
+			l.write(ctx, &opened, nil)
+			op.File = &file{File: op.File, l: l, r: r}
+			return nil
+		}
+		r.Completed = true
+		l.write(ctx, r, err)
+		return err
+	}
+}
+
+func (l *Logger) write(ctx context.Context, r *Record, err error) {
+	r.Outcome = Success
+	if err != nil {
+		r.Outcome = Failure
+		r.Error = err.Error()
+	}
+	if err := l.sink.Write(skip(ctx), r); err != nil && l.onError != nil {
+		l.onError(err)
+	}
+}
+
+func mutating(op *vfs.Operation) bool {
+	switch op.Name {
+	case vfs.OpCreate, vfs.OpMkdir, vfs.OpMkdirAll, vfs.OpRemove, vfs.OpRemoveAll, vfs.OpRename,
+		vfs.OpChmod, vfs.OpChown, vfs.OpChtimes, vfs.OpPreSignedURL, vfs.OpCopy, vfs.OpSetMetadata, vfs.OpRestoreVersion:
+		return true
+	case vfs.OpOpenFile:
+		return op.Flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	}
+	return false
+}
+
+// expiresAt prefers the expiration reported by the backend over the requested one
+func expiresAt(t time.Time, op *vfs.Operation) *time.Time {
+	var d *time.Duration
+	if op.Link != nil && op.Link.Expiration != nil {
+		d = op.Link.Expiration
+	} else if len(op.LinkOptions) > 0 && op.LinkOptions[0].Expire != nil {
+		d = op.LinkOptions[0].Expire
+	}
+	if d == nil {
+		return nil
+	}
+	e := t.Add(*d)
+	return &e
+}
+
+// file records the completion of the operation on close
+type file struct {
+	vfs.File
+	l       *Logger
+	r       *Record
+	written int64
+	once    sync.Once
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	atomic.AddInt64(&f.written, int64(n))
+	return n, err
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	atomic.AddInt64(&f.written, int64(n))
+	return n, err
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Close() error {
+	err := f.File.Close()
+	f.once.Do(func() {
+		f.r.Bytes = atomic.LoadInt64(&f.written)
+		f.r.Completed = true
+		f.l.write(context.Background(), f.r, err)
+	})
+	return err
+}
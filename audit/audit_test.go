@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+type linkFs struct {
+	vfs.FS
+}
+
+func (linkFs) PreSignedURL(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	return &vfs.Link{URL: name, Expiration: args[0].Expire}, nil
+}
+
+func (linkFs) PublicUrl(ctx context.Context, name string) (*vfs.Link, error) {
+	return &vfs.Link{URL: name}, nil
+}
+
+func (linkFs) InternalUrl(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	return &vfs.Link{URL: name}, nil
+}
+
+func readRecords(t *testing.T, fsys vfs.FS, name string) []Record {
+	f, err := fsys.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	var res []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		res = append(res, r)
+	}
+	return res
+}
+
+func TestInterceptor(t *testing.T) {
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/s3", linkFs{afero.NewMemMapFs()}))
+
+	sink := NewJSONLSink(v, "/var/audit/audit.jsonl", 0)
+	v.Use(New(sink).Interceptor())
+
+	ctx := vfs.WithIdentity(context.Background(), &vfs.Identity{ID: "bob", Groups: []string{"staff"}})
+	f, err := v.OpenFileContext(ctx, "/a.txt", os.O_CREATE|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	_, err = v.OpenContext(ctx, "/a.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, v.RenameContext(ctx, "/a.txt", "/b.txt"))
+	assert.Error(t, v.RemoveContext(ctx, "/a.txt"))
+	expire := time.Hour
+	_, err = v.PreSignedURL(ctx, "/s3/b.txt", vfs.LinkOptions{Expire: &expire})
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Close())
+
+	records := readRecords(t, v, "/var/audit/audit.jsonl")
+	if assert.Len(t, records, 5) {
+		// the open is recorded before any write, its completion on close
+		assert.Equal(t, vfs.OpOpenFile, records[0].Op)
+		assert.Equal(t, "/a.txt", records[0].Path)
+		assert.Equal(t, Success, records[0].Outcome)
+		assert.False(t, records[0].Completed)
+		assert.Equal(t, int64(0), records[0].Bytes)
+
+		assert.Equal(t, vfs.OpOpenFile, records[1].Op)
+		assert.Equal(t, "bob", records[1].Identity)
+		assert.Equal(t, []string{"staff"}, records[1].Groups)
+		assert.Equal(t, "/a.txt", records[1].Path)
+		assert.Equal(t, "/", records[1].Mount)
+		assert.Equal(t, int64(5), records[1].Bytes)
+		assert.Equal(t, Success, records[1].Outcome)
+		assert.True(t, records[1].Completed)
+
+		assert.Equal(t, vfs.OpRename, records[2].Op)
+		assert.Equal(t, "/b.txt", records[2].NewPath)
+		assert.True(t, records[2].Completed)
+
+		assert.Equal(t, vfs.OpRemove, records[3].Op)
+		assert.Equal(t, Failure, records[3].Outcome)
+		assert.NotEmpty(t, records[3].Error)
+
+		assert.Equal(t, vfs.OpPreSignedURL, records[4].Op)
+		assert.Equal(t, "/s3", records[4].Mount)
+		if assert.NotNil(t, records[4].ExpiresAt) {
+			assert.Equal(t, time.Hour, records[4].ExpiresAt.Sub(records[4].Time))
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	sink := NewJSONLSink(fsys, "/audit.jsonl", 100)
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sink.Write(context.Background(), &Record{Op: vfs.OpRemove, Path: "/some/long/path/to/a/file.txt", Outcome: Success}))
+	}
+	assert.NoError(t, sink.Close())
+
+	entries, err := afero.ReadDir(fsys, "/")
+	assert.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"audit-20220101T000001.000000000.jsonl", "audit-20220101T000002.000000000.jsonl", "audit.jsonl"}, names)
+	info, err := fsys.Stat("/audit-20220101T000001.000000000.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0444), info.Mode().Perm())
+	assert.Len(t, readRecords(t, fsys, "/audit.jsonl"), 1)
+}
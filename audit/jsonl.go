@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// JSONLSink appends records as json lines to a file of a FS, which can be the
+// audited Vfs itself. Rotated files are renamed with a timestamp suffix and
+// made read only.
+type JSONLSink struct {
+	mu      sync.Mutex
+	fsys    vfs.ContextFS
+	name    string
+	maxSize int64
+	f       vfs.File
+	size    int64
+	now     func() time.Time
+}
+
+var _ Sink = (*JSONLSink)(nil)
+
+// NewJSONLSink creates a sink writing to name of fsys, rotated once it would
+// exceed maxSize bytes. Zero maxSize disables rotation.
+func NewJSONLSink(fsys vfs.FS, name string, maxSize int64) *JSONLSink {
+	return &JSONLSink{fsys: vfs.AsContextFS(fsys), name: path.Clean("/" + name), maxSize: maxSize, now: time.Now}
+}
+
+func (s *JSONLSink) Write(ctx context.Context, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	ctx = skip(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil && s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(ctx); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.open(ctx); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// Rotate closes the current file and renames it
+func (s *JSONLSink) Rotate(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotate(skip(ctx))
+}
+
+// Close closes the current file
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *JSONLSink) open(ctx context.Context) error {
+	if err := s.fsys.MkdirAllContext(ctx, path.Dir(s.name), 0755); err != nil {
+		return err
+	}
+	f, err := s.fsys.OpenFileContext(ctx, s.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *JSONLSink) rotate(ctx context.Context) error {
+	if s.f != nil {
+		if err := s.f.Close(); err != nil {
+			return err
+		}
+		s.f = nil
+	}
+	ext := path.Ext(s.name)
+	rotated := strings.TrimSuffix(s.name, ext) + "-" + s.now().UTC().Format("20060102T150405.000000000") + ext
+	if err := s.fsys.RenameContext(ctx, s.name, rotated); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// not all backends support modes
+	s.fsys.ChmodContext(ctx, rotated, 0444)
+	s.size = 0
+	return nil
+}
@@ -0,0 +1,520 @@
+// Package cas is a deduplicating FS storing file bodies as content addressed
+// chunks in another FS. Files are manifests listing their chunks, so equal
+// content is stored once and copies only duplicate the manifest.
+//
+// The store is laid out as
+//
+//	/tree/<path>           manifests and directories of the FS
+//	/chunks/<ab>/<sha256>  chunk bodies
+//	/refs/<ab>/<sha256>    number of manifests referencing a chunk
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+const (
+	treeDir  = "/tree"
+	chunkDir = "/chunks"
+	refDir   = "/refs"
+)
+
+// chunkRef is a chunk of a manifest
+type chunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+type manifest struct {
+	Size   int64      `json:"size"`
+	Chunks []chunkRef `json:"chunks"`
+}
+
+// FS stores files in a store FS, deduplicating their chunks. Reference counts
+// are kept in the store, chunks are removed once no manifest references them.
+// A store must be used by a single FS.
+type FS struct {
+	store         vfs.FS
+	min, avg, max int
+	mu            sync.Mutex     // guards reference counts
+	pending       map[string]int // references of chunks held by writers which did not close yet
+}
+
+var (
+	_ vfs.FS     = (*FS)(nil)
+	_ vfs.Copier = (*FS)(nil)
+)
+
+type Option func(c *FS)
+
+// WithChunkSize sets the minimum, average and maximum size of chunks, 512KiB, 1MiB and 4MiB by default
+func WithChunkSize(min, avg, max int) Option {
+	return func(c *FS) {
+		c.min, c.avg, c.max = min, avg, max
+	}
+}
+
+// New returns a FS storing its files in store
+func New(store vfs.FS, opts ...Option) (*FS, error) {
+	c := &FS{store: store, min: 512 << 10, avg: 1 << 20, max: 4 << 20, pending: map[string]int{}}
+	for _, o := range opts {
+		o(c)
+	}
+	if c.min <= 0 || c.min > c.avg || c.avg > c.max {
+		return nil, &fs.PathError{Op: "cas", Path: store.Name(), Err: syscall.EINVAL}
+	}
+	for _, dir := range []string{treeDir, chunkDir, refDir} {
+		if err := store.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func treePath(name string) string {
+	return path.Join(treeDir, path.Clean("/"+filepath.ToSlash(name)))
+}
+
+func chunkPath(hash string) string {
+	return path.Join(chunkDir, hash[:2], hash)
+}
+
+func refPath(hash string) string {
+	return path.Join(refDir, hash[:2], hash)
+}
+
+// pathError replaces the store path of errors by name
+func pathError(name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+// readManifest reads the manifest at p of the store, empty files are empty manifests
+func (c *FS) readManifest(p string) (*manifest, error) {
+	b, err := afero.ReadFile(c.store, p)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if len(b) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: err}
+	}
+	return m, nil
+}
+
+func (c *FS) writeManifest(p string, m *manifest, flag int, perm os.FileMode) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := c.store.OpenFile(p, flag|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// putChunk stores data unless a chunk of equal content exists, the writer
+// holds a reference to it until release
+func (c *FS) putChunk(data []byte) (chunkRef, error) {
+	sum := sha256.Sum256(data)
+	ref := chunkRef{Hash: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := chunkPath(ref.Hash)
+	if _, err := c.store.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		if err := c.store.MkdirAll(path.Dir(p), 0755); err != nil {
+			return ref, err
+		}
+		if err := afero.WriteFile(c.store, p, data, 0644); err != nil {
+			return ref, err
+		}
+	} else if err != nil {
+		return ref, err
+	}
+	c.pending[ref.Hash]++
+	return ref, nil
+}
+
+func (c *FS) readRef(hash string) (int, error) {
+	b, err := afero.ReadFile(c.store, refPath(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(b))
+}
+
+// addRefs adds delta to the reference counts of chunks, chunks without
+// references are removed. c.mu must be held.
+func (c *FS) addRefs(chunks []chunkRef, delta int) error {
+	for _, ch := range chunks {
+		n, err := c.readRef(ch.Hash)
+		if err != nil {
+			return err
+		}
+		n += delta
+		p := refPath(ch.Hash)
+		if n > 0 {
+			if err := c.store.MkdirAll(path.Dir(p), 0755); err != nil {
+				return err
+			}
+			if err := afero.WriteFile(c.store, p, []byte(strconv.Itoa(n)), 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.store.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if c.pending[ch.Hash] == 0 {
+			if err := c.store.Remove(chunkPath(ch.Hash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commit writes m to the manifest at p and references its chunks instead of
+// those of the manifest it replaces, then releases the chunks held by a writer
+func (c *FS) commit(p string, m *manifest, flag int, perm os.FileMode, held []chunkRef) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the manifest replaced is read under c.mu, it may have been replaced since p was opened
+	var old *manifest
+	if info, err := c.store.Stat(p); err == nil && !info.IsDir() {
+		if old, err = c.readManifest(p); err != nil {
+			c.unhold(held)
+			return err
+		}
+	}
+	if err := c.writeManifest(p, m, flag, perm); err != nil {
+		c.unhold(held)
+		return err
+	}
+	if err := c.addRefs(m.Chunks, 1); err != nil {
+		return err
+	}
+	if err := c.unhold(held); err != nil {
+		return err
+	}
+	if old != nil {
+		return c.addRefs(old.Chunks, -1)
+	}
+	return nil
+}
+
+// hold holds chunks for an open file, so that they are kept until it is
+// closed. c.mu must be held.
+func (c *FS) hold(chunks []chunkRef) {
+	for _, ch := range chunks {
+		c.pending[ch.Hash]++
+	}
+}
+
+// unhold releases chunks held by an open file, removing those without
+// references. c.mu must be held.
+func (c *FS) unhold(held []chunkRef) error {
+	for _, ch := range held {
+		if c.pending[ch.Hash]--; c.pending[ch.Hash] > 0 {
+			continue
+		}
+		delete(c.pending, ch.Hash)
+		n, err := c.readRef(ch.Hash)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if err := c.store.Remove(chunkPath(ch.Hash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// release drops the chunks of manifests. c.mu must be held.
+func (c *FS) release(ms ...*manifest) error {
+	for _, m := range ms {
+		if err := c.addRefs(m.Chunks, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GC removes chunks which are neither referenced by manifests nor held by
+// writers, e.g. left by writers which were not closed, and returns their number
+func (c *FS) GC(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	err := afero.Walk(c.store, chunkDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hash := path.Base(filepath.ToSlash(p))
+		if c.pending[hash] > 0 {
+			return nil
+		}
+		if _, err := c.store.Stat(refPath(hash)); !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := c.store.Remove(p); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// fileInfo is the info of a manifest with the size of its file
+type fileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i *fileInfo) Size() int64 {
+	return i.size
+}
+
+// stat returns info of the manifest or directory p of the store
+func (c *FS) stat(p string, info os.FileInfo) (os.FileInfo, error) {
+	if info.IsDir() {
+		return info, nil
+	}
+	m, err := c.readManifest(p)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{FileInfo: info, size: m.Size}, nil
+}
+
+func (c *FS) Create(name string) (vfs.File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (c *FS) Mkdir(name string, perm os.FileMode) error {
+	return pathError(name, c.store.Mkdir(treePath(name), perm))
+}
+
+func (c *FS) MkdirAll(name string, perm os.FileMode) error {
+	return pathError(name, c.store.MkdirAll(treePath(name), perm))
+}
+
+func (c *FS) Open(name string) (vfs.File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	p := treePath(name)
+	info, err := c.store.Stat(p)
+	if err == nil && info.IsDir() {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+		f, err := c.store.Open(p)
+		if err != nil {
+			return nil, pathError(name, err)
+		}
+		return &dirFile{File: f, c: c, p: p, name: name}, nil
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if err != nil {
+			return nil, pathError(name, err)
+		}
+		// the chunks must outlive the replacement of the manifest while the file is open
+		c.mu.Lock()
+		m, err := c.readManifest(p)
+		if err == nil {
+			c.hold(m.Chunks)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return nil, pathError(name, err)
+		}
+		return &readFile{content: content{c: c, chunks: m.Chunks, size: m.Size}, name: name, info: &fileInfo{FileInfo: info, size: m.Size}}, nil
+	}
+	return c.openWriter(name, p, flag, perm)
+}
+
+func (c *FS) Remove(name string) error {
+	p := treePath(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := c.store.Stat(p)
+	if err != nil {
+		return pathError(name, err)
+	}
+	var m *manifest
+	if !info.IsDir() {
+		if m, err = c.readManifest(p); err != nil {
+			return pathError(name, err)
+		}
+	}
+	if err := c.store.Remove(p); err != nil {
+		return pathError(name, err)
+	}
+	if m != nil {
+		return c.release(m)
+	}
+	return nil
+}
+
+func (c *FS) RemoveAll(name string) error {
+	p := treePath(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ms []*manifest
+	err := afero.Walk(c.store, p, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		m, err := c.readManifest(p)
+		if err == nil {
+			ms = append(ms, m)
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return pathError(name, err)
+	}
+	if p == treeDir {
+		// keep the root
+		infos, err := afero.ReadDir(c.store, p)
+		if err != nil {
+			return pathError(name, err)
+		}
+		for _, info := range infos {
+			if err := c.store.RemoveAll(path.Join(p, info.Name())); err != nil {
+				return pathError(name, err)
+			}
+		}
+	} else if err := c.store.RemoveAll(p); err != nil {
+		return pathError(name, err)
+	}
+	return c.release(ms...)
+}
+
+func (c *FS) Rename(oldname, newname string) error {
+	oldp, newp := treePath(oldname), treePath(newname)
+	if oldp == newp {
+		_, err := c.store.Stat(oldp)
+		return pathError(oldname, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the chunks of a replaced file are released
+	var replaced *manifest
+	if info, err := c.store.Stat(newp); err == nil && !info.IsDir() {
+		if replaced, err = c.readManifest(newp); err != nil {
+			return pathError(newname, err)
+		}
+	}
+	if err := c.store.Rename(oldp, newp); err != nil {
+		return pathError(oldname, err)
+	}
+	if replaced != nil {
+		return c.release(replaced)
+	}
+	return nil
+}
+
+// Copy duplicates the manifest of src, directories are not supported
+func (c *FS) Copy(ctx context.Context, src, dest string) error {
+	srcp, destp := treePath(src), treePath(dest)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := c.store.Stat(srcp)
+	if err != nil {
+		return pathError(src, err)
+	}
+	if info.IsDir() {
+		return vfs.ErrNotSupported
+	}
+	if srcp == destp {
+		return nil
+	}
+	m, err := c.readManifest(srcp)
+	if err != nil {
+		return pathError(src, err)
+	}
+	var old *manifest
+	if info, err := c.store.Stat(destp); err == nil {
+		if info.IsDir() {
+			return &fs.PathError{Op: "copy", Path: dest, Err: syscall.EISDIR}
+		}
+		if old, err = c.readManifest(destp); err != nil {
+			return pathError(dest, err)
+		}
+	}
+	if err := c.writeManifest(destp, m, os.O_CREATE, info.Mode().Perm()); err != nil {
+		return pathError(dest, err)
+	}
+	if err := c.addRefs(m.Chunks, 1); err != nil {
+		return err
+	}
+	if old != nil {
+		return c.release(old)
+	}
+	return nil
+}
+
+func (c *FS) Stat(name string) (os.FileInfo, error) {
+	p := treePath(name)
+	info, err := c.store.Stat(p)
+	if err != nil {
+		return nil, pathError(name, err)
+	}
+	info, err = c.stat(p, info)
+	return info, pathError(name, err)
+}
+
+func (c *FS) Name() string {
+	return "cas"
+}
+
+func (c *FS) Chmod(name string, mode os.FileMode) error {
+	return pathError(name, c.store.Chmod(treePath(name), mode))
+}
+
+func (c *FS) Chown(name string, uid, gid int) error {
+	return pathError(name, c.store.Chown(treePath(name), uid, gid))
+}
+
+func (c *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return pathError(name, c.store.Chtimes(treePath(name), atime, mtime))
+}
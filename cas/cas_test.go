@@ -0,0 +1,193 @@
+package cas_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/cas"
+	"github.com/goxiaoy/vfs/vfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFS(t *testing.T, store vfs.FS) *cas.FS {
+	c, err := cas.New(store, cas.WithChunkSize(1<<10, 4<<10, 16<<10))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+		return newFS(t, afero.NewMemMapFs())
+	})
+}
+
+// chunks returns the number of chunks in store
+func chunks(t *testing.T, store vfs.FS) int {
+	n := 0
+	assert.NoError(t, afero.Walk(store, "/chunks", func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return err
+	}))
+	return n
+}
+
+func TestDedup(t *testing.T) {
+	store := afero.NewMemMapFs()
+	c := newFS(t, store)
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	assert.NoError(t, c.MkdirAll("/tenants/a", 0755))
+	assert.NoError(t, c.MkdirAll("/tenants/b", 0755))
+	assert.NoError(t, afero.WriteFile(c, "/tenants/a/1.bin", data, 0644))
+	n := chunks(t, store)
+	assert.Greater(t, n, 10)
+
+	// an insertion only changes the chunks around it
+	edited := append(append(append([]byte{}, data[:100<<10]...), "inserted"...), data[100<<10:]...)
+	assert.NoError(t, afero.WriteFile(c, "/tenants/b/1.bin", edited, 0644))
+	assert.LessOrEqual(t, chunks(t, store), n+3)
+
+	b, err := afero.ReadFile(c, "/tenants/b/1.bin")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(edited, b))
+	infos, err := afero.ReadDir(c, "/tenants/a")
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, int64(len(data)), infos[0].Size())
+	}
+
+	// copies share all chunks
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", c))
+	before := chunks(t, store)
+	assert.NoError(t, v.Copy(context.Background(), "/tenants/a/1.bin", "/tenants/b/2.bin"))
+	assert.Equal(t, before, chunks(t, store))
+	b, err = afero.ReadFile(v, "/tenants/b/2.bin")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, b))
+
+	// chunks are removed with their last reference
+	assert.NoError(t, c.Remove("/tenants/a/1.bin"))
+	assert.Equal(t, before, chunks(t, store))
+	assert.NoError(t, c.RemoveAll("/tenants"))
+	assert.Equal(t, 0, chunks(t, store))
+}
+
+func TestGC(t *testing.T) {
+	store := afero.NewMemMapFs()
+	c := newFS(t, store)
+	data := make([]byte, 64<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	f, err := c.Create("/abandoned.bin")
+	assert.NoError(t, err)
+	_, err = f.Write(data)
+	assert.NoError(t, err)
+	n := chunks(t, store)
+	assert.Greater(t, n, 0)
+	removed, err := c.GC(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed, "chunks of open writers are kept")
+
+	// as if the process had stopped before closing the file
+	c = newFS(t, store)
+	removed, err = c.GC(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, n, removed)
+	info, err := c.Stat("/abandoned.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
+
+func TestOverlappingWriters(t *testing.T) {
+	store := afero.NewMemMapFs()
+	c := newFS(t, store)
+	data := make([]byte, 64<<10)
+	rand.New(rand.NewSource(3)).Read(data)
+	assert.NoError(t, afero.WriteFile(c, "/a", data, 0644))
+	assert.NoError(t, afero.WriteFile(c, "/b", data, 0644))
+
+	// both writers replace the manifest of /a, its chunks are released once
+	w1, err := c.Create("/a")
+	assert.NoError(t, err)
+	w2, err := c.Create("/a")
+	assert.NoError(t, err)
+	_, err = w1.Write([]byte("one"))
+	assert.NoError(t, err)
+	_, err = w2.Write([]byte("two"))
+	assert.NoError(t, err)
+	assert.NoError(t, w1.Close())
+	assert.NoError(t, w2.Close())
+
+	b, err := afero.ReadFile(c, "/b")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, b))
+	b, err = afero.ReadFile(c, "/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(b))
+
+	// an appending writer keeps the chunks it started from
+	w, err := c.OpenFile("/b", os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	assert.NoError(t, afero.WriteFile(c, "/b", []byte("replaced"), 0644))
+	_, err = w.Write([]byte("tail"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	b, err = afero.ReadFile(c, "/b")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(append(data, "tail"...), b))
+}
+
+func TestReadWhileOverwriting(t *testing.T) {
+	store := afero.NewMemMapFs()
+	c := newFS(t, store)
+	data := make([]byte, 64<<10)
+	rand.New(rand.NewSource(4)).Read(data)
+	assert.NoError(t, afero.WriteFile(c, "/a", data, 0644))
+
+	// a reader keeps the chunks of the content it opened
+	r, err := c.Open("/a")
+	assert.NoError(t, err)
+	assert.NoError(t, afero.WriteFile(c, "/a", []byte("replaced"), 0644))
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, b))
+	assert.NoError(t, r.Close())
+	assert.Equal(t, 1, chunks(t, store))
+
+	// errors name the file, not the chunks of the store
+	r, err = c.Open("/a")
+	assert.NoError(t, err)
+	defer r.Close()
+	assert.NoError(t, store.RemoveAll("/chunks"))
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.NotContains(t, err.Error(), "/chunks")
+	assert.Contains(t, err.Error(), "/a")
+}
+
+func TestRenameOntoItself(t *testing.T) {
+	c := newFS(t, afero.NewMemMapFs())
+	assert.NoError(t, afero.WriteFile(c, "/a", []byte("content"), 0644))
+	assert.NoError(t, c.Rename("/a", "/a"))
+	assert.NoError(t, c.Rename("/a", "a"))
+	b, err := afero.ReadFile(c, "/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(b))
+	assert.NoError(t, c.Copy(context.Background(), "/a", "/a"))
+	b, err = afero.ReadFile(c, "/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(b))
+}
@@ -0,0 +1,65 @@
+package cas
+
+// gear maps bytes to the random values of the rolling gear hash, the table is
+// fixed so that equal content is always cut at the same boundaries
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gear[i] = z ^ z>>31
+	}
+}
+
+// chunker cuts a stream into content-defined chunks, a boundary is placed
+// where the gear hash of the last bytes matches the mask, so that inserting
+// data only changes the chunks around it
+type chunker struct {
+	min, max int
+	mask     uint64
+	buf      []byte
+	h        uint64
+}
+
+func newChunker(min, avg, max int) *chunker {
+	bits := 0
+	for 1<<(bits+1) <= avg {
+		bits++
+	}
+	return &chunker{min: min, max: max, mask: 1<<bits - 1}
+}
+
+// write buffers p and calls emit with every completed chunk
+func (c *chunker) write(p []byte, emit func(chunk []byte) error) error {
+	for len(p) > 0 {
+		n, cut := len(c.buf), false
+		i := 0
+		for i < len(p) {
+			c.h = c.h<<1 + gear[p[i]]
+			i++
+			n++
+			if n >= c.max || n >= c.min && c.h&c.mask == 0 {
+				cut = true
+				break
+			}
+		}
+		c.buf = append(c.buf, p[:i]...)
+		p = p[i:]
+		if cut {
+			if err := emit(c.buf); err != nil {
+				return err
+			}
+			c.reset()
+		}
+	}
+	return nil
+}
+
+func (c *chunker) reset() {
+	c.buf, c.h = c.buf[:0], 0
+}
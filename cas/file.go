@@ -0,0 +1,446 @@
+package cas
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// content reads the chunks of a file followed by a tail not chunked yet
+type content struct {
+	c      *FS
+	chunks []chunkRef
+	tail   []byte
+	size   int64
+
+	cur     int // index of the opened chunk
+	curFile vfs.File
+}
+
+func (r *content) close() error {
+	if r.curFile == nil {
+		return nil
+	}
+	err := r.curFile.Close()
+	r.curFile = nil
+	return err
+}
+
+func (r *content) readAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	start := int64(0)
+	for i := 0; i < len(r.chunks) && n < len(p); i++ {
+		ch := r.chunks[i]
+		if off+int64(n) >= start+ch.Size {
+			start += ch.Size
+			continue
+		}
+		if r.curFile == nil || r.cur != i {
+			r.close()
+			f, err := r.c.store.Open(chunkPath(ch.Hash))
+			if err != nil {
+				return n, storeError(err)
+			}
+			r.cur, r.curFile = i, f
+		}
+		want := min64(len(p)-n, start+ch.Size-off-int64(n))
+		m, err := r.curFile.ReadAt(p[n:n+want], off+int64(n)-start)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, storeError(err)
+		}
+		if m < want {
+			return n, io.ErrUnexpectedEOF
+		}
+		start += ch.Size
+	}
+	if n < len(p) && off+int64(n) < r.size {
+		n += copy(p[n:], r.tail[off+int64(n)-start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// storeError drops the store path of err, which is not a path of the FS
+func storeError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+func min64(a int, b int64) int {
+	if int64(a) < b {
+		return a
+	}
+	return int(b)
+}
+
+// readFile is a file opened for reading, it holds its chunks until closed
+type readFile struct {
+	content
+	name   string
+	info   os.FileInfo
+	offset int64
+	closed bool
+}
+
+var _ vfs.File = (*readFile)(nil)
+
+func (f *readFile) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *readFile) Close() error {
+	if f.closed {
+		return f.err("close", fs.ErrClosed)
+	}
+	f.closed = true
+	err := f.close()
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	if unholdErr := f.c.unhold(f.chunks); err == nil && unholdErr != nil {
+		err = f.err("close", storeError(unholdErr))
+	}
+	return err
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, f.err("read", fs.ErrClosed)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		err = f.err("read", err)
+	}
+	return n, err
+}
+
+func (f *readFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, f.err("read", fs.ErrClosed)
+	}
+	n, err := f.readAt(p, off)
+	if err != nil && err != io.EOF {
+		err = f.err("read", err)
+	}
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, f.err("seek", syscall.EINVAL)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readFile) Name() string {
+	return f.name
+}
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, f.err("readdir", syscall.ENOTDIR)
+}
+
+func (f *readFile) Readdirnames(n int) ([]string, error) {
+	return nil, f.err("readdir", syscall.ENOTDIR)
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *readFile) Sync() error {
+	return nil
+}
+
+func (f *readFile) Truncate(size int64) error {
+	return f.err("truncate", syscall.EBADF)
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, f.err("write", syscall.EBADF)
+}
+
+func (f *readFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, f.err("write", syscall.EBADF)
+}
+
+func (f *readFile) WriteString(s string) (int, error) {
+	return 0, f.err("write", syscall.EBADF)
+}
+
+// dirFile lists the sizes of files instead of the sizes of their manifests
+type dirFile struct {
+	vfs.File
+	c    *FS
+	p    string
+	name string
+}
+
+func (d *dirFile) Name() string {
+	return d.name
+}
+
+func (d *dirFile) Stat() (os.FileInfo, error) {
+	return d.c.Stat(d.name)
+}
+
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	for i, info := range infos {
+		if infos[i], err = d.c.stat(d.p+"/"+info.Name(), info); err != nil {
+			return nil, pathError(d.name, err)
+		}
+	}
+	return infos, err
+}
+
+// writeFile chunks written data, the manifest is replaced on Close. Data is
+// appended at the end of the file, writes at other offsets are not supported.
+type writeFile struct {
+	content
+	name    string
+	p       string
+	perm    os.FileMode
+	chunker *chunker
+	held    []chunkRef // chunks stored or kept by this writer
+	offset  int64
+	append  bool
+	closed  bool
+}
+
+var _ vfs.File = (*writeFile)(nil)
+
+func (c *FS) openWriter(name, p string, flag int, perm os.FileMode) (*writeFile, error) {
+	// the store checks existence, exclusivity and permissions
+	sf, err := c.store.OpenFile(p, flag&(os.O_CREATE|os.O_EXCL)|os.O_WRONLY, perm)
+	if err != nil {
+		return nil, pathError(name, err)
+	}
+	info, err := sf.Stat()
+	sf.Close()
+	if err != nil {
+		return nil, pathError(name, err)
+	}
+	// the chunks kept from the old content must outlive its replacement by other writers
+	c.mu.Lock()
+	old, err := c.readManifest(p)
+	if err == nil {
+		c.hold(old.Chunks)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, pathError(name, err)
+	}
+	w := &writeFile{
+		content: content{c: c, chunks: append([]chunkRef(nil), old.Chunks...), size: old.Size},
+		name:    name,
+		p:       p,
+		perm:    info.Mode().Perm(),
+		held:    append([]chunkRef(nil), old.Chunks...),
+		chunker: newChunker(c.min, c.avg, c.max),
+		append:  flag&os.O_APPEND != 0,
+	}
+	if flag&os.O_TRUNC != 0 {
+		if err := w.Truncate(0); err != nil {
+			w.release()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *writeFile) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: w.name, Err: err}
+}
+
+func (w *writeFile) emit(chunk []byte) error {
+	ref, err := w.c.putChunk(chunk)
+	if err != nil {
+		return err
+	}
+	w.held = append(w.held, ref)
+	w.chunks = append(w.chunks, ref)
+	return nil
+}
+
+// feed chunks p written at the end of the file
+func (w *writeFile) feed(p []byte) error {
+	if err := w.chunker.write(p, w.emit); err != nil {
+		return err
+	}
+	w.tail = w.chunker.buf
+	w.size += int64(len(p))
+	return nil
+}
+
+func (w *writeFile) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, w.err("write", fs.ErrClosed)
+	}
+	if w.append {
+		w.offset = w.size
+	}
+	if w.offset != w.size {
+		return 0, w.err("write", vfs.ErrNotSupported)
+	}
+	if err := w.feed(p); err != nil {
+		return 0, w.err("write", err)
+	}
+	w.offset = w.size
+	return len(p), nil
+}
+
+func (w *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	if w.append {
+		return 0, w.err("write", syscall.EINVAL)
+	}
+	if off != w.size {
+		return 0, w.err("write", vfs.ErrNotSupported)
+	}
+	w.offset = off
+	return w.Write(p)
+}
+
+func (w *writeFile) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Truncate changes the size of the file, shrinking rechunks the last remaining chunk
+func (w *writeFile) Truncate(size int64) error {
+	if w.closed {
+		return w.err("truncate", fs.ErrClosed)
+	}
+	if size < 0 {
+		return w.err("truncate", syscall.EINVAL)
+	}
+	if size >= w.size {
+		return w.feed(make([]byte, size-w.size))
+	}
+	// keep the chunks before size and the head of the chunk containing it
+	start := int64(0)
+	i := 0
+	for ; i < len(w.chunks) && start+w.chunks[i].Size <= size; i++ {
+		start += w.chunks[i].Size
+	}
+	head := make([]byte, size-start)
+	if _, err := w.readAt(head, start); err != nil && err != io.EOF {
+		return w.err("truncate", err)
+	}
+	w.close()
+	w.chunks = w.chunks[:i]
+	w.chunker.reset()
+	w.tail, w.size = nil, start
+	return w.feed(head)
+}
+
+func (w *writeFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := w.readAt(p, w.offset)
+	w.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		err = w.err("read", err)
+	}
+	return n, err
+}
+
+func (w *writeFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := w.readAt(p, off)
+	if err != nil && err != io.EOF {
+		err = w.err("read", err)
+	}
+	return n, err
+}
+
+func (w *writeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += w.offset
+	case io.SeekEnd:
+		offset += w.size
+	}
+	if offset < 0 {
+		return 0, w.err("seek", syscall.EINVAL)
+	}
+	w.offset = offset
+	return offset, nil
+}
+
+// release drops the chunks held by the writer
+func (w *writeFile) release() {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.c.unhold(w.held)
+	w.held = nil
+}
+
+func (w *writeFile) Close() error {
+	if w.closed {
+		return w.err("close", fs.ErrClosed)
+	}
+	w.closed = true
+	w.close()
+	if len(w.chunker.buf) > 0 {
+		if err := w.emit(w.chunker.buf); err != nil {
+			w.release()
+			return w.err("close", err)
+		}
+		w.chunker.reset()
+	}
+	m := &manifest{Size: w.size, Chunks: w.chunks}
+	return pathError(w.name, w.c.commit(w.p, m, 0, w.perm, w.held))
+}
+
+func (w *writeFile) Name() string {
+	return w.name
+}
+
+func (w *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, w.err("readdir", syscall.ENOTDIR)
+}
+
+func (w *writeFile) Readdirnames(n int) ([]string, error) {
+	return nil, w.err("readdir", syscall.ENOTDIR)
+}
+
+func (w *writeFile) Stat() (os.FileInfo, error) {
+	info, err := w.c.store.Stat(w.p)
+	if err != nil {
+		return nil, pathError(w.name, err)
+	}
+	return &fileInfo{FileInfo: info, size: w.size}, nil
+}
+
+func (w *writeFile) Sync() error {
+	return nil
+}
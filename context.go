@@ -0,0 +1,104 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// AsContextFS returns fsys as ContextFS. FS without context support are wrapped
+// so that the context is only checked before calling the plain afero method.
+func AsContextFS(fsys FS) ContextFS {
+	if fsys, ok := fsys.(ContextFS); ok {
+		return fsys
+	}
+	return contextFS{fsys}
+}
+
+type contextFS struct {
+	FS
+}
+
+func (c contextFS) CreateContext(ctx context.Context, name string) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.FS.Create(name)
+}
+
+func (c contextFS) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.Mkdir(name, perm)
+}
+
+func (c contextFS) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.MkdirAll(path, perm)
+}
+
+func (c contextFS) OpenContext(ctx context.Context, name string) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.FS.Open(name)
+}
+
+func (c contextFS) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.FS.OpenFile(name, flag, perm)
+}
+
+func (c contextFS) RemoveContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.Remove(name)
+}
+
+func (c contextFS) RemoveAllContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.RemoveAll(path)
+}
+
+func (c contextFS) RenameContext(ctx context.Context, oldname, newname string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.Rename(oldname, newname)
+}
+
+func (c contextFS) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.FS.Stat(name)
+}
+
+func (c contextFS) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.Chmod(name, mode)
+}
+
+func (c contextFS) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.Chown(name, uid, gid)
+}
+
+func (c contextFS) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.FS.Chtimes(name, atime, mtime)
+}
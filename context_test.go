@@ -0,0 +1,49 @@
+package vfs
+
+import (
+	"context"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+type ctxKey struct{}
+
+// valueFS records the context value passed to StatContext
+type valueFS struct {
+	contextFS
+	got any
+}
+
+func (f *valueFS) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	f.got = ctx.Value(ctxKey{})
+	return f.contextFS.StatContext(ctx, name)
+}
+
+func TestContext(t *testing.T) {
+	v := New()
+	mem := afero.NewMemMapFs()
+	assert.NoError(t, v.Mount("/", mem))
+	assert.NoError(t, afero.WriteFile(mem, "a.txt", []byte("a"), 0644))
+
+	// fallback checks the context before calling plain afero methods
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := v.StatContext(ctx, "/a.txt")
+	assert.NoError(t, err)
+	cancel()
+	_, err = v.StatContext(ctx, "/a.txt")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = v.OpenContext(ctx, "/a.txt")
+	assert.ErrorIs(t, err, context.Canceled)
+	mp, _, _ := v.findMountPoint("/a.txt")
+	assert.Equal(t, int32(0), mp.GetOpenCount())
+
+	// context is passed down to backends implementing ContextFS
+	vfs := &valueFS{contextFS: contextFS{afero.NewMemMapFs()}}
+	assert.NoError(t, v.Mount("/ctx", vfs))
+	assert.NoError(t, v.Mkdir("/ctx/dir", 0755))
+	_, err = v.StatContext(context.WithValue(context.Background(), ctxKey{}, "value"), "/ctx/dir")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", vfs.got)
+}
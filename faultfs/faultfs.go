@@ -0,0 +1,504 @@
+// Package faultfs wraps a FS to inject errors, latency, bandwidth limits and
+// short or partial reads and writes, for testing code using unreliable backends
+package faultfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// Operations on opened files, FS operations use the vfs.Op names
+const (
+	OpRead    = "read"
+	OpWrite   = "write"
+	OpReaddir = "readdir"
+	OpSync    = "sync"
+	OpClose   = "close"
+)
+
+// Rule selects calls and the faults injected into them
+type Rule struct {
+	// Ops are operation names, empty matches all operations
+	Ops []string
+	// Path is a glob as in vfs.Match, empty matches all paths
+	Path string
+	// Probability of injecting into a matching call, zero means always
+	Probability float64
+	// Nth only injects into the nth matching call, counted from 1
+	Nth int
+	// Times limits the number of injections, zero means unlimited
+	Times int
+
+	// Err is returned by the call, nil for rules only delaying or shortening calls
+	Err error
+	// Latency is added before the call
+	Latency time.Duration
+	// Bandwidth limits reads and writes in bytes per second
+	Bandwidth int64
+	// ShortIO halves reads and writes, short writes fail with io.ErrShortWrite
+	ShortIO bool
+	// FailAfter, if positive, fails reads or writes of a file with Err, or EIO
+	// if nil, once FailAfter bytes were transferred
+	FailAfter int64
+}
+
+type rule struct {
+	Rule
+	calls    int
+	injected int
+}
+
+// fault is the combination of matching rules for a call
+type fault struct {
+	err       error
+	latency   time.Duration
+	bandwidth int64
+	short     bool
+	failAfter int64
+}
+
+// FS injects faults into calls of the wrapped FS
+type FS struct {
+	fs    vfs.ContextFS
+	inner vfs.FS
+	mu    sync.Mutex
+	rules []*rule
+	rand  *rand.Rand
+}
+
+var (
+	_ vfs.FS        = (*FS)(nil)
+	_ vfs.ContextFS = (*FS)(nil)
+	_ vfs.Linker    = (*FS)(nil)
+)
+
+// New wraps fsys without any rule
+func New(fsys vfs.FS) *FS {
+	return &FS{fs: vfs.AsContextFS(fsys), inner: fsys, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Seed makes probabilities reproducible
+func (f *FS) Seed(seed int64) {
+	f.mu.Lock()
+	f.rand.Seed(seed)
+	f.mu.Unlock()
+}
+
+// Add appends rules, all matching rules apply to a call
+func (f *FS) Add(rules ...Rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range rules {
+		if r.Path != "" {
+			r.Path = path.Clean("/" + r.Path)
+		}
+		f.rules = append(f.rules, &rule{Rule: r})
+	}
+}
+
+// Reset removes all rules
+func (f *FS) Reset() {
+	f.mu.Lock()
+	f.rules = nil
+	f.mu.Unlock()
+}
+
+func (r *rule) match(op, name string) bool {
+	if len(r.Ops) > 0 {
+		found := false
+		for _, o := range r.Ops {
+			if o == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.Path == "" || vfs.Match(r.Path, name)
+}
+
+func (f *FS) match(op, name string) fault {
+	name = path.Clean("/" + name)
+	flt := fault{failAfter: -1}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rules {
+		if !r.match(op, name) {
+			continue
+		}
+		r.calls++
+		if r.Nth > 0 && r.calls != r.Nth {
+			continue
+		}
+		if r.Times > 0 && r.injected >= r.Times {
+			continue
+		}
+		if r.Probability > 0 && f.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.injected++
+		flt.latency += r.Latency
+		if r.Bandwidth > 0 && (flt.bandwidth == 0 || r.Bandwidth < flt.bandwidth) {
+			flt.bandwidth = r.Bandwidth
+		}
+		flt.short = flt.short || r.ShortIO
+		if r.FailAfter > 0 && (flt.failAfter < 0 || r.FailAfter < flt.failAfter) {
+			flt.failAfter = r.FailAfter
+			if flt.err == nil {
+				flt.err = r.Err
+				if flt.err == nil {
+					flt.err = syscall.EIO
+				}
+			}
+			continue
+		}
+		if flt.err == nil {
+			flt.err = r.Err
+		}
+	}
+	return flt
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (flt fault) transfer(n int) time.Duration {
+	if flt.bandwidth <= 0 {
+		return 0
+	}
+	return time.Duration(int64(n) * int64(time.Second) / flt.bandwidth)
+}
+
+// inject delays the call and returns the injected error of op on name
+func (f *FS) inject(ctx context.Context, op, name string) error {
+	flt := f.match(op, name)
+	if err := sleep(ctx, flt.latency); err != nil {
+		return err
+	}
+	if flt.err != nil && flt.failAfter < 0 {
+		return &fs.PathError{Op: op, Path: name, Err: flt.err}
+	}
+	return nil
+}
+
+func (f *FS) Name() string {
+	return "FaultFS"
+}
+
+func (f *FS) Create(name string) (vfs.File, error) {
+	return f.CreateContext(context.Background(), name)
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	return f.MkdirContext(context.Background(), name, perm)
+}
+
+func (f *FS) MkdirAll(path string, perm os.FileMode) error {
+	return f.MkdirAllContext(context.Background(), path, perm)
+}
+
+func (f *FS) Open(name string) (vfs.File, error) {
+	return f.OpenContext(context.Background(), name)
+}
+
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	return f.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (f *FS) Remove(name string) error {
+	return f.RemoveContext(context.Background(), name)
+}
+
+func (f *FS) RemoveAll(path string) error {
+	return f.RemoveAllContext(context.Background(), path)
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	return f.RenameContext(context.Background(), oldname, newname)
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	return f.StatContext(context.Background(), name)
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return f.ChmodContext(context.Background(), name, mode)
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	return f.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (f *FS) CreateContext(ctx context.Context, name string) (vfs.File, error) {
+	if err := f.inject(ctx, vfs.OpCreate, name); err != nil {
+		return nil, err
+	}
+	inner, err := f.fs.CreateContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(inner, name), nil
+}
+
+func (f *FS) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	if err := f.inject(ctx, vfs.OpMkdir, name); err != nil {
+		return err
+	}
+	return f.fs.MkdirContext(ctx, name, perm)
+}
+
+func (f *FS) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	if err := f.inject(ctx, vfs.OpMkdirAll, path); err != nil {
+		return err
+	}
+	return f.fs.MkdirAllContext(ctx, path, perm)
+}
+
+func (f *FS) OpenContext(ctx context.Context, name string) (vfs.File, error) {
+	if err := f.inject(ctx, vfs.OpOpen, name); err != nil {
+		return nil, err
+	}
+	inner, err := f.fs.OpenContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(inner, name), nil
+}
+
+func (f *FS) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if err := f.inject(ctx, vfs.OpOpenFile, name); err != nil {
+		return nil, err
+	}
+	inner, err := f.fs.OpenFileContext(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(inner, name), nil
+}
+
+func (f *FS) RemoveContext(ctx context.Context, name string) error {
+	if err := f.inject(ctx, vfs.OpRemove, name); err != nil {
+		return err
+	}
+	return f.fs.RemoveContext(ctx, name)
+}
+
+func (f *FS) RemoveAllContext(ctx context.Context, path string) error {
+	if err := f.inject(ctx, vfs.OpRemoveAll, path); err != nil {
+		return err
+	}
+	return f.fs.RemoveAllContext(ctx, path)
+}
+
+func (f *FS) RenameContext(ctx context.Context, oldname, newname string) error {
+	if err := f.inject(ctx, vfs.OpRename, oldname); err != nil {
+		return err
+	}
+	return f.fs.RenameContext(ctx, oldname, newname)
+}
+
+func (f *FS) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := f.inject(ctx, vfs.OpStat, name); err != nil {
+		return nil, err
+	}
+	return f.fs.StatContext(ctx, name)
+}
+
+func (f *FS) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	if err := f.inject(ctx, vfs.OpChmod, name); err != nil {
+		return err
+	}
+	return f.fs.ChmodContext(ctx, name, mode)
+}
+
+func (f *FS) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	if err := f.inject(ctx, vfs.OpChown, name); err != nil {
+		return err
+	}
+	return f.fs.ChownContext(ctx, name, uid, gid)
+}
+
+func (f *FS) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	if err := f.inject(ctx, vfs.OpChtimes, name); err != nil {
+		return err
+	}
+	return f.fs.ChtimesContext(ctx, name, atime, mtime)
+}
+
+func (f *FS) linker() (vfs.Linker, error) {
+	l, ok := f.inner.(vfs.Linker)
+	if !ok {
+		return nil, vfs.ErrNotSupported
+	}
+	return l, nil
+}
+
+func (f *FS) PreSignedURL(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	l, err := f.linker()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.inject(ctx, vfs.OpPreSignedURL, name); err != nil {
+		return nil, err
+	}
+	return l.PreSignedURL(ctx, name, args...)
+}
+
+func (f *FS) PublicUrl(ctx context.Context, name string) (*vfs.Link, error) {
+	l, err := f.linker()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.inject(ctx, vfs.OpPublicUrl, name); err != nil {
+		return nil, err
+	}
+	return l.PublicUrl(ctx, name)
+}
+
+func (f *FS) InternalUrl(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	l, err := f.linker()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.inject(ctx, vfs.OpInternalUrl, name); err != nil {
+		return nil, err
+	}
+	return l.InternalUrl(ctx, name, args...)
+}
+
+func (f *FS) wrap(inner vfs.File, name string) vfs.File {
+	return &file{File: inner, fs: f, name: name}
+}
+
+// file injects faults into reads and writes
+type file struct {
+	vfs.File
+	fs      *FS
+	name    string
+	mu      sync.Mutex
+	read    int64
+	written int64
+}
+
+// transfer performs a read or write of p, counted in *done
+func (f *file) transfer(op string, p []byte, done *int64, call func(p []byte) (int, error)) (int, error) {
+	flt := f.fs.match(op, f.name)
+	if err := sleep(context.Background(), flt.latency); err != nil {
+		return 0, err
+	}
+	if flt.err != nil && flt.failAfter < 0 {
+		return 0, &fs.PathError{Op: op, Path: f.name, Err: flt.err}
+	}
+	limit := len(p)
+	if flt.short && limit > 1 {
+		limit /= 2
+	}
+	f.mu.Lock()
+	transferred := *done
+	f.mu.Unlock()
+	failing := false
+	if flt.failAfter >= 0 {
+		remaining := flt.failAfter - transferred
+		if remaining <= 0 {
+			return 0, &fs.PathError{Op: op, Path: f.name, Err: flt.err}
+		}
+		if remaining < int64(limit) {
+			limit = int(remaining)
+			failing = true
+		}
+	}
+	n, err := call(p[:limit])
+	f.mu.Lock()
+	*done += int64(n)
+	f.mu.Unlock()
+	sleep(context.Background(), flt.transfer(n))
+	if err != nil {
+		return n, err
+	}
+	if failing {
+		return n, &fs.PathError{Op: op, Path: f.name, Err: flt.err}
+	}
+	if op == OpWrite && n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	return f.transfer(OpRead, p, &f.read, f.File.Read)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	return f.transfer(OpRead, p, &f.read, func(p []byte) (int, error) {
+		return f.File.ReadAt(p, off)
+	})
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return f.transfer(OpWrite, p, &f.written, f.File.Write)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	return f.transfer(OpWrite, p, &f.written, func(p []byte) (int, error) {
+		return f.File.WriteAt(p, off)
+	})
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if err := f.fs.inject(context.Background(), OpReaddir, f.name); err != nil {
+		return nil, err
+	}
+	return f.File.Readdir(count)
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	if err := f.fs.inject(context.Background(), OpReaddir, f.name); err != nil {
+		return nil, err
+	}
+	return f.File.Readdirnames(n)
+}
+
+func (f *file) Sync() error {
+	if err := f.fs.inject(context.Background(), OpSync, f.name); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *file) Close() error {
+	if err := f.fs.inject(context.Background(), OpClose, f.name); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
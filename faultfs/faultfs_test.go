@@ -0,0 +1,113 @@
+package faultfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/vfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+		return New(afero.NewMemMapFs())
+	}, vfstest.Skip("Linker"))
+}
+
+func TestErrors(t *testing.T) {
+	f := New(afero.NewMemMapFs())
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/a", f))
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("1"), 0644))
+
+	f.Add(Rule{Ops: []string{vfs.OpStat}, Path: "/**/*.txt", Err: syscall.EIO, Times: 2})
+	_, err := v.Stat("/a/1.txt")
+	assert.ErrorIs(t, err, syscall.EIO)
+	_, err = v.Stat("/a/1.txt")
+	assert.ErrorIs(t, err, syscall.EIO)
+	_, err = v.Stat("/a/1.txt")
+	assert.NoError(t, err)
+
+	f.Reset()
+	f.Add(Rule{Ops: []string{vfs.OpOpen}, Nth: 2, Err: syscall.ECONNRESET})
+	_, err = v.Open("/a/1.txt")
+	assert.NoError(t, err)
+	_, err = v.Open("/a/1.txt")
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	_, err = v.Open("/a/1.txt")
+	assert.NoError(t, err)
+
+	f.Reset()
+	f.Seed(1)
+	f.Add(Rule{Ops: []string{vfs.OpStat}, Probability: 0.5, Err: syscall.EIO})
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if _, err := v.Stat("/a/1.txt"); err != nil {
+			failed++
+		}
+	}
+	assert.Greater(t, failed, 20)
+	assert.Less(t, failed, 80)
+}
+
+func TestLatency(t *testing.T) {
+	f := New(afero.NewMemMapFs())
+	f.Add(Rule{Ops: []string{vfs.OpStat}, Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := f.StatContext(ctx, "/1.txt")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	f.Reset()
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", make([]byte, 100), 0644))
+	f.Add(Rule{Ops: []string{OpRead}, Bandwidth: 1000})
+	start := time.Now()
+	_, err = afero.ReadFile(f, "/1.txt")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestShortIO(t *testing.T) {
+	f := New(afero.NewMemMapFs())
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("0123456789"), 0644))
+	f.Add(Rule{Ops: []string{OpRead, OpWrite}, ShortIO: true})
+
+	file, err := f.Open("/1.txt")
+	assert.NoError(t, err)
+	buf := make([]byte, 10)
+	n, err := file.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	file.Close()
+
+	// readers handling short reads still get everything
+	b, err := afero.ReadFile(f, "/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(b))
+
+	file, err = f.OpenFile("/1.txt", os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	n, err = file.Write([]byte("abcd"))
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.Equal(t, 2, n)
+	file.Close()
+}
+
+func TestFailAfter(t *testing.T) {
+	f := New(afero.NewMemMapFs())
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("0123456789"), 0644))
+	f.Add(Rule{Ops: []string{OpRead}, Path: "/1.txt", FailAfter: 4, Err: syscall.ECONNRESET})
+
+	file, err := f.Open("/1.txt")
+	assert.NoError(t, err)
+	defer file.Close()
+	b, err := io.ReadAll(file)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, "0123", string(b))
+}
@@ -3,8 +3,10 @@ package vfs
 import (
 	"context"
 	"github.com/spf13/afero"
+	"io"
 	"io/fs"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -13,6 +15,22 @@ type File = afero.File
 
 type FS = afero.Fs
 
+// ContextFS is implemented by FS which accept a context for cancellation and deadlines
+type ContextFS interface {
+	CreateContext(ctx context.Context, name string) (File, error)
+	MkdirContext(ctx context.Context, name string, perm os.FileMode) error
+	MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error
+	OpenContext(ctx context.Context, name string) (File, error)
+	OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error)
+	RemoveContext(ctx context.Context, name string) error
+	RemoveAllContext(ctx context.Context, path string) error
+	RenameContext(ctx context.Context, oldname, newname string) error
+	StatContext(ctx context.Context, name string) (os.FileInfo, error)
+	ChmodContext(ctx context.Context, name string, mode os.FileMode) error
+	ChownContext(ctx context.Context, name string, uid, gid int) error
+	ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error
+}
+
 type Linker interface {
 	PreSignedURL(ctx context.Context, name string, args ...LinkOptions) (*Link, error)
 	PublicUrl(ctx context.Context, name string) (*Link, error)
@@ -29,10 +47,55 @@ type Copier interface {
 	Copy(ctx context.Context, src, dest string) error
 }
 
+// Snapshotter is implemented by FS which can capture a subtree at a point in time
+type Snapshotter interface {
+	// Snapshot returns a read-only FS rooted at name with the content name has at the time of the call
+	Snapshot(ctx context.Context, name string) (FS, error)
+}
+
+// Version of a file kept by a Versioner
+type Version struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+	// IsLatest is set on the current version of the file
+	IsLatest bool
+	// DeleteMarker records the removal of the file, it has no content
+	DeleteMarker bool
+}
+
+// Versioner is implemented by FS keeping previous versions of files when
+// they are overwritten or removed
+type Versioner interface {
+	// ListVersions lists the versions of the file name, the latest first
+	ListVersions(ctx context.Context, name string) ([]*Version, error)
+	// OpenVersion opens a version of name for reading
+	OpenVersion(ctx context.Context, name, id string) (File, error)
+	// RestoreVersion makes a copy of the version id the latest version of name
+	RestoreVersion(ctx context.Context, name, id string) error
+}
+
 type Lister interface {
 	ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *ListOptions) (retval []*fs.FileInfo, nextPageToken []byte, err error)
 }
 
+// Metadata of a file beyond its FileInfo. Listers may return it from
+// FileInfo.Sys to save a GetMetadata per entry.
+type Metadata struct {
+	ContentType string
+	// ETag identifies the content, empty if the FS does not know it
+	ETag string
+	// User defined metadata by lower case key
+	User map[string]string
+}
+
+// Metadater is implemented by FS storing metadata along files, e.g. object stores
+type Metadater interface {
+	GetMetadata(ctx context.Context, name string) (*Metadata, error)
+	// SetMetadata replaces the content type and user metadata of name, the ETag is ignored
+	SetMetadata(ctx context.Context, name string, md *Metadata) error
+}
+
 type Initializer interface {
 	Init(ctx context.Context) error
 	Dispose(ctx context.Context) error
@@ -83,22 +146,81 @@ type ListOptions struct {
 
 type fileWrapper struct {
 	File
-	closed func()
+	written bool
+	closed  func(written bool)
+	// failed is called with errors of file I/O, if not nil
+	failed func(err error)
 }
 
-func newFileWrapper(f File, closed func()) *fileWrapper {
+func newFileWrapper(f File, closed func(written bool)) *fileWrapper {
 	return &fileWrapper{
 		File:   f,
 		closed: closed,
 	}
 }
-func (f fileWrapper) Close() error {
+
+// check reports err to failed, io.EOF is not a failure
+func (f *fileWrapper) check(err error) error {
+	if err != nil && err != io.EOF && f.failed != nil {
+		f.failed(err)
+	}
+	return err
+}
+
+func (f *fileWrapper) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	return n, f.check(err)
+}
+
+func (f *fileWrapper) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	return n, f.check(err)
+}
+
+func (f *fileWrapper) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	return infos, f.check(err)
+}
+
+func (f *fileWrapper) Readdirnames(n int) ([]string, error) {
+	names, err := f.File.Readdirnames(n)
+	return names, f.check(err)
+}
+
+func (f *fileWrapper) Write(p []byte) (int, error) {
+	f.written = true
+	n, err := f.File.Write(p)
+	return n, f.check(err)
+}
+
+func (f *fileWrapper) WriteAt(p []byte, off int64) (int, error) {
+	f.written = true
+	n, err := f.File.WriteAt(p, off)
+	return n, f.check(err)
+}
+
+func (f *fileWrapper) WriteString(s string) (int, error) {
+	f.written = true
+	n, err := f.File.WriteString(s)
+	return n, f.check(err)
+}
+
+func (f *fileWrapper) Truncate(size int64) error {
+	f.written = true
+	return f.check(f.File.Truncate(size))
+}
+
+func (f *fileWrapper) Sync() error {
+	return f.check(f.File.Sync())
+}
+
+func (f *fileWrapper) Close() error {
 	//TODO close fail?
 	defer func() {
 		if f.closed != nil {
-			f.closed()
+			f.closed(f.written)
 		}
 		f.closed = nil
 	}()
-	return f.File.Close()
+	return f.check(f.File.Close())
 }
@@ -0,0 +1,41 @@
+package vfs
+
+import (
+	"path"
+	"strings"
+)
+
+// Match reports whether the slash separated path p matches pattern. Segments
+// are matched by path.Match, and "**" matches any number of segments.
+func Match(pattern, p string) bool {
+	return matchSegments(splitSegments(pattern), splitSegments(p))
+}
+
+func splitSegments(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
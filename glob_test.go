@@ -0,0 +1,17 @@
+package vfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("/a/**", "/a"))
+	assert.True(t, Match("/a/**", "/a/b/c"))
+	assert.True(t, Match("/a/*/c", "/a/b/c"))
+	assert.False(t, Match("/a/*/c", "/a/b/d/c"))
+	assert.True(t, Match("/**/*.txt", "/a/b/c.txt"))
+	assert.False(t, Match("/**/*.txt", "/a/b/c.png"))
+	assert.True(t, Match("/", "/"))
+}
@@ -0,0 +1,306 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrUnhealthy fails operations on a mount point whose circuit is open
+var ErrUnhealthy = errors.New("mount point is unhealthy")
+
+type HealthState int
+
+const (
+	// HealthUnknown is the state of mount points without health check
+	HealthUnknown HealthState = iota
+	HealthHealthy
+	// HealthUnhealthy fast fails operations with ErrUnhealthy
+	HealthUnhealthy
+	// HealthRecovering lets a single trial operation through after the cool down
+	HealthRecovering
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthHealthy:
+		return "healthy"
+	case HealthUnhealthy:
+		return "unhealthy"
+	case HealthRecovering:
+		return "recovering"
+	}
+	return "unknown"
+}
+
+func (s HealthState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// HealthConfig configures the health check and circuit breaker of a mount point.
+// Zero fields take default values.
+type HealthConfig struct {
+	// Interval of probes, defaults to 30s
+	Interval time.Duration
+	// Timeout of a probe, defaults to 5s
+	Timeout time.Duration
+	// Probe checks the mounted FS, defaults to stat its root
+	Probe func(ctx context.Context, fsys FS) error
+	// FailureThreshold is the number of consecutive failures of operations or
+	// probes opening the circuit, defaults to 3
+	FailureThreshold int
+	// CoolDown is the time the circuit stays open before a trial operation, defaults to 30s
+	CoolDown time.Duration
+	// IsFailure reports whether an error of an operation indicates a degraded backend,
+	// defaults to all errors but the ones caused by the request, e.g. fs.ErrNotExist
+	IsFailure func(err error) bool
+}
+
+// WithHealthCheck probes the mount point periodically and fast fails its
+// operations with ErrUnhealthy when it is unhealthy. Only the source mount
+// point of a rename is guarded.
+func WithHealthCheck(cfg HealthConfig) MountOption {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Probe == nil {
+		cfg.Probe = func(ctx context.Context, fsys FS) error {
+			_, err := AsContextFS(fsys).StatContext(ctx, "")
+			return err
+		}
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = 30 * time.Second
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = IsBackendFailure
+	}
+	return func(mp *MountPoint) {
+		mp.health = &health{cfg: cfg, state: HealthHealthy, since: time.Now(), now: time.Now}
+	}
+}
+
+// IsBackendFailure reports whether err indicates a degraded backend rather
+// than being caused by the request, e.g. fs.ErrNotExist or ErrNotSupported
+func IsBackendFailure(err error) bool {
+	for _, e := range []error{fs.ErrNotExist, fs.ErrExist, fs.ErrPermission, fs.ErrInvalid, fs.ErrClosed, ErrNotSupported, context.Canceled,
+		syscall.ENOTDIR, syscall.EISDIR, syscall.ENOTEMPTY, syscall.ENOSPC, syscall.ENOTSUP} {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthStatus of a mount point
+type HealthStatus struct {
+	Mount string      `json:"mount"`
+	State HealthState `json:"state"`
+	// Since is the time of the last state change
+	Since     time.Time `json:"since"`
+	LastCheck time.Time `json:"lastCheck"`
+	// Failures is the number of consecutive failures
+	Failures  int    `json:"failures"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// HealthReport of all mount points, Healthy is false if any mount point is not healthy
+type HealthReport struct {
+	Healthy bool           `json:"healthy"`
+	Mounts  []HealthStatus `json:"mounts"`
+}
+
+type health struct {
+	cfg       HealthConfig
+	mu        sync.Mutex
+	state     HealthState
+	since     time.Time
+	openedAt  time.Time
+	lastCheck time.Time
+	failures  int
+	lastErr   error
+	trial     bool // a trial operation is in flight
+	cancel    context.CancelFunc
+	now       func() time.Time
+}
+
+func (h *health) start(fsys FS) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.mu.Lock()
+	h.cancel = cancel
+	h.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(h.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			h.probe(ctx, fsys)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (h *health) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+func (h *health) probe(ctx context.Context, fsys FS) {
+	probeCtx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	err := h.cfg.Probe(probeCtx, fsys)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCheck = h.now()
+	if err == nil {
+		h.failures = 0
+		h.setState(HealthHealthy)
+		return
+	}
+	h.fail(err)
+}
+
+// allow reports whether an operation may call the backend
+func (h *health) allow() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case HealthUnhealthy:
+		if h.now().Sub(h.openedAt) < h.cfg.CoolDown {
+			return ErrUnhealthy
+		}
+		h.setState(HealthRecovering)
+		h.trial = true
+	case HealthRecovering:
+		if h.trial {
+			return ErrUnhealthy
+		}
+		h.trial = true
+	}
+	return nil
+}
+
+// record accounts the result of an allowed operation
+func (h *health) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == HealthRecovering {
+		h.trial = false
+	}
+	if err == nil || !h.cfg.IsFailure(err) {
+		h.failures = 0
+		// operations started before the circuit opened do not close it
+		if h.state != HealthUnhealthy {
+			h.setState(HealthHealthy)
+		}
+		return
+	}
+	h.fail(err)
+}
+
+// failed accounts a failure of I/O on a file opened by an allowed operation.
+// Successful I/O is not recorded, it does not close the circuit.
+func (h *health) failed(err error) {
+	if !h.cfg.IsFailure(err) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fail(err)
+}
+
+func (h *health) fail(err error) {
+	h.failures++
+	h.lastErr = err
+	if h.state == HealthRecovering || h.state == HealthHealthy && h.failures >= h.cfg.FailureThreshold {
+		h.setState(HealthUnhealthy)
+		h.openedAt = h.now()
+	}
+}
+
+func (h *health) setState(s HealthState) {
+	if h.state != s {
+		h.state = s
+		h.since = h.now()
+	}
+}
+
+// guard fast fails op if its mount point is unhealthy, and records the result of h
+func guard(ctx context.Context, op *Operation, h Handler) error {
+	hc := op.MountPoint.health
+	if hc == nil {
+		return h(ctx, op)
+	}
+	if err := hc.allow(); err != nil {
+		return &fs.PathError{Op: op.Name, Path: op.Path, Err: err}
+	}
+	err := h(ctx, op)
+	hc.record(err)
+	return err
+}
+
+// Health returns the health status of the mount point
+func (mp *MountPoint) Health() HealthStatus {
+	s := HealthStatus{Mount: mp.prefix}
+	h := mp.health
+	if h == nil {
+		return s
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.State, s.Since, s.LastCheck, s.Failures = h.state, h.since, h.lastCheck, h.failures
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+// Health reports the health of all mount points sorted by prefix
+func (v *Vfs) Health() HealthReport {
+	r := HealthReport{Healthy: true}
+	for _, mp := range v.Mounts() {
+		s := mp.Health()
+		if s.State == HealthUnhealthy || s.State == HealthRecovering {
+			r.Healthy = false
+		}
+		r.Mounts = append(r.Mounts, s)
+	}
+	sort.Slice(r.Mounts, func(i, j int) bool {
+		return r.Mounts[i].Mount < r.Mounts[j].Mount
+	})
+	return r
+}
+
+// HealthHandler serves the health report as json, with status 503 if not healthy
+func (v *Vfs) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := v.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
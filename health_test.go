@@ -0,0 +1,147 @@
+package vfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyFs fails Stat with EIO while failing is set
+type flakyFs struct {
+	FS
+	failing int32
+}
+
+func (f *flakyFs) Stat(name string) (os.FileInfo, error) {
+	if atomic.LoadInt32(&f.failing) != 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.EIO}
+	}
+	return f.FS.Stat(name)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	flaky := &flakyFs{FS: afero.NewMemMapFs()}
+	v := New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/a", flaky, WithHealthCheck(HealthConfig{Interval: time.Hour, FailureThreshold: 2, CoolDown: 50 * time.Millisecond})))
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("1"), 0644))
+	mp, _, _ := v.findMountPoint("/a")
+	assert.Eventually(t, func() bool {
+		return !mp.Health().LastCheck.IsZero()
+	}, time.Second, time.Millisecond)
+
+	// errors caused by the request do not count
+	for i := 0; i < 3; i++ {
+		_, err := v.Stat("/a/none.txt")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+	assert.Equal(t, HealthHealthy, mp.Health().State)
+
+	atomic.StoreInt32(&flaky.failing, 1)
+	for i := 0; i < 2; i++ {
+		_, err := v.Stat("/a/1.txt")
+		assert.ErrorIs(t, err, syscall.EIO)
+	}
+	_, err := v.Stat("/a/1.txt")
+	assert.ErrorIs(t, err, ErrUnhealthy)
+	// other mounts are not affected
+	_, err = v.Stat("/")
+	assert.NoError(t, err)
+
+	report := v.Health()
+	assert.False(t, report.Healthy)
+	if assert.Len(t, report.Mounts, 2) {
+		assert.Equal(t, HealthUnknown, report.Mounts[0].State)
+		assert.Equal(t, "/a", report.Mounts[1].Mount)
+		assert.Equal(t, HealthUnhealthy, report.Mounts[1].State)
+		assert.Contains(t, report.Mounts[1].LastError, "input/output error")
+	}
+	rec := httptest.NewRecorder()
+	v.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"state":"unhealthy"`)
+
+	// trial after the cool down fails and reopens
+	time.Sleep(60 * time.Millisecond)
+	_, err = v.Stat("/a/1.txt")
+	assert.ErrorIs(t, err, syscall.EIO)
+	_, err = v.Stat("/a/1.txt")
+	assert.ErrorIs(t, err, ErrUnhealthy)
+
+	// trial succeeds
+	atomic.StoreInt32(&flaky.failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	_, err = v.Stat("/a/1.txt")
+	assert.NoError(t, err)
+	assert.True(t, v.Health().Healthy)
+}
+
+func TestHealthProbe(t *testing.T) {
+	flaky := &flakyFs{FS: afero.NewMemMapFs(), failing: 1}
+	v := New()
+	assert.NoError(t, v.Mount("/a", flaky, WithHealthCheck(HealthConfig{Interval: 5 * time.Millisecond, FailureThreshold: 2, CoolDown: time.Hour})))
+	defer v.Unmount("/a", nil)
+	mp, _, _ := v.findMountPoint("/a")
+	assert.Eventually(t, func() bool {
+		return mp.Health().State == HealthUnhealthy
+	}, time.Second, time.Millisecond)
+
+	// a successful probe closes the circuit
+	atomic.StoreInt32(&flaky.failing, 0)
+	assert.Eventually(t, func() bool {
+		return mp.Health().State == HealthHealthy
+	}, time.Second, time.Millisecond)
+}
+
+// eioFile fails reads with EIO
+type eioFile struct {
+	File
+}
+
+func (f eioFile) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.Name(), Err: syscall.EIO}
+}
+
+// eioFs opens files failing reads
+type eioFs struct {
+	FS
+}
+
+func (f eioFs) Open(name string) (File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f eioFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return eioFile{file}, nil
+}
+
+func TestCircuitBreakerFileIO(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/a", eioFs{afero.NewMemMapFs()}, WithHealthCheck(HealthConfig{Interval: time.Hour, FailureThreshold: 2, CoolDown: time.Hour})))
+	defer v.Unmount("/a", nil)
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("1"), 0644))
+	mp, _, _ := v.findMountPoint("/a")
+
+	f, err := v.Open("/a/1.txt")
+	assert.NoError(t, err)
+	defer f.Close()
+	buf := make([]byte, 1)
+	for i := 0; i < 2; i++ {
+		_, err = f.Read(buf)
+		assert.ErrorIs(t, err, syscall.EIO)
+	}
+	assert.Equal(t, HealthUnhealthy, mp.Health().State)
+	_, err = v.Stat("/a/1.txt")
+	assert.ErrorIs(t, err, ErrUnhealthy)
+}
@@ -0,0 +1,240 @@
+// Package httpfs serves a vfs.FS, usually a Vfs or a View of it, read-only over HTTP.
+//
+//	http.Handle("/files/", httpfs.NewHandler(v, httpfs.WithPrefix("/files"), httpfs.WithRedirect(64<<20)))
+package httpfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+type handler struct {
+	fs       vfs.ContextFS
+	mounts   vfs.MountPather // nil if fs has no mount points
+	prefix   string
+	redirect bool
+	minSize  int64
+	context  func(r *http.Request) context.Context
+}
+
+type Option func(h *handler)
+
+// WithPrefix strips prefix from request paths
+func WithPrefix(prefix string) Option {
+	return func(h *handler) {
+		h.prefix = prefix
+	}
+}
+
+// WithRedirect redirects requests of files of at least minSize bytes to their
+// public url when the FS is a vfs.Linker supporting them, e.g. a s3.Blob mount
+func WithRedirect(minSize int64) Option {
+	return func(h *handler) {
+		h.redirect = true
+		h.minSize = minSize
+	}
+}
+
+// WithContext derives the context of operations from requests, e.g. to attach
+// the vfs.Identity of the authenticated user
+func WithContext(fn func(r *http.Request) context.Context) Option {
+	return func(h *handler) {
+		h.context = fn
+	}
+}
+
+// NewHandler serves GET and HEAD requests of fsys. Files support range and
+// conditional requests, directories are listed as HTML or, when requested by
+// "Accept: application/json" or "?format=json", as JSON including the entries
+// leading to mount points.
+func NewHandler(fsys vfs.FS, opts ...Option) http.Handler {
+	m, _ := fsys.(vfs.MountPather)
+	h := &handler{fs: vfs.AsContextFS(fsys), mounts: m}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return http.StatusForbidden
+	case errors.Is(err, vfs.ErrUnhealthy):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, h.prefix)
+	if len(p) == len(r.URL.Path) && h.prefix != "" {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	if h.context != nil {
+		ctx = h.context(r)
+	}
+	name := path.Clean("/" + p)
+	info, err := h.fs.StatContext(ctx, name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if info.IsDir() {
+		// relative links of listings need a trailing slash
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(ctx, w, r, name)
+		return
+	}
+	h.serveFile(ctx, w, r, name, info)
+}
+
+func (h *handler) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, info os.FileInfo) {
+	if h.redirect && info.Size() >= h.minSize {
+		if l, ok := h.fs.(vfs.Linker); ok {
+			link, err := l.PublicUrl(ctx, name)
+			if err == nil {
+				http.Redirect(w, r, link.URL, http.StatusFound)
+				return
+			}
+			if !errors.Is(err, vfs.ErrNotSupported) {
+				httpError(w, err)
+				return
+			}
+		}
+	}
+	// metadata is optional, the content type is detected by http.ServeContent otherwise
+	if md := h.metadata(ctx, name, info); md != nil {
+		if md.ContentType != "" {
+			w.Header().Set("Content-Type", md.ContentType)
+		}
+		if md.ETag != "" {
+			w.Header().Set("ETag", md.ETag)
+		}
+	}
+	f, err := h.fs.OpenContext(ctx, name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func (h *handler) metadata(ctx context.Context, name string, info os.FileInfo) *vfs.Metadata {
+	if md, ok := info.Sys().(*vfs.Metadata); ok {
+		return md
+	}
+	if m, ok := h.fs.(vfs.Metadater); ok {
+		if md, err := m.GetMetadata(ctx, name); err == nil {
+			return md
+		}
+	}
+	return nil
+}
+
+// Entry is a directory entry of JSON listings
+type Entry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Mount is set for mount points and directories leading to them
+	Mount bool `json:"mount,omitempty"`
+}
+
+// Listing is the JSON listing of a directory
+type Listing struct {
+	Path    string  `json:"path"`
+	Entries []Entry `json:"entries"`
+}
+
+func (h *handler) serveDir(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) {
+	// a Vfs merges the mount points below the directory into its entries
+	f, err := h.fs.OpenContext(ctx, name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	l := &Listing{Path: name, Entries: []Entry{}}
+	for _, info := range infos {
+		e := Entry{Name: info.Name(), IsDir: info.IsDir(), ModTime: info.ModTime()}
+		if !e.IsDir {
+			e.Size = info.Size()
+		}
+		e.Mount = h.mounts != nil && h.mounts.IsMountPath(path.Join(name, e.Name))
+		l.Entries = append(l.Entries, e)
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, l)
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"href": func(e Entry) string {
+		href := (&url.URL{Path: e.Name}).String()
+		if e.IsDir {
+			href += "/"
+		}
+		return href
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{href .}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{if not .ModTime.IsZero}}{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,156 @@
+package httpfs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/httpfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// metaFS returns the same metadata for all files
+type metaFS struct {
+	afero.Fs
+}
+
+func (m metaFS) GetMetadata(ctx context.Context, name string) (*vfs.Metadata, error) {
+	return &vfs.Metadata{ContentType: "text/x-custom", ETag: `"custom"`}, nil
+}
+
+func (m metaFS) SetMetadata(ctx context.Context, name string, md *vfs.Metadata) error {
+	return vfs.ErrNotSupported
+}
+
+func newServer(t *testing.T, opts ...httpfs.Option) (*vfs.Vfs, *httptest.Server) {
+	v := vfs.New()
+	root := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(root, "dir/1.txt", []byte("0123456789"), 0644))
+	assert.NoError(t, v.Mount("/", root))
+	meta := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(meta, "2.dat", []byte("2"), 0644))
+	assert.NoError(t, v.Mount("/dir/a/meta", metaFS{meta}))
+	linked := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(linked, "large.bin", []byte("large"), 0644))
+	assert.NoError(t, afero.WriteFile(linked, "small.bin", []byte("s"), 0644))
+	assert.NoError(t, v.Mount("/linked", vfs.NewOptLinker(linked, url.URL{Scheme: "https", Host: "cdn.example.com"}, url.URL{}, nil)))
+
+	srv := httptest.NewServer(httpfs.NewHandler(v, opts...))
+	t.Cleanup(srv.Close)
+	return v, srv
+}
+
+func get(t *testing.T, u string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+func TestFiles(t *testing.T) {
+	_, srv := newServer(t)
+	resp, body := get(t, srv.URL+"/dir/1.txt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	resp, body = get(t, srv.URL+"/dir/1.txt", "Range", "bytes=2-4")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "234", body)
+
+	lastModified := resp.Header.Get("Last-Modified")
+	resp, _ = get(t, srv.URL+"/dir/1.txt", "If-Modified-Since", lastModified)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// from metadata
+	resp, body = get(t, srv.URL+"/dir/a/meta/2.dat")
+	assert.Equal(t, "2", body)
+	assert.Equal(t, "text/x-custom", resp.Header.Get("Content-Type"))
+	resp, _ = get(t, srv.URL+"/dir/a/meta/2.dat", "If-None-Match", `"custom"`)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, _ = get(t, srv.URL+"/missing.txt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err := http.Post(srv.URL+"/dir/1.txt", "text/plain", strings.NewReader("1"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestListing(t *testing.T) {
+	_, srv := newServer(t)
+	resp, _ := get(t, srv.URL+"/dir")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "/dir/", resp.Header.Get("Location"))
+
+	resp, body := get(t, srv.URL+"/dir/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `<a href="1.txt">1.txt</a>`)
+	assert.Contains(t, body, `<a href="a/">a/</a>`)
+
+	_, body = get(t, srv.URL+"/dir/?format=json")
+	var l httpfs.Listing
+	assert.NoError(t, json.Unmarshal([]byte(body), &l))
+	assert.Equal(t, "/dir", l.Path)
+	if assert.Len(t, l.Entries, 2) {
+		assert.Equal(t, httpfs.Entry{Name: "1.txt", Size: 10, ModTime: l.Entries[0].ModTime}, l.Entries[0])
+		assert.Equal(t, "a", l.Entries[1].Name)
+		assert.True(t, l.Entries[1].IsDir)
+		assert.True(t, l.Entries[1].Mount)
+	}
+
+	_, body = get(t, srv.URL+"/", "Accept", "application/json")
+	assert.NoError(t, json.Unmarshal([]byte(body), &l))
+	var names []string
+	for _, e := range l.Entries {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{"dir", "linked"}, names)
+}
+
+func TestRedirect(t *testing.T) {
+	_, srv := newServer(t, httpfs.WithRedirect(2))
+	resp, _ := get(t, srv.URL+"/linked/large.bin")
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://cdn.example.com/large.bin", resp.Header.Get("Location"))
+	resp, body := get(t, srv.URL+"/linked/small.bin")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "s", body)
+	// mounts without links are proxied
+	resp, body = get(t, srv.URL+"/dir/1.txt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+}
+
+func TestView(t *testing.T) {
+	v, _ := newServer(t)
+	view, err := v.View("/dir")
+	assert.NoError(t, err)
+	srv := httptest.NewServer(httpfs.NewHandler(view, httpfs.WithPrefix("/files")))
+	defer srv.Close()
+	_, body := get(t, srv.URL+"/files/1.txt")
+	assert.Equal(t, "0123456789", body)
+	_, body = get(t, srv.URL+"/files/?format=json")
+	assert.Contains(t, body, `"name":"a","isDir":true`)
+	assert.Contains(t, body, `"mount":true`)
+	resp, _ := get(t, srv.URL+"/other/1.txt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,22 @@
+package vfs
+
+import "context"
+
+// Identity is the caller of Vfs operations
+type Identity struct {
+	ID     string
+	Groups []string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity carried by ctx
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
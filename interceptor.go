@@ -0,0 +1,184 @@
+package vfs
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Operation names
+const (
+	OpCreate         = "create"
+	OpMkdir          = "mkdir"
+	OpMkdirAll       = "mkdirAll"
+	OpOpen           = "open"
+	OpOpenFile       = "openFile"
+	OpRemove         = "remove"
+	OpRemoveAll      = "removeAll"
+	OpRename         = "rename"
+	OpStat           = "stat"
+	OpChmod          = "chmod"
+	OpChown          = "chown"
+	OpChtimes        = "chtimes"
+	OpPreSignedURL   = "preSignedURL"
+	OpPublicUrl      = "publicUrl"
+	OpInternalUrl    = "internalUrl"
+	OpCopy           = "copy"
+	OpListPage       = "listPage"
+	OpGetMetadata    = "getMetadata"
+	OpSetMetadata    = "setMetadata"
+	OpSnapshot       = "snapshot"
+	OpListVersions   = "listVersions"
+	OpOpenVersion    = "openVersion"
+	OpRestoreVersion = "restoreVersion"
+)
+
+// Operation describes a Vfs operation passing through interceptors
+type Operation struct {
+	Name string
+	// Path is the full Vfs path
+	Path       string
+	MountPoint *MountPoint
+	// Unrooted is the path passed to the mounted FS
+	Unrooted string
+
+	// NewPath, NewMountPoint and NewUnrooted are the destination of OpRename and OpCopy
+	NewPath       string
+	NewMountPoint *MountPoint
+	NewUnrooted   string
+
+	// Arguments
+	Flag         int
+	Perm         os.FileMode // perm of OpMkdir, OpMkdirAll, OpOpenFile and mode of OpChmod
+	Uid, Gid     int
+	Atime, Mtime time.Time
+	LinkOptions  []LinkOptions
+	// ListOptions, PageToken and PageSize of OpListPage, Path is the directory
+	// of the prefix and ListOptions.Prefix is translated for the mounted FS
+	ListOptions *ListOptions
+	PageToken   []byte
+	PageSize    int
+	Metadata    *Metadata // of OpSetMetadata, result of OpGetMetadata
+	VersionID   string    // of OpOpenVersion and OpRestoreVersion
+
+	// Results, set by the operation when it succeeds. Interceptors may
+	// replace File with a wrapper of it.
+	File          File
+	FileInfo      os.FileInfo
+	Link          *Link
+	FileInfos     []*fs.FileInfo // page of OpListPage
+	NextPageToken []byte
+	Snapshot      FS         // of OpSnapshot
+	Versions      []*Version // of OpListVersions
+
+	created bool // OpOpenFile created the file, OpMkdirAll the directory
+}
+
+// Handler performs an operation
+type Handler func(ctx context.Context, op *Operation) error
+
+// Interceptor wraps an operation. It may inspect or change op before calling next,
+// and inspect or replace the results after. If an interceptor fails an operation
+// after next succeeded, the opened File is closed by Vfs.
+type Interceptor func(ctx context.Context, op *Operation, next Handler) error
+
+// Use registers interceptors wrapping operations of all mount points. Global
+// interceptors run before the interceptors of mount points.
+func (v *Vfs) Use(interceptors ...Interceptor) {
+	v.mtab.mu.Lock()
+	v.interceptors = append(v.interceptors, interceptors...)
+	v.mtab.mu.Unlock()
+}
+
+// newOperation resolves the mount point of name
+func (v *Vfs) newOperation(opName string, name string) *Operation {
+	v.mtab.mu.RLock()
+	mp, _, unrooted := v.findMountPoint(name)
+	v.mtab.mu.RUnlock()
+	return &Operation{Name: opName, Path: fullPath(mp, unrooted, name), MountPoint: mp, Unrooted: unrooted}
+}
+
+func fullPath(mp *MountPoint, unrooted, name string) string {
+	if mp == nil {
+		return path.Clean(filepath.ToSlash(name))
+	}
+	return path.Join(mp.prefix, unrooted)
+}
+
+// invoke calls h through the interceptors of op
+func (v *Vfs) invoke(ctx context.Context, op *Operation, h Handler) error {
+	var chain []Interceptor
+	v.mtab.mu.RLock()
+	chain = append(chain, v.interceptors...)
+	if op.MountPoint != nil {
+		chain = append(chain, op.MountPoint.interceptors...)
+	}
+	if op.NewMountPoint != nil && op.NewMountPoint != op.MountPoint {
+		chain = append(chain, op.NewMountPoint.interceptors...)
+	}
+	v.mtab.mu.RUnlock()
+
+	next := func(ctx context.Context, op *Operation) error {
+		if op.MountPoint == nil || ((op.Name == OpRename || op.Name == OpCopy) && op.NewMountPoint == nil) {
+			return syscall.ENOENT
+		}
+		return guard(ctx, op, h)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, h := chain[i], next
+		next = func(ctx context.Context, op *Operation) error {
+			return interceptor(ctx, op, h)
+		}
+	}
+	if err := next(ctx, op); err != nil {
+		return err
+	}
+	v.notifyOperation(op)
+	return nil
+}
+
+// notifyOperation delivers the event of a succeeded operation
+func (v *Vfs) notifyOperation(op *Operation) {
+	switch op.Name {
+	case OpCreate, OpMkdir:
+		v.notifyPath(EventCreate, op.MountPoint, op.Unrooted)
+	case OpOpenFile, OpMkdirAll:
+		if op.created {
+			v.notifyPath(EventCreate, op.MountPoint, op.Unrooted)
+		}
+	case OpRemove, OpRemoveAll:
+		v.notifyPath(EventRemove, op.MountPoint, op.Unrooted)
+	case OpCopy:
+		v.notifyPath(EventWrite, op.NewMountPoint, op.NewUnrooted)
+	case OpRestoreVersion:
+		v.notifyPath(EventWrite, op.MountPoint, op.Unrooted)
+	case OpRename:
+		if !v.reported(op.NewMountPoint) {
+			v.notify(Event{Op: EventRename, Path: op.NewPath, OldPath: op.Path, Mount: op.NewMountPoint.prefix})
+		}
+	case OpChmod, OpChown, OpChtimes, OpSetMetadata:
+		v.notifyPath(EventChmod, op.MountPoint, op.Unrooted)
+	}
+}
+
+// wrapFile tracks the file opened by op, counted reports whether it is counted as open file of the mount point
+func (v *Vfs) wrapFile(op *Operation, f File, counted bool) *fileWrapper {
+	mp, unrooted, truncated := op.MountPoint, op.Unrooted, op.Name == OpOpenFile && op.Flag&os.O_TRUNC != 0
+	w := newFileWrapper(f, func(written bool) {
+		if counted {
+			mp.closed()
+		}
+		if written || truncated {
+			v.notifyPath(EventWrite, mp, unrooted)
+		}
+	})
+	if mp.health != nil {
+		// the circuit breaker sees failures of the open file as well
+		w.failed = mp.health.failed
+	}
+	return w
+}
@@ -0,0 +1,67 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+)
+
+func TestInterceptor(t *testing.T) {
+	v := New()
+	var global, mount []string
+	v.Use(func(ctx context.Context, op *Operation, next Handler) error {
+		global = append(global, op.Name+" "+op.Path)
+		return next(ctx, op)
+	})
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/a", afero.NewMemMapFs(), WithInterceptors(func(ctx context.Context, op *Operation, next Handler) error {
+		mount = append(mount, op.Name+" "+op.Unrooted)
+		if op.Name == OpRemove {
+			return &fs.PathError{Op: op.Name, Path: op.Path, Err: fs.ErrPermission}
+		}
+		err := next(ctx, op)
+		if op.Name == OpStat && err == nil {
+			assert.Equal(t, "1.txt", op.FileInfo.Name())
+		}
+		return err
+	})))
+
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("1"), 0644))
+	_, err := v.Stat("/a/1.txt")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, v.Remove("/a/1.txt"), fs.ErrPermission)
+	assert.NoError(t, afero.WriteFile(v, "/2.txt", []byte("2"), 0644))
+
+	assert.Equal(t, []string{"openFile /a/1.txt", "stat /a/1.txt", "remove /a/1.txt", "openFile /2.txt"}, global)
+	assert.Equal(t, []string{"openFile 1.txt", "stat 1.txt", "remove 1.txt"}, mount)
+
+	exist, err := afero.Exists(v, "/a/1.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+}
+
+func TestInterceptorOpen(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, afero.WriteFile(v, "/1.txt", []byte("1"), 0644))
+
+	failed := errors.New("failed")
+	v.Use(func(ctx context.Context, op *Operation, next Handler) error {
+		if err := next(ctx, op); err != nil {
+			return err
+		}
+		if op.Name == OpOpen {
+			// fail after the file is opened
+			return failed
+		}
+		return nil
+	})
+	_, err := v.Open("/1.txt")
+	assert.ErrorIs(t, err, failed)
+	mp, _, _ := v.findMountPoint("/1.txt")
+	assert.Equal(t, int32(0), mp.GetOpenCount())
+	assert.NoError(t, v.Unmount("/", nil))
+}
@@ -0,0 +1,216 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IOFS adapts a FS to io/fs.FS, e.g. for template.ParseFS, http.FS or
+// fs.WalkDir. Directories of a Vfs list the mount points below them.
+type IOFS struct {
+	fs       ContextFS
+	mounts   MountPather // nil if fs has no mount points
+	ctx      context.Context
+	prefix   string
+	unrooted bool // names are passed without leading slash, as Vfs does to mounted FSs
+}
+
+var (
+	_ fs.ReadDirFS  = (*IOFS)(nil)
+	_ fs.ReadFileFS = (*IOFS)(nil)
+	_ fs.StatFS     = (*IOFS)(nil)
+	_ fs.SubFS      = (*IOFS)(nil)
+	_ fs.GlobFS     = (*IOFS)(nil)
+)
+
+// NewIOFS adapts fsys, names are rooted at "/" of fsys
+func NewIOFS(fsys FS) *IOFS {
+	f := &IOFS{fs: AsContextFS(fsys), ctx: context.Background()}
+	f.mounts, _ = fsys.(MountPather)
+	return f
+}
+
+// IOFS adapts the FS of the mount point, names are passed as Vfs passes them
+func (mp *MountPoint) IOFS() *IOFS {
+	return &IOFS{fs: AsContextFS(mp.fS), ctx: context.Background(), unrooted: true}
+}
+
+// WithContext returns a copy of f using ctx for all operations, e.g. carrying an Identity
+func (f *IOFS) WithContext(ctx context.Context) *IOFS {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
+// path returns the path of name in the FS
+func (f *IOFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	p := path.Join("/", f.prefix, name)
+	if f.unrooted {
+		p = strings.TrimPrefix(p, "/")
+	}
+	return p, nil
+}
+
+// pathError replaces the path of errors of the FS by name
+func pathError(name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+func (f *IOFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.fs.OpenContext(f.ctx, p)
+	if err != nil {
+		return nil, pathError(name, err)
+	}
+	return &ioFile{File: file, fsys: f, name: name}, nil
+}
+
+func (f *IOFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.fs.StatContext(f.ctx, p)
+	if err != nil {
+		return nil, pathError(name, err)
+	}
+	return renamed(info, name), nil
+}
+
+// namedInfo is the info of a mount point root, which has no name in its FS
+type namedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i *namedInfo) Name() string {
+	return i.name
+}
+
+// renamed returns info named as the base of name
+func renamed(info fs.FileInfo, name string) fs.FileInfo {
+	if name == "." || info.Name() == path.Base(name) {
+		return info
+	}
+	return &namedInfo{FileInfo: info, name: path.Base(name)}
+}
+
+// ReadDir returns the entries of dir sorted by name
+func (f *IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries, err := file.(*ioFile).ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, err
+}
+
+func (f *IOFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Sub returns the FS rooted at dir, a Vfs is rooted by a View sharing its mount points
+func (f *IOFS) Sub(dir string) (fs.FS, error) {
+	p, err := f.path("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "." {
+		return f, nil
+	}
+	if v, ok := f.fs.(*Vfs); ok {
+		w, err := v.View(p)
+		if err != nil {
+			return nil, err
+		}
+		return NewIOFS(w).WithContext(f.ctx), nil
+	}
+	c := *f
+	c.prefix = path.Join(f.prefix, dir)
+	return &c, nil
+}
+
+func (f *IOFS) Glob(pattern string) ([]string, error) {
+	// hide Glob from fs.Glob, which would call it again
+	return fs.Glob(struct{ fs.ReadDirFS }{f}, pattern)
+}
+
+// ioFile adapts File to fs.ReadDirFile
+type ioFile struct {
+	File
+	fsys *IOFS
+	name string
+}
+
+var _ fs.ReadDirFile = (*ioFile)(nil)
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, pathError(f.name, err)
+	}
+	return renamed(info, f.name), nil
+}
+
+// ReadAt reports io.EOF for short reads as required by io.ReaderAt
+func (f *ioFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	infos, err := f.File.Readdir(n)
+	if err != nil && err != io.EOF {
+		err = pathError(f.name, err)
+	}
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(info)
+		if f.fsys.mounts == nil {
+			continue
+		}
+		name := path.Join(f.name, info.Name())
+		if p, _ := f.fsys.path("stat", name); f.fsys.mounts.IsMountPath(p) {
+			entries[i] = &mountEntry{DirEntry: entries[i], fsys: f.fsys, name: name}
+		}
+	}
+	return entries, err
+}
+
+// mountEntry is an entry leading to mount points, listed without stating the mounted FS
+type mountEntry struct {
+	fs.DirEntry
+	fsys *IOFS
+	name string
+}
+
+func (e *mountEntry) Info() (fs.FileInfo, error) {
+	return e.fsys.Stat(e.name)
+}
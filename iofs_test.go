@@ -0,0 +1,70 @@
+package vfs
+
+import (
+	"html/template"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIOFS(t *testing.T) {
+	v := New()
+	root := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(root, "a/1.txt", []byte("1"), 0644))
+	assert.NoError(t, v.Mount("/", root))
+	mounted := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(mounted, "2.tmpl", []byte(`{{define "2"}}two{{end}}`), 0644))
+	assert.NoError(t, v.Mount("/a/b/c", mounted))
+
+	fsys := NewIOFS(v)
+	assert.NoError(t, fstest.TestFS(fsys, "a/1.txt", "a/b/c/2.tmpl"))
+
+	// parents of mount points are listed
+	entries, err := fs.ReadDir(fsys, "a")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "1.txt", entries[0].Name())
+		assert.Equal(t, "b", entries[1].Name())
+		assert.True(t, entries[1].IsDir())
+	}
+
+	sub, err := fs.Sub(fsys, "a/b")
+	assert.NoError(t, err)
+	assert.IsType(t, &View{}, sub.(*IOFS).fs)
+	assert.NoError(t, fstest.TestFS(sub, "c/2.tmpl"))
+	matches, err := fs.Glob(fsys, "a/*/c/*.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/b/c/2.tmpl"}, matches)
+
+	tmpl, err := template.ParseFS(fsys, "a/b/c/*.tmpl")
+	assert.NoError(t, err)
+	var sb strings.Builder
+	assert.NoError(t, tmpl.ExecuteTemplate(&sb, "2", nil))
+	assert.Equal(t, "two", sb.String())
+
+	_, err = fsys.Open("/a/1.txt")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	_, err = fsys.Stat("a/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, "a/missing", err.(*fs.PathError).Path)
+
+	// names of mount points are passed unrooted
+	var mp *MountPoint
+	for _, m := range v.Mounts() {
+		if m.prefix == "/a/b/c" {
+			mp = m
+		}
+	}
+	assert.NoError(t, fstest.TestFS(mp.IOFS(), "2.tmpl"))
+	plain := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(plain, "/x/3.txt", []byte("3"), 0644))
+	sub, err = fs.Sub(NewIOFS(plain), "x")
+	assert.NoError(t, err)
+	b, err := fs.ReadFile(sub, "3.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(b))
+}
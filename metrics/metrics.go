@@ -0,0 +1,336 @@
+// Package metrics collects per mount metrics of Vfs operations. It has no
+// dependency on the prometheus client, Collector serves the prometheus text
+// exposition format and Snapshot can be adapted to any registry.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// DefaultBuckets of latency histograms in seconds
+var DefaultBuckets = []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// Histogram of latencies in seconds, Counts are not cumulative
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64 // one more than Buckets for +Inf
+	Sum     float64
+	Count   uint64
+}
+
+func (h *Histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.Buckets, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+type OpStats struct {
+	Count   uint64
+	Errors  map[string]uint64 // by errno
+	Latency Histogram
+}
+
+type MountStats struct {
+	Mount        string
+	Ops          map[string]*OpStats // by operation name
+	BytesRead    uint64
+	BytesWritten uint64
+	OpenFiles    int32
+}
+
+type mountStats struct {
+	mu           sync.Mutex
+	ops          map[string]*OpStats
+	bytesRead    uint64
+	bytesWritten uint64
+}
+
+// Collector collects metrics of a Vfs
+type Collector struct {
+	v       *vfs.Vfs
+	buckets []float64
+	mu      sync.Mutex
+	mounts  map[string]*mountStats
+}
+
+// New creates a Collector of v with latency buckets, DefaultBuckets are used if none provided
+func New(v *vfs.Vfs, buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Collector{v: v, buckets: buckets, mounts: map[string]*mountStats{}}
+}
+
+func (c *Collector) mount(prefix string) *mountStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.mounts[prefix]
+	if !ok {
+		m = &mountStats{ops: map[string]*OpStats{}}
+		c.mounts[prefix] = m
+	}
+	return m
+}
+
+// Interceptor records operations, should be registered globally to also count operations without mount point
+func (c *Collector) Interceptor() vfs.Interceptor {
+	return func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		start := time.Now()
+		err := next(ctx, op)
+		elapsed := time.Since(start).Seconds()
+
+		prefix := ""
+		if op.MountPoint != nil {
+			prefix = op.MountPoint.GetPrefix()
+		}
+		m := c.mount(prefix)
+		m.mu.Lock()
+		s, ok := m.ops[op.Name]
+		if !ok {
+			s = &OpStats{Errors: map[string]uint64{}, Latency: Histogram{Buckets: c.buckets, Counts: make([]uint64, len(c.buckets)+1)}}
+			m.ops[op.Name] = s
+		}
+		s.Count++
+		s.Latency.observe(elapsed)
+		if err != nil {
+			s.Errors[Errno(err)]++
+		}
+		m.mu.Unlock()
+
+		if err == nil && op.File != nil {
+			op.File = &file{File: op.File, m: m}
+		}
+		return err
+	}
+}
+
+// Errno returns the symbolic errno of err
+func Errno(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if name, ok := errnoNames[errno]; ok {
+			return name
+		}
+		return "errno" + strconv.Itoa(int(errno))
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "ENOENT"
+	case errors.Is(err, fs.ErrExist):
+		return "EEXIST"
+	case errors.Is(err, fs.ErrPermission):
+		return "EPERM"
+	case errors.Is(err, fs.ErrClosed):
+		return "EBADF"
+	case errors.Is(err, fs.ErrInvalid):
+		return "EINVAL"
+	case errors.Is(err, vfs.ErrNotSupported):
+		return "ENOTSUP"
+	case errors.Is(err, context.Canceled):
+		return "ECANCELED"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "ETIMEDOUT"
+	}
+	return "EIO"
+}
+
+var errnoNames = map[syscall.Errno]string{
+	syscall.EPERM:     "EPERM",
+	syscall.ENOENT:    "ENOENT",
+	syscall.EIO:       "EIO",
+	syscall.EBADF:     "EBADF",
+	syscall.EACCES:    "EACCES",
+	syscall.EBUSY:     "EBUSY",
+	syscall.EEXIST:    "EEXIST",
+	syscall.ENOTDIR:   "ENOTDIR",
+	syscall.EISDIR:    "EISDIR",
+	syscall.EINVAL:    "EINVAL",
+	syscall.EMFILE:    "EMFILE",
+	syscall.ENOSPC:    "ENOSPC",
+	syscall.EROFS:     "EROFS",
+	syscall.ENOTEMPTY: "ENOTEMPTY",
+	syscall.ENOTSUP:   "ENOTSUP",
+	syscall.ETIMEDOUT: "ETIMEDOUT",
+}
+
+// Snapshot returns a copy of the metrics sorted by mount prefix
+func (c *Collector) Snapshot() []MountStats {
+	openFiles := map[string]int32{}
+	for _, mp := range c.v.Mounts() {
+		openFiles[mp.GetPrefix()] = mp.GetOpenCount()
+		c.mount(mp.GetPrefix())
+	}
+	c.mu.Lock()
+	prefixes := make([]string, 0, len(c.mounts))
+	for prefix := range c.mounts {
+		prefixes = append(prefixes, prefix)
+	}
+	c.mu.Unlock()
+	sort.Strings(prefixes)
+
+	res := make([]MountStats, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		m := c.mount(prefix)
+		s := MountStats{
+			Mount:        prefix,
+			Ops:          map[string]*OpStats{},
+			BytesRead:    atomic.LoadUint64(&m.bytesRead),
+			BytesWritten: atomic.LoadUint64(&m.bytesWritten),
+			OpenFiles:    openFiles[prefix],
+		}
+		m.mu.Lock()
+		for name, o := range m.ops {
+			cp := *o
+			cp.Errors = map[string]uint64{}
+			for k, v := range o.Errors {
+				cp.Errors[k] = v
+			}
+			cp.Latency.Counts = append([]uint64(nil), o.Latency.Counts...)
+			s.Ops[name] = &cp
+		}
+		m.mu.Unlock()
+		res = append(res, s)
+	}
+	return res
+}
+
+// WritePrometheus writes the metrics in the prometheus text exposition format
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	snapshot := c.Snapshot()
+	ew := &errWriter{w: w}
+	family := func(name, typ, help string, each func(s *MountStats)) {
+		fmt.Fprintf(ew, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for i := range snapshot {
+			each(&snapshot[i])
+		}
+	}
+	eachOp := func(s *MountStats, fn func(name string, o *OpStats)) {
+		names := make([]string, 0, len(s.Ops))
+		for name := range s.Ops {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fn(name, s.Ops[name])
+		}
+	}
+	family("vfs_operations_total", "counter", "Number of Vfs operations.", func(s *MountStats) {
+		eachOp(s, func(name string, o *OpStats) {
+			fmt.Fprintf(ew, "vfs_operations_total{mount=%s,op=%s} %d\n", label(s.Mount), label(name), o.Count)
+		})
+	})
+	family("vfs_operation_errors_total", "counter", "Number of failed Vfs operations by errno.", func(s *MountStats) {
+		eachOp(s, func(name string, o *OpStats) {
+			errnos := make([]string, 0, len(o.Errors))
+			for errno := range o.Errors {
+				errnos = append(errnos, errno)
+			}
+			sort.Strings(errnos)
+			for _, errno := range errnos {
+				fmt.Fprintf(ew, "vfs_operation_errors_total{mount=%s,op=%s,errno=%s} %d\n", label(s.Mount), label(name), label(errno), o.Errors[errno])
+			}
+		})
+	})
+	family("vfs_operation_duration_seconds", "histogram", "Latency of Vfs operations.", func(s *MountStats) {
+		eachOp(s, func(name string, o *OpStats) {
+			var cumulative uint64
+			for i, le := range o.Latency.Buckets {
+				cumulative += o.Latency.Counts[i]
+				fmt.Fprintf(ew, "vfs_operation_duration_seconds_bucket{mount=%s,op=%s,le=%s} %d\n", label(s.Mount), label(name), label(strconv.FormatFloat(le, 'g', -1, 64)), cumulative)
+			}
+			fmt.Fprintf(ew, "vfs_operation_duration_seconds_bucket{mount=%s,op=%s,le=\"+Inf\"} %d\n", label(s.Mount), label(name), o.Latency.Count)
+			fmt.Fprintf(ew, "vfs_operation_duration_seconds_sum{mount=%s,op=%s} %g\n", label(s.Mount), label(name), o.Latency.Sum)
+			fmt.Fprintf(ew, "vfs_operation_duration_seconds_count{mount=%s,op=%s} %d\n", label(s.Mount), label(name), o.Latency.Count)
+		})
+	})
+	family("vfs_read_bytes_total", "counter", "Bytes read from files opened through Vfs.", func(s *MountStats) {
+		fmt.Fprintf(ew, "vfs_read_bytes_total{mount=%s} %d\n", label(s.Mount), s.BytesRead)
+	})
+	family("vfs_written_bytes_total", "counter", "Bytes written to files opened through Vfs.", func(s *MountStats) {
+		fmt.Fprintf(ew, "vfs_written_bytes_total{mount=%s} %d\n", label(s.Mount), s.BytesWritten)
+	})
+	family("vfs_open_files", "gauge", "Number of open files of mount points.", func(s *MountStats) {
+		fmt.Fprintf(ew, "vfs_open_files{mount=%s} %d\n", label(s.Mount), s.OpenFiles)
+	})
+	return ew.err
+}
+
+// labelEscaper escapes label values as the text exposition format requires,
+// which differs from Go quoting for non-ASCII and control characters
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label quotes a label value
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// ServeHTTP serves the metrics in the prometheus text exposition format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WritePrometheus(w)
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+// file counts bytes read and written
+type file struct {
+	vfs.File
+	m *mountStats
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	atomic.AddUint64(&f.m.bytesRead, uint64(n))
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	atomic.AddUint64(&f.m.bytesRead, uint64(n))
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	atomic.AddUint64(&f.m.bytesWritten, uint64(n))
+	return n, err
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	atomic.AddUint64(&f.m.bytesWritten, uint64(n))
+	return n, err
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
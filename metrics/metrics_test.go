@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/a", afero.NewMemMapFs()))
+	c := New(v)
+	v.Use(c.Interceptor())
+
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("hello"), 0644))
+	f, err := v.Open("/a/1.txt")
+	assert.NoError(t, err)
+	_, err = io.ReadAll(f)
+	assert.NoError(t, err)
+	_, err = v.Stat("/a/none")
+	assert.Error(t, err)
+
+	var a MountStats
+	for _, s := range c.Snapshot() {
+		if s.Mount == "/a" {
+			a = s
+		}
+	}
+	assert.Equal(t, uint64(5), a.BytesRead)
+	assert.Equal(t, uint64(5), a.BytesWritten)
+	assert.Equal(t, int32(1), a.OpenFiles)
+	assert.Equal(t, uint64(1), a.Ops[vfs.OpStat].Count)
+	assert.Equal(t, map[string]uint64{"ENOENT": 1}, a.Ops[vfs.OpStat].Errors)
+	assert.Equal(t, uint64(1), a.Ops[vfs.OpOpen].Latency.Count)
+	assert.NoError(t, f.Close())
+
+	var sb strings.Builder
+	assert.NoError(t, c.WritePrometheus(&sb))
+	out := sb.String()
+	assert.Contains(t, out, `vfs_operations_total{mount="/a",op="openFile"} 1`)
+	assert.Contains(t, out, `vfs_operation_errors_total{mount="/a",op="stat",errno="ENOENT"} 1`)
+	assert.Contains(t, out, `vfs_operation_duration_seconds_bucket{mount="/a",op="open",le="+Inf"} 1`)
+	assert.Contains(t, out, `vfs_read_bytes_total{mount="/a"} 5`)
+	assert.Contains(t, out, `vfs_open_files{mount="/"} 0`)
+}
+
+func TestLabel(t *testing.T) {
+	assert.Equal(t, `"/a\\b\"c\nd	é"`, label("/a\\b\"c\nd\té"))
+}
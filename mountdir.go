@@ -0,0 +1,234 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// childMounts returns the names of the entries of dir leading to mount points,
+// e.g. "b" for dir "/a" and mount point "/a/b/c"
+func (v *Vfs) childMounts(dir string) []string {
+	dir = path.Clean(filepath.ToSlash(dir))
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = dir
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, mp := range v.Mounts() {
+		if mp.prefix == dir || !strings.HasPrefix(mp.prefix, prefix) {
+			continue
+		}
+		name, _, _ := strings.Cut(mp.prefix[len(prefix):], "/")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MountPather is implemented by Vfs and View, for servers to list mount points
+// and refuse removing or renaming them
+type MountPather interface {
+	IsMountPath(name string) bool
+}
+
+var (
+	_ MountPather = (*Vfs)(nil)
+	_ MountPather = (*View)(nil)
+)
+
+// IsMountPath reports whether name is a mount point or a directory leading to
+// one, e.g. to refuse removing or renaming it
+func (v *Vfs) IsMountPath(name string) bool {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return v.isMountPoint(name) || len(v.childMounts(name)) > 0
+}
+
+// isMountPoint reports whether name is the prefix of a mount point
+func (v *Vfs) isMountPoint(name string) bool {
+	for _, mp := range v.Mounts() {
+		if mp.prefix == name {
+			return true
+		}
+	}
+	return false
+}
+
+// statMountDir returns a directory for paths leading to mount points which
+// do not exist in the FS they belong to
+func (v *Vfs) statMountDir(op *Operation, err error) (os.FileInfo, error) {
+	if !errors.Is(err, fs.ErrNotExist) || len(v.childMounts(op.Path)) == 0 {
+		return nil, err
+	}
+	return NewFileInfo(op.Path, true, 0, time.Time{}), nil
+}
+
+// openMountDir lists the mount points under directories opened for reading.
+// Paths leading to mount points which do not exist are opened as empty directories.
+func (v *Vfs) openMountDir(op *Operation, f File, err error) (File, error) {
+	if op.Flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return f, err
+	}
+	if err != nil {
+		if _, statErr := v.statMountDir(op, err); statErr != nil {
+			return nil, err
+		}
+		return &mountDir{name: op.Path, dirReader: dirReader{read: true, entries: mountEntries(nil, v.childMounts(op.Path))}}, nil
+	}
+	mounts := v.childMounts(op.Path)
+	if len(mounts) == 0 {
+		return f, nil
+	}
+	if info, err := f.Stat(); err != nil || !info.IsDir() {
+		return f, nil
+	}
+	d := &mergedDir{File: f}
+	d.dirReader.readAll = func() ([]os.FileInfo, error) {
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		return mountEntries(infos, mounts), nil
+	}
+	return d, nil
+}
+
+// mountEntries adds directories of mounts to infos, mount points shadow existing entries
+func mountEntries(infos []os.FileInfo, mounts []string) []os.FileInfo {
+	index := map[string]int{}
+	for i, info := range infos {
+		index[info.Name()] = i
+	}
+	for _, name := range mounts {
+		info := NewFileInfo(name, true, 0, time.Time{})
+		if i, ok := index[name]; ok {
+			if !infos[i].IsDir() {
+				infos[i] = info
+			}
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// dirReader pages directory entries read at once
+type dirReader struct {
+	readAll func() ([]os.FileInfo, error)
+	read    bool
+	entries []os.FileInfo
+	offset  int
+}
+
+func (d *dirReader) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		entries, err := d.readAll()
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	rest := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.offset += count
+	return rest[:count], nil
+}
+
+func (d *dirReader) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+// mergedDir is a directory containing mount points
+type mergedDir struct {
+	File
+	dirReader
+}
+
+func (d *mergedDir) Readdir(count int) ([]os.FileInfo, error) {
+	return d.dirReader.Readdir(count)
+}
+
+func (d *mergedDir) Readdirnames(n int) ([]string, error) {
+	return d.dirReader.Readdirnames(n)
+}
+
+// mountDir is a directory leading to mount points which does not exist in any FS
+type mountDir struct {
+	name string
+	dirReader
+}
+
+var _ File = (*mountDir)(nil)
+
+func (d *mountDir) err(op string) error {
+	return &fs.PathError{Op: op, Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) Close() error {
+	return nil
+}
+
+func (d *mountDir) Read(p []byte) (int, error) {
+	return 0, d.err("read")
+}
+
+func (d *mountDir) ReadAt(p []byte, off int64) (int, error) {
+	return 0, d.err("read")
+}
+
+func (d *mountDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, d.err("seek")
+}
+
+func (d *mountDir) Write(p []byte) (int, error) {
+	return 0, d.err("write")
+}
+
+func (d *mountDir) WriteAt(p []byte, off int64) (int, error) {
+	return 0, d.err("write")
+}
+
+func (d *mountDir) WriteString(s string) (int, error) {
+	return 0, d.err("write")
+}
+
+func (d *mountDir) Name() string {
+	return d.name
+}
+
+func (d *mountDir) Stat() (os.FileInfo, error) {
+	return NewFileInfo(d.name, true, 0, time.Time{}), nil
+}
+
+func (d *mountDir) Sync() error {
+	return nil
+}
+
+func (d *mountDir) Truncate(size int64) error {
+	return d.err("truncate")
+}
@@ -41,7 +41,9 @@ func (o *OptLinker) PreSignedURL(ctx context.Context, name string, args ...LinkO
 	url := o.publicAccessUrl
 	url.Path = path.Join(url.Path, name)
 	if len(token) > 0 {
-		url.Query().Add("token", token)
+		q := url.Query()
+		q.Set("token", token)
+		url.RawQuery = q.Encode()
 	}
 	res = &Link{}
 	res.URL = url.String()
@@ -59,6 +61,7 @@ func (o *OptLinker) PublicUrl(ctx context.Context, name string) (res *Link, err
 func (o *OptLinker) InternalUrl(ctx context.Context, name string, args ...LinkOptions) (res *Link, err error) {
 	url := o.internalAccessUrl
 	url.Path = path.Join(url.Path, name)
+	res = &Link{}
 	res.URL = url.String()
 	return
 }
@@ -0,0 +1,27 @@
+package vfs
+
+import (
+	"context"
+
+	"github.com/spf13/afero"
+)
+
+// OsFs is an afero.OsFs rooted at a directory which reports changes made
+// outside the Vfs natively where the platform supports it.
+type OsFs struct {
+	*afero.BasePathFs
+	root string
+}
+
+func NewOsFs(root string) *OsFs {
+	return &OsFs{
+		BasePathFs: afero.NewBasePathFs(afero.NewOsFs(), root).(*afero.BasePathFs),
+		root:       root,
+	}
+}
+
+var _ Notifier = (*OsFs)(nil)
+
+func (o *OsFs) Notify(ctx context.Context, fn func(Event)) error {
+	return notifyOs(ctx, o.root, fn)
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// notifyOs reports changes under root with inotify
+func notifyOs(ctx context.Context, root string, fn func(Event)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// a non-blocking fd is handled by the runtime poller, so Close unblocks Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	// watched directory relative to root by watch descriptor
+	dirs := map[int32]string{}
+	add := func(dir string, created bool) {
+		filepath.WalkDir(filepath.Join(root, dir), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, p)
+			rel = filepath.ToSlash(rel)
+			if created && rel != dir {
+				// entries created before the watch is added
+				fn(Event{Op: EventCreate, Path: rel})
+			}
+			if d.IsDir() {
+				if wd, err := syscall.InotifyAddWatch(fd, p, inotifyMask); err == nil {
+					dirs[int32(wd)] = rel
+				}
+			}
+			return nil
+		})
+	}
+	add(".", false)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		// MOVED_FROM waiting for MOVED_TO with the same cookie
+		moved := map[uint32]string{}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			dir, ok := dirs[raw.Wd]
+			if !ok {
+				continue
+			}
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(dirs, raw.Wd)
+				continue
+			}
+			name := path.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+			switch {
+			case raw.Mask&syscall.IN_CREATE != 0:
+				fn(Event{Op: EventCreate, Path: name})
+				if raw.Mask&syscall.IN_ISDIR != 0 {
+					add(name, true)
+				}
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				fn(Event{Op: EventWrite, Path: name})
+			case raw.Mask&syscall.IN_DELETE != 0:
+				fn(Event{Op: EventRemove, Path: name})
+			case raw.Mask&syscall.IN_ATTRIB != 0:
+				fn(Event{Op: EventChmod, Path: name})
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				moved[raw.Cookie] = name
+			case raw.Mask&syscall.IN_MOVED_TO != 0:
+				if old, ok := moved[raw.Cookie]; ok {
+					delete(moved, raw.Cookie)
+					fn(Event{Op: EventRename, Path: name, OldPath: old})
+				} else {
+					fn(Event{Op: EventCreate, Path: name})
+				}
+				if raw.Mask&syscall.IN_ISDIR != 0 {
+					add(name, false)
+				}
+			}
+		}
+		// moved out of the watched tree
+		for _, old := range moved {
+			fn(Event{Op: EventRemove, Path: old})
+		}
+	}
+}
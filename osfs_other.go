@@ -0,0 +1,13 @@
+//go:build !linux
+
+package vfs
+
+import (
+	"context"
+
+	"github.com/spf13/afero"
+)
+
+func notifyOs(ctx context.Context, root string, fn func(Event)) error {
+	return Poll(ctx, afero.NewBasePathFs(afero.NewOsFs(), root), DefaultPollInterval, fn)
+}
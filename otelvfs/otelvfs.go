@@ -0,0 +1,174 @@
+// Package otelvfs traces Vfs operations with OpenTelemetry
+package otelvfs
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/goxiaoy/vfs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/goxiaoy/vfs/otelvfs"
+
+// Attribute keys
+const (
+	MountKey        = attribute.Key("vfs.mount")
+	BackendKey      = attribute.Key("vfs.backend")
+	PathKey         = attribute.Key("vfs.path")
+	NewMountKey     = attribute.Key("vfs.new_mount")
+	NewPathKey      = attribute.Key("vfs.new_path")
+	BytesReadKey    = attribute.Key("vfs.bytes_read")
+	BytesWrittenKey = attribute.Key("vfs.bytes_written")
+)
+
+type config struct {
+	tp trace.TracerProvider
+}
+
+type Option func(c *config)
+
+// WithTracerProvider uses tp instead of the global provider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tp = tp
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{tp: otel.GetTracerProvider()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Interceptor emits a span named "vfs.<operation>" per operation. Backends
+// implementing vfs.ContextFS receive the span in the context, so their calls
+// become child spans. Files opened by the operation are traced by a "vfs.file"
+// span ended on close with the transferred bytes.
+func Interceptor(opts ...Option) vfs.Interceptor {
+	tracer := newConfig(opts).tp.Tracer(instrumentationName)
+	return func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		attrs := []attribute.KeyValue{PathKey.String(op.Unrooted)}
+		if op.MountPoint != nil {
+			attrs = append(attrs, MountKey.String(op.MountPoint.GetPrefix()), BackendKey.String(op.MountPoint.GetFS().Name()))
+		}
+		if op.NewMountPoint != nil {
+			attrs = append(attrs, NewMountKey.String(op.NewMountPoint.GetPrefix()), NewPathKey.String(op.NewUnrooted))
+		}
+		spanCtx, span := tracer.Start(ctx, "vfs."+op.Name, trace.WithAttributes(attrs...))
+		err := next(spanCtx, op)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err == nil && op.File != nil {
+			_, fileSpan := tracer.Start(ctx, "vfs.file", trace.WithAttributes(attrs...))
+			op.File = &file{File: op.File, span: fileSpan}
+		}
+		return err
+	}
+}
+
+// file records transferred bytes to its span
+type file struct {
+	vfs.File
+	span    trace.Span
+	read    int64
+	written int64
+	ended   int32
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	atomic.AddInt64(&f.read, int64(n))
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	atomic.AddInt64(&f.read, int64(n))
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	atomic.AddInt64(&f.written, int64(n))
+	return n, err
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	atomic.AddInt64(&f.written, int64(n))
+	return n, err
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Close() error {
+	err := f.File.Close()
+	if atomic.CompareAndSwapInt32(&f.ended, 0, 1) {
+		f.span.SetAttributes(BytesReadKey.Int64(atomic.LoadInt64(&f.read)), BytesWrittenKey.Int64(atomic.LoadInt64(&f.written)))
+		if err != nil {
+			f.span.RecordError(err)
+			f.span.SetStatus(codes.Error, err.Error())
+		}
+		f.span.End()
+	}
+	return err
+}
+
+type spanKey struct{}
+
+// InstrumentSession emits a span named "<service>.<operation>" per request of sess,
+// including presign requests. Spans are children of the request context, e.g.
+// of the operation span when used with s3.Blob. Clients copy the handlers of
+// the session, so it must be called before creating them.
+func InstrumentSession(sess *session.Session, opts ...Option) {
+	tracer := newConfig(opts).tp.Tracer(instrumentationName)
+	end := func(r *request.Request) {
+		span, ok := r.Context().Value(spanKey{}).(trace.Span)
+		if !ok {
+			return
+		}
+		if r.Error != nil {
+			span.RecordError(r.Error)
+			span.SetStatus(codes.Error, r.Error.Error())
+		}
+		if r.HTTPResponse != nil && r.HTTPResponse.StatusCode != 0 {
+			span.SetAttributes(attribute.Int("http.status_code", r.HTTPResponse.StatusCode))
+		}
+		span.End()
+	}
+	sess.Handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "otelvfs.start",
+		Fn: func(r *request.Request) {
+			ctx, span := tracer.Start(r.Context(), r.ClientInfo.ServiceName+"."+r.Operation.Name,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attribute.String("rpc.service", r.ClientInfo.ServiceName), attribute.String("rpc.method", r.Operation.Name)))
+			if r.ExpireTime > 0 {
+				span.SetAttributes(attribute.Bool("aws.presign", true))
+			}
+			r.SetContext(context.WithValue(ctx, spanKey{}, span))
+		},
+	})
+	// presign requests are only built and signed
+	sess.Handlers.Sign.PushBackNamed(request.NamedHandler{
+		Name: "otelvfs.presign",
+		Fn: func(r *request.Request) {
+			if r.ExpireTime > 0 {
+				end(r)
+			}
+		},
+	})
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{Name: "otelvfs.end", Fn: end})
+}
@@ -0,0 +1,116 @@
+package otelvfs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/s3"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, s := range spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func attr(s sdktrace.ReadOnlySpan, key string) any {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/a", afero.NewMemMapFs()))
+	v.Use(Interceptor(WithTracerProvider(tp)))
+
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("hello"), 0644))
+	_, err := v.Stat("/a/none")
+	assert.Error(t, err)
+
+	spans := recorder.Ended()
+	open := findSpan(spans, "vfs.openFile")
+	if assert.NotNil(t, open) {
+		assert.Equal(t, "/a", attr(open, "vfs.mount"))
+		assert.Equal(t, "MemMapFS", attr(open, "vfs.backend"))
+		assert.Equal(t, "1.txt", attr(open, "vfs.path"))
+	}
+	f := findSpan(spans, "vfs.file")
+	if assert.NotNil(t, f) {
+		assert.Equal(t, int64(5), attr(f, "vfs.bytes_written"))
+	}
+	stat := findSpan(spans, "vfs.stat")
+	if assert.NotNil(t, stat) {
+		assert.Equal(t, codes.Error, stat.Status().Code)
+	}
+}
+
+func TestInstrumentSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`<ListBucketResult><KeyCount>0</KeyCount></ListBucketResult>`))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	InstrumentSession(sess, WithTracerProvider(tp))
+
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/s3", s3.NewBlob(sess, "bucket", url.URL{}, url.URL{}, time.Hour)))
+	v.Use(Interceptor(WithTracerProvider(tp)))
+
+	_, err := v.StatContext(context.Background(), "/s3/none")
+	assert.Error(t, err)
+	_, err = v.PreSignedURL(context.Background(), "/s3/1.txt")
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	stat := findSpan(spans, "vfs.stat")
+	head := findSpan(spans, "s3.HeadObject")
+	if assert.NotNil(t, stat) && assert.NotNil(t, head) {
+		assert.Equal(t, stat.SpanContext().SpanID(), head.Parent().SpanID())
+		assert.Equal(t, int64(404), attr(head, "http.status_code"))
+	}
+	presign := findSpan(spans, "vfs.preSignedURL")
+	put := findSpan(spans, "s3.PutObject")
+	if assert.NotNil(t, presign) && assert.NotNil(t, put) {
+		assert.Equal(t, presign.SpanContext().SpanID(), put.Parent().SpanID())
+		assert.Equal(t, true, attr(put, "aws.presign"))
+	}
+}
@@ -61,26 +61,31 @@ func (trie *PathTrie[T]) clearValue() {
 	trie.hasValue = false
 }
 
-// Get returns the value matched by path. Returns default value for internal
-// nodes or for nodes with a default value.
+// Get returns the value of the deepest node with a value along the path and
+// the rest of the key. Returns default value if no such node exists.
 func (trie *PathTrie[T]) Get(key string) (T, string) {
 	node := trie
 	var pre *PathTrie[T]
 	var prefix []string
+	var matched int
 	for part, i := trie.segmenter(key, 0); part != ""; part, i = trie.segmenter(key, i) {
 		node = node.children[part]
 		if node == nil {
 			break
 		}
 		prefix = append(prefix, part)
-		pre = node
+		// internal nodes do not hold a value, keep the deepest one which does
+		if node.hasValue {
+			pre = node
+			matched = len(prefix)
+		}
 	}
 	var d T
 	if pre == nil {
 		//not found
 		return d, ""
 	}
-	return pre.value, strings.TrimPrefix(strings.TrimPrefix(key, path.Join(prefix...)), "/")
+	return pre.value, strings.TrimPrefix(strings.TrimPrefix(key, path.Join(prefix[:matched]...)), "/")
 }
 
 // Put inserts the value into the trie at the given key, replacing any
@@ -0,0 +1,330 @@
+// Package policy enforces path based authorization on Vfs operations
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/goxiaoy/vfs"
+)
+
+type Action string
+
+const (
+	ActionRead    Action = "read"
+	ActionWrite   Action = "write"
+	ActionDelete  Action = "delete"
+	ActionList    Action = "list"
+	ActionPresign Action = "presign"
+)
+
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Rule grants or denies actions on paths to subjects
+type Rule struct {
+	Effect Effect `json:"effect"`
+	// Subjects are "*", "authenticated", "user:<id>" or "group:<name>"
+	Subjects []string `json:"subjects"`
+	// Paths are slash separated glob patterns of Vfs paths, "**" matches any number of segments
+	Paths   []string `json:"paths"`
+	Actions []Action `json:"actions"`
+	// Labels restricts the rule to mount points with all these labels
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Config is a set of rules. Deny rules take precedence over allow rules, and
+// Default applies when no rule matches.
+type Config struct {
+	Default Effect `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Load decodes a json config. Unknown effects, actions or defaults are
+// rejected rather than ignored, so that a mistyped config fails closed.
+func Load(r io.Reader) (*Config, error) {
+	var cfg Config
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	if !cfg.Default.valid() {
+		return fmt.Errorf("policy: invalid default %q", cfg.Default)
+	}
+	for i, r := range cfg.Rules {
+		if !r.Effect.valid() {
+			return fmt.Errorf("policy: rule %d: invalid effect %q", i, r.Effect)
+		}
+		for _, a := range r.Actions {
+			if !a.valid() {
+				return fmt.Errorf("policy: rule %d: invalid action %q", i, a)
+			}
+		}
+	}
+	return nil
+}
+
+func (e Effect) valid() bool {
+	return e == Allow || e == Deny
+}
+
+func (a Action) valid() bool {
+	switch a {
+	case ActionRead, ActionWrite, ActionDelete, ActionList, ActionPresign, "*":
+		return true
+	}
+	return false
+}
+
+// Engine evaluates a Config which can be replaced at any time
+type Engine struct {
+	cfg atomic.Pointer[Config]
+}
+
+func New(cfg *Config) *Engine {
+	e := &Engine{}
+	e.Update(cfg)
+	return e
+}
+
+// Update replaces the config
+func (e *Engine) Update(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{Default: Deny}
+	}
+	e.cfg.Store(cfg)
+}
+
+// Reload loads the config from the json file name of fsys. The file is opened
+// without identity: to reload from a Vfs using this Engine, use Watch, which
+// reads the FS mounted there directly.
+func (e *Engine) Reload(fsys vfs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg, err := Load(f)
+	if err != nil {
+		return err
+	}
+	e.Update(cfg)
+	return nil
+}
+
+// Watch reloads the config whenever the json file name of v changes, until ctx is done.
+// Errors of reloading are passed to onError if not nil, the previous config is kept.
+// The file is read from the FS mounted at name, bypassing the interceptors of v
+// so that the Engine can not deny its own reload.
+func (e *Engine) Watch(ctx context.Context, v *vfs.Vfs, name string, onError func(error)) error {
+	name = path.Clean(name)
+	mp, unrooted := v.Lookup(name)
+	if mp == nil {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	fsys := mp.GetFS()
+	if err := e.Reload(fsys, unrooted); err != nil {
+		return err
+	}
+	w, err := v.Watch(path.Dir(name), false)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-w.Events():
+				if ev.Path != name || ev.Op&(vfs.EventCreate|vfs.EventWrite|vfs.EventRename) == 0 {
+					continue
+				}
+				if err := e.Reload(fsys, unrooted); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Allowed reports whether id may perform action on the Vfs path p of a mount point with labels
+func (e *Engine) Allowed(id *vfs.Identity, action Action, p string, labels map[string]string) bool {
+	cfg := e.cfg.Load()
+	allowed := false
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if !r.match(id, action, p, labels) {
+			continue
+		}
+		if r.Effect == Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed || cfg.Default == Allow
+}
+
+func (r *Rule) match(id *vfs.Identity, action Action, p string, labels map[string]string) bool {
+	found := false
+	for _, a := range r.Actions {
+		if a == action || a == "*" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	for k, v := range r.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	found = false
+	for _, s := range r.Subjects {
+		if matchSubject(s, id) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	for _, pattern := range r.Paths {
+		if vfs.Match(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSubject(s string, id *vfs.Identity) bool {
+	switch {
+	case s == "*":
+		return true
+	case id == nil:
+		return false
+	case s == "authenticated":
+		return true
+	case strings.HasPrefix(s, "user:"):
+		return id.ID == s[len("user:"):]
+	case strings.HasPrefix(s, "group:"):
+		for _, g := range id.Groups {
+			if g == s[len("group:"):] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Interceptor denies operations not allowed for the identity of the context with fs.ErrPermission
+func (e *Engine) Interceptor() vfs.Interceptor {
+	return func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		id, _ := vfs.IdentityFromContext(ctx)
+		var labels, newLabels map[string]string
+		if op.MountPoint != nil {
+			labels = op.MountPoint.GetLabels()
+		}
+		if op.NewMountPoint != nil {
+			newLabels = op.NewMountPoint.GetLabels()
+		}
+		check := func(action Action, p string, labels map[string]string) error {
+			if !e.Allowed(id, action, p, labels) {
+				return &fs.PathError{Op: op.Name, Path: p, Err: fs.ErrPermission}
+			}
+			return nil
+		}
+		required, ok := actions(op)
+		if !ok {
+			// fail closed on operations added without a mapping
+			return &fs.PathError{Op: op.Name, Path: op.Path, Err: fs.ErrPermission}
+		}
+		for _, action := range required {
+			if err := check(action, op.Path, labels); err != nil {
+				return err
+			}
+		}
+		if op.Name == vfs.OpRename || op.Name == vfs.OpCopy {
+			if err := check(ActionWrite, op.NewPath, newLabels); err != nil {
+				return err
+			}
+		}
+		if err := next(ctx, op); err != nil {
+			return err
+		}
+		if op.File != nil {
+			p := op.Path
+			op.File = &listFile{File: op.File, check: func() error {
+				return check(ActionList, p, labels)
+			}}
+		}
+		return nil
+	}
+}
+
+// actions returns the actions required by op on op.Path, ok is false for unknown operations
+func actions(op *vfs.Operation) (res []Action, ok bool) {
+	switch op.Name {
+	case vfs.OpOpen, vfs.OpStat, vfs.OpPublicUrl, vfs.OpInternalUrl, vfs.OpCopy, vfs.OpGetMetadata, vfs.OpSnapshot,
+		vfs.OpListVersions, vfs.OpOpenVersion:
+		return []Action{ActionRead}, true
+	case vfs.OpListPage:
+		return []Action{ActionList}, true
+	case vfs.OpOpenFile:
+		if op.Flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY {
+			res = append(res, ActionRead)
+		}
+		if op.Flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+			res = append(res, ActionWrite)
+		}
+		return res, true
+	case vfs.OpCreate, vfs.OpMkdir, vfs.OpMkdirAll, vfs.OpChmod, vfs.OpChown, vfs.OpChtimes, vfs.OpSetMetadata,
+		vfs.OpRestoreVersion:
+		return []Action{ActionWrite}, true
+	case vfs.OpRemove, vfs.OpRemoveAll, vfs.OpRename:
+		return []Action{ActionDelete}, true
+	case vfs.OpPreSignedURL:
+		return []Action{ActionRead, ActionPresign}, true
+	}
+	return nil, false
+}
+
+// listFile checks the list action before reading the directory
+type listFile struct {
+	vfs.File
+	check func() error
+}
+
+func (f *listFile) Readdir(count int) ([]os.FileInfo, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f.File.Readdir(count)
+}
+
+func (f *listFile) Readdirnames(n int) ([]string, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f.File.Readdirnames(n)
+}
@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+const config = `{
+	"default": "deny",
+	"rules": [
+		{"effect": "allow", "subjects": ["*"], "paths": ["/public/**"], "actions": ["read", "list"]},
+		{"effect": "allow", "subjects": ["group:staff"], "paths": ["/**"], "actions": ["*"]},
+		{"effect": "deny", "subjects": ["user:bob"], "paths": ["/**"], "actions": ["presign"], "labels": {"tier": "s3"}},
+		{"effect": "deny", "subjects": ["*"], "paths": ["/**/*.secret"], "actions": ["read"]}
+	]
+}`
+
+type linkFs struct {
+	vfs.FS
+}
+
+func (linkFs) PreSignedURL(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	return &vfs.Link{URL: name}, nil
+}
+
+func (linkFs) PublicUrl(ctx context.Context, name string) (*vfs.Link, error) {
+	return &vfs.Link{URL: name}, nil
+}
+
+func (linkFs) InternalUrl(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	return &vfs.Link{URL: name}, nil
+}
+
+func TestInterceptor(t *testing.T) {
+	cfg, err := Load(strings.NewReader(config))
+	assert.NoError(t, err)
+	e := New(cfg)
+
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/s3", linkFs{afero.NewMemMapFs()}, vfs.WithLabels(map[string]string{"tier": "s3"})))
+	assert.NoError(t, afero.WriteFile(v, "/public/1.txt", []byte("1"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/public/2.secret", []byte("2"), 0644))
+	v.Use(e.Interceptor())
+
+	anonymous := context.Background()
+	bob := vfs.WithIdentity(anonymous, &vfs.Identity{ID: "bob", Groups: []string{"staff"}})
+
+	_, err = v.OpenContext(anonymous, "/public/1.txt")
+	assert.NoError(t, err)
+	_, err = v.OpenContext(anonymous, "/public/2.secret")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = v.OpenFileContext(anonymous, "/public/1.txt", 0x1, 0644)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, v.RemoveContext(anonymous, "/public/1.txt"), fs.ErrPermission)
+
+	d, err := v.OpenContext(anonymous, "/public")
+	assert.NoError(t, err)
+	names, err := d.Readdirnames(-1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.txt", "2.secret"}, names)
+
+	assert.NoError(t, v.MkdirAllContext(bob, "/home/bob", 0755))
+	assert.NoError(t, v.RenameContext(bob, "/public/1.txt", "/home/bob/1.txt"))
+	assert.ErrorIs(t, v.RenameContext(anonymous, "/home/bob/1.txt", "/public/1.txt"), fs.ErrPermission)
+	// allowed but not supported by the mounted FS
+	assert.ErrorIs(t, v.Copy(bob, "/home/bob/1.txt", "/home/bob/2.txt"), vfs.ErrNotSupported)
+	assert.ErrorIs(t, v.Copy(anonymous, "/home/bob/1.txt", "/public/1.txt"), fs.ErrPermission)
+
+	// presign is denied for bob on mounts labeled tier=s3 even though reading is allowed
+	_, err = v.PublicUrl(bob, "/s3/1.txt")
+	assert.NoError(t, err)
+	_, err = v.PreSignedURL(bob, "/s3/1.txt")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = v.PreSignedURL(anonymous, "/s3/1.txt")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = v.PreSignedURL(vfs.WithIdentity(anonymous, &vfs.Identity{ID: "alice", Groups: []string{"staff"}}), "/s3/1.txt")
+	assert.NoError(t, err)
+}
+
+func TestInterceptorUnknownOp(t *testing.T) {
+	cfg, err := Load(strings.NewReader(`{"default": "allow", "rules": []}`))
+	assert.NoError(t, err)
+	called := false
+	next := func(ctx context.Context, op *vfs.Operation) error {
+		called = true
+		return nil
+	}
+	err = New(cfg).Interceptor()(context.Background(), &vfs.Operation{Name: "Unknown", Path: "/1.txt"}, next)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.False(t, called)
+}
+
+func TestWatch(t *testing.T) {
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, afero.WriteFile(v, "/etc/policy.json", []byte(`{"default": "deny"}`), 0644))
+
+	e := New(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, e.Watch(ctx, v, "/etc/policy.json", nil))
+	assert.False(t, e.Allowed(nil, ActionRead, "/a", nil))
+
+	assert.NoError(t, afero.WriteFile(v, "/etc/policy.json", []byte(`{"default": "allow"}`), 0644))
+	assert.Eventually(t, func() bool {
+		return e.Allowed(nil, ActionRead, "/a", nil)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{"rules": []}`,
+		`{"default": "Deny"}`,
+		`{"default": "deny", "rules": [{"effect": "Deny", "subjects": ["*"], "paths": ["/**"], "actions": ["read"]}]}`,
+		`{"default": "deny", "rules": [{"subjects": ["*"], "paths": ["/**"], "actions": ["read"]}]}`,
+		`{"default": "deny", "rules": [{"effect": "deny", "subjects": ["*"], "paths": ["/**"], "actions": ["reed"]}]}`,
+	} {
+		_, err := Load(strings.NewReader(config))
+		assert.Error(t, err, config)
+	}
+}
+
+func TestWatchIntercepted(t *testing.T) {
+	v := vfs.New()
+	etc := afero.NewMemMapFs()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/etc", etc))
+	assert.NoError(t, afero.WriteFile(v, "/etc/policy.json", []byte(`{
+		"default": "deny",
+		"rules": [{"effect": "allow", "subjects": ["user:admin"], "paths": ["/etc/**"], "actions": ["*"]}]
+	}`), 0644))
+
+	// the engine denies anonymous reads, before and after loading
+	e := New(nil)
+	v.Use(e.Interceptor())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, e.Watch(ctx, v, "/etc/policy.json", nil))
+	_, err := v.OpenContext(ctx, "/etc/policy.json")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	admin := vfs.WithIdentity(ctx, &vfs.Identity{ID: "admin"})
+	f, err := v.OpenFileContext(admin, "/etc/policy.json", os.O_WRONLY|os.O_TRUNC, 0644)
+	if assert.NoError(t, err) {
+		_, err = f.WriteString(`{"default": "allow"}`)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+	assert.Eventually(t, func() bool {
+		return e.Allowed(nil, ActionRead, "/a", nil)
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,446 @@
+// Package quota limits bytes and files stored under Vfs subtrees
+package quota
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+// Limit of a subtree. Zero values mean unlimited.
+type Limit struct {
+	// Prefix is the Vfs path of the subtree, usually the prefix of a mount point
+	Prefix   string
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// Usage of a subtree, directories are not counted as files
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+type entry struct {
+	limit Limit
+	usage Usage
+}
+
+// Manager tracks usage of subtrees by intercepting Vfs operations
+type Manager struct {
+	mu      sync.Mutex
+	entries []*entry // longer prefix first
+	store   Store
+	dirty   bool
+}
+
+// New creates a Manager, store can be nil if usage is not persisted
+func New(store Store, limits ...Limit) *Manager {
+	m := &Manager{store: store}
+	for _, l := range limits {
+		l.Prefix = path.Clean("/" + l.Prefix)
+		m.entries = append(m.entries, &entry{limit: l})
+	}
+	sort.Slice(m.entries, func(i, j int) bool {
+		return len(m.entries[i].limit.Prefix) > len(m.entries[j].limit.Prefix)
+	})
+	return m
+}
+
+// Init loads persisted usage and scans fsys for subtrees without persisted usage.
+// All subtrees are scanned if the persisted usage is corrupt.
+func (m *Manager) Init(ctx context.Context, fsys vfs.FS) error {
+	var stored map[string]Usage
+	if m.store != nil {
+		var err error
+		if stored, err = m.store.Load(ctx); err != nil && !errors.Is(err, ErrCorrupt) {
+			return err
+		}
+	}
+	for _, e := range m.entries {
+		if u, ok := stored[e.limit.Prefix]; ok {
+			m.mu.Lock()
+			e.usage = u
+			m.mu.Unlock()
+			continue
+		}
+		if err := m.Scan(ctx, fsys, e.limit.Prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan recounts the usage of the subtree prefix by walking fsys
+func (m *Manager) Scan(ctx context.Context, fsys vfs.FS, prefix string) error {
+	prefix = path.Clean("/" + prefix)
+	u, err := walkUsage(ctx, fsys, prefix)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.limit.Prefix == prefix {
+			e.usage = u
+			m.dirty = true
+		}
+	}
+	return nil
+}
+
+// Usage returns the usage of the subtree prefix
+func (m *Manager) Usage(prefix string) (Usage, bool) {
+	prefix = path.Clean("/" + prefix)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.limit.Prefix == prefix {
+			return e.usage, true
+		}
+	}
+	return Usage{}, false
+}
+
+// Save persists usage if it changed since the last save
+func (m *Manager) Save(ctx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	usage := map[string]Usage{}
+	for _, e := range m.entries {
+		usage[e.limit.Prefix] = e.usage
+	}
+	m.dirty = false
+	m.mu.Unlock()
+	if err := m.store.Save(ctx, usage); err != nil {
+		m.mu.Lock()
+		m.dirty = true
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// AutoSave saves usage every interval until ctx is done
+func (m *Manager) AutoSave(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return m.Save(context.Background())
+		case <-ticker.C:
+			m.Save(ctx)
+		}
+	}
+}
+
+// reserve adds delta to the usage of subtrees containing p, and fails with
+// ENOSPC without changing anything if a limit would be exceeded
+func (m *Manager) reserve(p string, delta Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matched []*entry
+	for _, e := range m.entries {
+		if !within(p, e.limit.Prefix) {
+			continue
+		}
+		if delta.Bytes > 0 && e.limit.MaxBytes > 0 && e.usage.Bytes+delta.Bytes > e.limit.MaxBytes {
+			return syscall.ENOSPC
+		}
+		if delta.Files > 0 && e.limit.MaxFiles > 0 && e.usage.Files+delta.Files > e.limit.MaxFiles {
+			return syscall.ENOSPC
+		}
+		matched = append(matched, e)
+	}
+	for _, e := range matched {
+		e.usage.Bytes += delta.Bytes
+		e.usage.Files += delta.Files
+		m.dirty = true
+	}
+	return nil
+}
+
+// move transfers u from the subtrees containing oldpath to the ones containing
+// newpath, where replaced is the usage released by overwriting newpath
+func (m *Manager) move(oldpath, newpath string, u, replaced Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var from, to []*entry
+	for _, e := range m.entries {
+		inOld, inNew := within(oldpath, e.limit.Prefix), within(newpath, e.limit.Prefix)
+		switch {
+		case inOld && !inNew:
+			from = append(from, e)
+		case inNew && !inOld:
+			if e.limit.MaxBytes > 0 && e.usage.Bytes+u.Bytes-replaced.Bytes > e.limit.MaxBytes ||
+				e.limit.MaxFiles > 0 && e.usage.Files+u.Files-replaced.Files > e.limit.MaxFiles {
+				return syscall.ENOSPC
+			}
+			to = append(to, e)
+		}
+	}
+	for _, e := range from {
+		e.usage.Bytes -= u.Bytes
+		e.usage.Files -= u.Files
+	}
+	for _, e := range to {
+		e.usage.Bytes += u.Bytes
+		e.usage.Files += u.Files
+	}
+	var released bool
+	for _, e := range m.entries {
+		if within(newpath, e.limit.Prefix) {
+			e.usage.Bytes -= replaced.Bytes
+			e.usage.Files -= replaced.Files
+			released = true
+		}
+	}
+	m.dirty = m.dirty || len(from)+len(to) > 0 || released
+	return nil
+}
+
+// tracked reports whether p is under any limit
+func (m *Manager) tracked(p string) bool {
+	for _, e := range m.entries {
+		if within(p, e.limit.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func within(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// Interceptor tracks usage of operations and fails them with ENOSPC when a limit is exceeded
+func (m *Manager) Interceptor() vfs.Interceptor {
+	return func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		if op.MountPoint == nil || !m.tracked(op.Path) && (op.Name != vfs.OpRename && op.Name != vfs.OpCopy || !m.tracked(op.NewPath)) {
+			return next(ctx, op)
+		}
+		fsys := vfs.AsContextFS(op.MountPoint.GetFS())
+		fail := func(err error) error {
+			return &fs.PathError{Op: op.Name, Path: op.Path, Err: err}
+		}
+		switch op.Name {
+		case vfs.OpCreate, vfs.OpOpenFile:
+			if op.Name == vfs.OpOpenFile && op.Flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+				return next(ctx, op)
+			}
+			var size int64
+			info, err := fsys.StatContext(ctx, op.Unrooted)
+			exists := err == nil
+			if exists {
+				size = info.Size()
+			}
+			var delta Usage
+			if !exists && op.Flag&os.O_CREATE != 0 {
+				delta.Files = 1
+			}
+			if exists && op.Flag&os.O_TRUNC != 0 {
+				delta.Bytes = -size
+				size = 0
+			}
+			if err := m.reserve(op.Path, delta); err != nil {
+				return fail(err)
+			}
+			if err := next(ctx, op); err != nil {
+				m.reserve(op.Path, Usage{Bytes: -delta.Bytes, Files: -delta.Files})
+				return err
+			}
+			op.File = &file{File: op.File, m: m, path: op.Path, size: size, append: op.Flag&os.O_APPEND != 0}
+			return nil
+		case vfs.OpRemove, vfs.OpRemoveAll:
+			u, err := walkUsage(ctx, op.MountPoint.GetFS(), op.Unrooted)
+			if err != nil {
+				return next(ctx, op)
+			}
+			if err := next(ctx, op); err != nil {
+				return err
+			}
+			m.reserve(op.Path, Usage{Bytes: -u.Bytes, Files: -u.Files})
+			return nil
+		case vfs.OpRename:
+			u, err := walkUsage(ctx, op.MountPoint.GetFS(), op.Unrooted)
+			if err != nil {
+				return next(ctx, op)
+			}
+			// the destination is replaced
+			var replaced Usage
+			if op.NewMountPoint != nil {
+				replaced, _ = walkUsage(ctx, op.NewMountPoint.GetFS(), op.NewUnrooted)
+			}
+			if err := m.move(op.Path, op.NewPath, u, replaced); err != nil {
+				return fail(err)
+			}
+			if err := next(ctx, op); err != nil {
+				m.move(op.NewPath, op.Path, u, Usage{})
+				m.reserve(op.NewPath, replaced)
+				return err
+			}
+			return nil
+		case vfs.OpCopy:
+			u, err := walkUsage(ctx, op.MountPoint.GetFS(), op.Unrooted)
+			if err != nil || op.NewMountPoint == nil {
+				return next(ctx, op)
+			}
+			// the destination is replaced
+			replaced, _ := walkUsage(ctx, op.NewMountPoint.GetFS(), op.NewUnrooted)
+			delta := Usage{Bytes: u.Bytes - replaced.Bytes, Files: u.Files - replaced.Files}
+			if err := m.reserve(op.NewPath, delta); err != nil {
+				return fail(err)
+			}
+			if err := next(ctx, op); err != nil {
+				m.reserve(op.NewPath, Usage{Bytes: -delta.Bytes, Files: -delta.Files})
+				return err
+			}
+			return nil
+		case vfs.OpRestoreVersion:
+			versioner, ok := op.MountPoint.GetFS().(vfs.Versioner)
+			if !ok {
+				return next(ctx, op)
+			}
+			versions, err := versioner.ListVersions(ctx, op.Unrooted)
+			if err != nil {
+				return next(ctx, op)
+			}
+			// the current version is replaced
+			var delta Usage
+			for _, version := range versions {
+				if version.ID == op.VersionID {
+					delta.Bytes = version.Size
+				}
+			}
+			if info, err := fsys.StatContext(ctx, op.Unrooted); err == nil {
+				delta.Bytes -= info.Size()
+			} else {
+				delta.Files = 1
+			}
+			if err := m.reserve(op.Path, delta); err != nil {
+				return fail(err)
+			}
+			if err := next(ctx, op); err != nil {
+				m.reserve(op.Path, Usage{Bytes: -delta.Bytes, Files: -delta.Files})
+				return err
+			}
+			return nil
+		}
+		return next(ctx, op)
+	}
+}
+
+// walkUsage sums regular files under name of fsys
+func walkUsage(ctx context.Context, fsys vfs.FS, name string) (Usage, error) {
+	var u Usage
+	info, err := vfs.AsContextFS(fsys).StatContext(ctx, name)
+	if err != nil {
+		return u, err
+	}
+	if !info.IsDir() {
+		return Usage{Bytes: info.Size(), Files: 1}, nil
+	}
+	err = afero.Walk(fsys, name, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			u.Bytes += info.Size()
+			u.Files++
+		}
+		return nil
+	})
+	return u, err
+}
+
+// file accounts bytes written to it
+type file struct {
+	vfs.File
+	m      *Manager
+	path   string
+	size   int64
+	append bool
+}
+
+func (f *file) grow(off int64, n int) int64 {
+	if end := off + int64(n); end > f.size {
+		return end - f.size
+	}
+	return 0
+}
+
+func (f *file) offset() int64 {
+	if f.append {
+		return f.size
+	}
+	off, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return f.size
+	}
+	return off
+}
+
+func (f *file) writeAt(p []byte, off int64, write func() (int, error)) (int, error) {
+	growth := f.grow(off, len(p))
+	if err := f.m.reserve(f.path, Usage{Bytes: growth}); err != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.path, Err: err}
+	}
+	n, err := write()
+	// give back what was not written
+	if actual := f.grow(off, n); actual < growth {
+		f.m.reserve(f.path, Usage{Bytes: actual - growth})
+		growth = actual
+	}
+	f.size += growth
+	return n, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return f.writeAt(p, f.offset(), func() (int, error) {
+		return f.File.Write(p)
+	})
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	return f.writeAt(p, off, func() (int, error) {
+		return f.File.WriteAt(p, off)
+	})
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Truncate(size int64) error {
+	delta := size - f.size
+	if err := f.m.reserve(f.path, Usage{Bytes: delta}); err != nil {
+		return &fs.PathError{Op: "truncate", Path: f.path, Err: err}
+	}
+	if err := f.File.Truncate(size); err != nil {
+		f.m.reserve(f.path, Usage{Bytes: -delta})
+		return err
+	}
+	f.size = size
+	return nil
+}
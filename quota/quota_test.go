@@ -0,0 +1,113 @@
+package quota
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/t", afero.NewMemMapFs()))
+	assert.NoError(t, afero.WriteFile(v, "/t/a/seed.txt", []byte("12345"), 0644))
+
+	state := afero.NewMemMapFs()
+	m := New(NewFileStore(state, "quota.json"), Limit{Prefix: "/t", MaxBytes: 20, MaxFiles: 3}, Limit{Prefix: "/t/a", MaxBytes: 10})
+	assert.NoError(t, m.Init(ctx, v))
+	u, _ := m.Usage("/t")
+	assert.Equal(t, Usage{Bytes: 5, Files: 1}, u)
+	v.Use(m.Interceptor())
+
+	// nested limit of /t/a
+	f, err := v.Create("/t/a/1.txt")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("123456"))
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+	_, err = f.Write([]byte("12345"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	u, _ = m.Usage("/t/a")
+	assert.Equal(t, Usage{Bytes: 10, Files: 2}, u)
+
+	// overwrite releases the previous size
+	assert.NoError(t, afero.WriteFile(v, "/t/a/1.txt", []byte("1"), 0644))
+	u, _ = m.Usage("/t/a")
+	assert.Equal(t, Usage{Bytes: 6, Files: 2}, u)
+
+	assert.NoError(t, afero.WriteFile(v, "/t/2.txt", []byte("2"), 0644))
+	_, err = v.Create("/t/3.txt")
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+
+	// rename out of /t/a
+	assert.NoError(t, v.Rename("/t/a/seed.txt", "/t/seed.txt"))
+	u, _ = m.Usage("/t/a")
+	assert.Equal(t, Usage{Bytes: 1, Files: 1}, u)
+	u, _ = m.Usage("/t")
+	assert.Equal(t, Usage{Bytes: 7, Files: 3}, u)
+
+	f, err = v.OpenFile("/t/seed.txt", 0x1, 0644)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Truncate(2))
+	assert.NoError(t, f.Close())
+	assert.NoError(t, v.Remove("/t/2.txt"))
+	u, _ = m.Usage("/t")
+	assert.Equal(t, Usage{Bytes: 3, Files: 2}, u)
+
+	assert.NoError(t, v.RemoveAll("/t/a"))
+	u, _ = m.Usage("/t")
+	assert.Equal(t, Usage{Bytes: 2, Files: 1}, u)
+
+	// restart loads the persisted usage
+	assert.NoError(t, m.Save(ctx))
+	m = New(NewFileStore(state, "quota.json"), Limit{Prefix: "/t", MaxBytes: 20})
+	assert.NoError(t, m.Init(ctx, afero.NewMemMapFs()))
+	u, _ = m.Usage("/t")
+	assert.Equal(t, Usage{Bytes: 2, Files: 1}, u)
+}
+
+func TestQuotaRenameReplace(t *testing.T) {
+	ctx := context.Background()
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, afero.WriteFile(v, "/a/1.txt", []byte("12345"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/b/1.txt", []byte("12345"), 0644))
+
+	m := New(nil, Limit{Prefix: "/b", MaxBytes: 5, MaxFiles: 1})
+	assert.NoError(t, m.Init(ctx, v))
+	v.Use(m.Interceptor())
+
+	// the replaced file makes room for the renamed one
+	assert.NoError(t, v.Rename("/a/1.txt", "/b/1.txt"))
+	u, _ := m.Usage("/b")
+	assert.Equal(t, Usage{Bytes: 5, Files: 1}, u)
+}
+
+func TestQuotaCorruptStore(t *testing.T) {
+	ctx := context.Background()
+	fsys := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fsys, "/t/1.txt", []byte("123"), 0644))
+
+	state := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(state, "quota.json", []byte(`{"/t": {"bytes"`), 0644))
+	store := NewFileStore(state, "quota.json")
+	_, err := store.Load(ctx)
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	// falls back to scanning and rewrites the store
+	m := New(store, Limit{Prefix: "/t"})
+	assert.NoError(t, m.Init(ctx, fsys))
+	u, _ := m.Usage("/t")
+	assert.Equal(t, Usage{Bytes: 3, Files: 1}, u)
+	assert.NoError(t, m.Save(ctx))
+	stored, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, u, stored["/t"])
+	exists, _ := afero.Exists(state, "quota.json.tmp")
+	assert.False(t, exists)
+}
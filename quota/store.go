@@ -0,0 +1,65 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+// ErrCorrupt is returned by a Store whose persisted usage can not be decoded
+var ErrCorrupt = errors.New("quota: corrupt usage")
+
+// Store persists usage so that restarts do not need to scan
+type Store interface {
+	Load(ctx context.Context) (map[string]Usage, error)
+	Save(ctx context.Context, usage map[string]Usage) error
+}
+
+// FileStore stores usage as json file of a FS
+type FileStore struct {
+	fsys vfs.FS
+	name string
+}
+
+var _ Store = (*FileStore)(nil)
+
+func NewFileStore(fsys vfs.FS, name string) *FileStore {
+	return &FileStore{fsys: fsys, name: name}
+}
+
+func (s *FileStore) Load(ctx context.Context) (map[string]Usage, error) {
+	b, err := afero.ReadFile(s.fsys, s.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var usage map[string]Usage
+	if err := json.Unmarshal(b, &usage); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, s.name, err)
+	}
+	return usage, nil
+}
+
+func (s *FileStore) Save(ctx context.Context, usage map[string]Usage) error {
+	b, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	// write aside and rename so that a crash never leaves a truncated file
+	tmp := s.name + ".tmp"
+	if err := afero.WriteFile(s.fsys, tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := s.fsys.Rename(tmp, s.name); err != nil {
+		s.fsys.Remove(tmp)
+		return err
+	}
+	return nil
+}
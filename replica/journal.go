@@ -0,0 +1,156 @@
+package replica
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+// OpJournal is passed to the error handler for failures of the journal of queued writes
+const OpJournal = "journal"
+
+// WithJournal persists the queues of replicas as write ahead journals in dir
+// of fsys, one file per replica index. Writes queued at Close or at a crash
+// are replayed by the next FS created with the same journal and replicas.
+// Failures of the journal are passed to the error handler, queues are then
+// kept in memory only.
+func WithJournal(fsys vfs.FS, dir string) Option {
+	return func(f *FS) {
+		f.journalFS = fsys
+		f.journalDir = dir
+	}
+}
+
+// record of a journal, a record without task marks the task Seq as done
+type record struct {
+	Seq  uint64 `json:"seq"`
+	Task *task  `json:"task,omitempty"`
+}
+
+// journal appends the records of the queue of a replica
+type journal struct {
+	fs   vfs.FS
+	name string
+	file vfs.File
+}
+
+// replay loads the pending tasks of the journals of all replicas
+func (f *FS) replay() {
+	if err := f.journalFS.MkdirAll(f.journalDir, 0755); err != nil {
+		f.onError(-1, OpJournal, f.journalDir, err)
+		return
+	}
+	for _, r := range f.replicas {
+		name := path.Join(f.journalDir, fmt.Sprintf("%d.jsonl", r.index))
+		j, pending, err := openJournal(f.journalFS, name)
+		if err != nil {
+			f.onError(r.index, OpJournal, name, err)
+			continue
+		}
+		for _, t := range pending {
+			if t.Src < 0 || t.Src >= len(f.replicas) || t.Src == r.index {
+				f.onError(r.index, OpJournal, t.Name, fmt.Errorf("replica %d of queued %s not found", t.Src, t.Op))
+				continue
+			}
+			r.queue = append(r.queue, t)
+		}
+		if len(pending) > 0 {
+			r.seq = pending[len(pending)-1].seq
+		}
+		r.journal = j
+	}
+}
+
+// openJournal reads the journal name and compacts it to the tasks which are
+// not done, which it returns in order
+func openJournal(fsys vfs.FS, name string) (*journal, []task, error) {
+	b, err := afero.ReadFile(fsys, name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	var tasks []task
+	done := map[uint64]bool{}
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		var rec record
+		if len(line) == 0 || json.Unmarshal(line, &rec) != nil {
+			// torn by a crash while appending
+			continue
+		}
+		if rec.Task == nil {
+			done[rec.Seq] = true
+			continue
+		}
+		t := *rec.Task
+		t.seq = rec.Seq
+		tasks = append(tasks, t)
+	}
+	var pending []task
+	for _, t := range tasks {
+		if !done[t.seq] {
+			pending = append(pending, t)
+		}
+	}
+	j := &journal{fs: fsys, name: name}
+	if err := j.rewrite(pending); err != nil {
+		return nil, nil, err
+	}
+	return j, pending, nil
+}
+
+// rewrite replaces the journal by the records of tasks and opens it for appending
+func (j *journal) rewrite(tasks []task) error {
+	var buf bytes.Buffer
+	for i := range tasks {
+		b, err := json.Marshal(record{Seq: tasks[i].seq, Task: &tasks[i]})
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	tmp := j.name + ".tmp"
+	if err := afero.WriteFile(j.fs, tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := j.fs.Rename(tmp, j.name); err != nil {
+		return err
+	}
+	file, err := j.fs.OpenFile(j.name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	return nil
+}
+
+// append writes rec and syncs it before returning
+func (j *journal) append(rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// reset empties the journal once all tasks are done
+func (j *journal) reset() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,297 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// OpRepair is passed to the error handler for failures of background repairs
+const OpRepair = "repair"
+
+// Actions of Repair
+const (
+	ActionMkdir  = "mkdir"
+	ActionCopy   = "copy"
+	ActionRemove = "remove"
+)
+
+type RepairOptions struct {
+	// Checksum compares the content of files with the same size which are not
+	// newer in the replica, files are otherwise copied again if modified in
+	// the source after the replica
+	Checksum bool
+	// DeleteExtra removes files missing in the source replica, but the ones
+	// modified since the repair started
+	DeleteExtra bool
+	// DryRun only reports the divergence
+	DryRun bool
+}
+
+// RepairAction fixes the divergence of a path in a replica
+type RepairAction struct {
+	Replica int
+	Path    string
+	Action  string
+}
+
+// Repair compares the listings of the first healthy replica with the other
+// replicas and fixes missing directories and missing or different files.
+// Entries modified in a replica after the source are never overwritten nor
+// removed. Replicas marked down or with queued writes are skipped: queues
+// must be drained, e.g. with Flush, before a replica can be repaired. It
+// returns the actions taken and the first error, other replicas are repaired
+// regardless of errors.
+func (f *FS) Repair(ctx context.Context, opts RepairOptions) ([]RepairAction, error) {
+	candidates := f.candidates()
+	src := candidates[0]
+	var (
+		actions []RepairAction
+		res     error
+	)
+	start := f.now()
+	for _, r := range candidates[1:] {
+		if !f.healthy(r) || f.queued(r) {
+			continue
+		}
+		rp := &repair{ctx: ctx, opts: opts, src: src.fs, dst: r.fs, replica: r.index, start: start}
+		err := rp.dir("")
+		actions = append(actions, rp.actions...)
+		if err != nil {
+			f.result(r, err)
+			if res == nil {
+				res = err
+			}
+		}
+	}
+	return actions, res
+}
+
+type repair struct {
+	ctx     context.Context
+	opts    RepairOptions
+	src     vfs.ContextFS
+	dst     vfs.ContextFS
+	replica int
+	start   time.Time
+	actions []RepairAction
+}
+
+func (rp *repair) act(action, name string, fn func() error) error {
+	rp.actions = append(rp.actions, RepairAction{Replica: rp.replica, Path: name, Action: action})
+	if rp.opts.DryRun {
+		return nil
+	}
+	return fn()
+}
+
+// dir repairs the entries of dir, "" being the root
+func (rp *repair) dir(dir string) error {
+	srcInfos, err := readDir(rp.ctx, rp.src, dir)
+	if err != nil {
+		return err
+	}
+	dstInfos, err := readDir(rp.ctx, rp.dst, dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := map[string]os.FileInfo{}
+	for _, info := range dstInfos {
+		existing[info.Name()] = info
+	}
+	for _, info := range srcInfos {
+		name := path.Join("/", dir, info.Name())
+		dstInfo, ok := existing[info.Name()]
+		delete(existing, info.Name())
+		if ok && info.IsDir() != dstInfo.IsDir() && newer(dstInfo, info.ModTime()) {
+			// replaced in the replica after the source
+			continue
+		}
+		if info.IsDir() {
+			if !ok || !dstInfo.IsDir() {
+				if err := rp.act(ActionMkdir, name, func() error {
+					if ok {
+						if err := rp.dst.RemoveAllContext(rp.ctx, name); err != nil {
+							return err
+						}
+					}
+					return rp.dst.MkdirAllContext(rp.ctx, name, info.Mode().Perm())
+				}); err != nil {
+					return err
+				}
+				if rp.opts.DryRun {
+					// nothing to compare with
+					rp.missing(name)
+					continue
+				}
+			}
+			if err := rp.dir(name); err != nil {
+				return err
+			}
+			continue
+		}
+		same, err := rp.same(name, info, dstInfo)
+		if err != nil {
+			return err
+		}
+		if same {
+			continue
+		}
+		if err := rp.act(ActionCopy, name, func() error {
+			if ok && dstInfo.IsDir() {
+				if err := rp.dst.RemoveAllContext(rp.ctx, name); err != nil {
+					return err
+				}
+			}
+			return copyFile(rp.ctx, rp.src, rp.dst, name)
+		}); err != nil {
+			return err
+		}
+	}
+	if !rp.opts.DeleteExtra {
+		return nil
+	}
+	for _, info := range dstInfos {
+		if _, ok := existing[info.Name()]; !ok {
+			continue
+		}
+		if err := rp.extra(path.Join("/", dir, info.Name()), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extra removes the entry name missing in the source, entries of a directory
+// modified since the repair started are kept
+func (rp *repair) extra(name string, info os.FileInfo) error {
+	if rp.modified(info) {
+		return nil
+	}
+	if info.IsDir() {
+		recent, err := rp.recent(name)
+		if err != nil {
+			return err
+		}
+		if recent {
+			infos, err := readDir(rp.ctx, rp.dst, name)
+			if err != nil {
+				return err
+			}
+			for _, info := range infos {
+				if err := rp.extra(path.Join(name, info.Name()), info); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return rp.act(ActionRemove, name, func() error {
+		return rp.dst.RemoveAllContext(rp.ctx, name)
+	})
+}
+
+// recent reports whether the replica directory dir contains entries modified
+// since the repair started
+func (rp *repair) recent(dir string) (bool, error) {
+	infos, err := readDir(rp.ctx, rp.dst, dir)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if rp.modified(info) {
+			return true, nil
+		}
+		if info.IsDir() {
+			if recent, err := rp.recent(path.Join(dir, info.Name())); recent || err != nil {
+				return recent, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// modified reports whether info may have been modified since the repair
+// started, entries of the same second are considered modified
+func (rp *repair) modified(info os.FileInfo) bool {
+	return !info.ModTime().Truncate(time.Second).Before(rp.start.Truncate(time.Second))
+}
+
+// newer reports whether info was modified after t. Replicas may store seconds only.
+func newer(info os.FileInfo, t time.Time) bool {
+	return info.ModTime().Truncate(time.Second).After(t.Truncate(time.Second))
+}
+
+// missing reports the content of a directory missing in the replica
+func (rp *repair) missing(dir string) {
+	infos, _ := readDir(rp.ctx, rp.src, dir)
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			rp.actions = append(rp.actions, RepairAction{Replica: rp.replica, Path: name, Action: ActionMkdir})
+			rp.missing(name)
+		} else {
+			rp.actions = append(rp.actions, RepairAction{Replica: rp.replica, Path: name, Action: ActionCopy})
+		}
+	}
+}
+
+// same reports whether the file in the replica matches the source, or is
+// newer and must not be overwritten by a stale source
+func (rp *repair) same(name string, info, dstInfo os.FileInfo) (bool, error) {
+	if dstInfo == nil || dstInfo.IsDir() {
+		return false, nil
+	}
+	if newer(dstInfo, info.ModTime()) {
+		return true, nil
+	}
+	if dstInfo.Size() != info.Size() {
+		return false, nil
+	}
+	if !rp.opts.Checksum {
+		// replicas are written after the source
+		return !newer(info, dstInfo.ModTime()), nil
+	}
+	srcSum, err := checksum(rp.ctx, rp.src, name)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := checksum(rp.ctx, rp.dst, name)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+func readDir(ctx context.Context, fsys vfs.ContextFS, dir string) ([]os.FileInfo, error) {
+	file, err := fsys.OpenContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	return infos, nil
+}
+
+func checksum(ctx context.Context, fsys vfs.ContextFS, name string) ([]byte, error) {
+	file, err := fsys.OpenContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,665 @@
+// Package replica mirrors a FS to several replicas, e.g. a local disk and an
+// S3 bucket, so that reads survive the outage of a replica
+package replica
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// FS writes to all replicas and reads from the first healthy one.
+//
+// Writes are applied to the first healthy replica, then replicated to the
+// others, synchronously by default or through per replica in memory queues
+// with WithAsync. A write succeeds once the first replica is written: in sync
+// mode, replicas marked down or failing are caught up through their queue
+// too. Queued writes are lost on restart unless they are journaled with
+// WithJournal, run Repair to fix the resulting divergence. Files are
+// replicated on Close by copying them from the replica they were written to.
+type FS struct {
+	replicas      []*replica
+	async         bool
+	retryInterval time.Duration
+	downTime      time.Duration
+	isFailure     func(err error) bool
+	onError       func(replica int, op, name string, err error)
+	journalFS     vfs.FS
+	journalDir    string
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	now           func() time.Time
+}
+
+var (
+	_ vfs.FS        = (*FS)(nil)
+	_ vfs.ContextFS = (*FS)(nil)
+	_ vfs.Linker    = (*FS)(nil)
+)
+
+type Option func(f *FS)
+
+// WithAsync replicates writes in the background, the write returns once the
+// first healthy replica is written
+func WithAsync() Option {
+	return func(f *FS) {
+		f.async = true
+	}
+}
+
+// WithRetryInterval sets the delay before retrying a queued write failed by a
+// degraded replica, defaults to 5s
+func WithRetryInterval(d time.Duration) Option {
+	return func(f *FS) {
+		f.retryInterval = d
+	}
+}
+
+// WithDownTime sets how long a replica failing with a backend error is skipped
+// by reads and writes, defaults to 30s
+func WithDownTime(d time.Duration) Option {
+	return func(f *FS) {
+		f.downTime = d
+	}
+}
+
+// WithIsFailure classifies errors marking a replica down, defaults to vfs.IsBackendFailure
+func WithIsFailure(isFailure func(err error) bool) Option {
+	return func(f *FS) {
+		f.isFailure = isFailure
+	}
+}
+
+// WithErrorHandler is called with errors of replication and repair, the write
+// itself having succeeded on another replica
+func WithErrorHandler(h func(replica int, op, name string, err error)) Option {
+	return func(f *FS) {
+		f.onError = h
+	}
+}
+
+// WithRepair runs Repair every interval in the background until Close
+func WithRepair(interval time.Duration, opts RepairOptions) Option {
+	return func(f *FS) {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-f.ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if _, err := f.Repair(f.ctx, opts); err != nil && f.ctx.Err() == nil {
+					f.onError(-1, OpRepair, "", err)
+				}
+			}
+		}()
+	}
+}
+
+// New mirrors replicas, the first one is preferred for reads and writes
+func New(replicas []vfs.FS, opts ...Option) *FS {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &FS{
+		retryInterval: 5 * time.Second,
+		downTime:      30 * time.Second,
+		isFailure:     vfs.IsBackendFailure,
+		onError:       func(int, string, string, error) {},
+		ctx:           ctx,
+		cancel:        cancel,
+		now:           time.Now,
+	}
+	for i, fsys := range replicas {
+		f.replicas = append(f.replicas, &replica{index: i, fs: vfs.AsContextFS(fsys), inner: fsys, wake: make(chan struct{}, 1)})
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.journalFS != nil {
+		f.replay()
+	}
+	for _, r := range f.replicas {
+		f.wg.Add(1)
+		go f.work(r)
+	}
+	return f
+}
+
+// Close stops background replication and repair. Pending writes are kept in
+// the journal with WithJournal, and dropped otherwise.
+func (f *FS) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	var res error
+	for _, r := range f.replicas {
+		if r.journal == nil {
+			continue
+		}
+		if err := r.journal.close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+type replica struct {
+	index     int
+	fs        vfs.ContextFS
+	inner     vfs.FS
+	mu        sync.Mutex
+	downUntil time.Time
+	lastErr   error
+	queue     []task
+	seq       uint64 // of the last queued task
+	journal   *journal
+	wake      chan struct{}
+}
+
+// task replicates a write to a replica, it must be idempotent. Tasks are
+// plain data so that they can be journaled.
+type task struct {
+	Op      string `json:"op"`
+	Name    string `json:"name"`
+	NewName string `json:"newName,omitempty"`
+	// Src is the index of the replica the write was applied to
+	Src   int         `json:"src"`
+	Perm  os.FileMode `json:"perm,omitempty"`
+	UID   int         `json:"uid,omitempty"`
+	GID   int         `json:"gid,omitempty"`
+	Atime time.Time   `json:"atime,omitempty"`
+	Mtime time.Time   `json:"mtime,omitempty"`
+	seq   uint64
+}
+
+// apply replicates t to dst
+func (f *FS) apply(ctx context.Context, t task, dst vfs.ContextFS) error {
+	switch t.Op {
+	case vfs.OpMkdir, vfs.OpMkdirAll:
+		return dst.MkdirAllContext(ctx, t.Name, t.Perm)
+	case vfs.OpRemove:
+		return ignore(dst.RemoveContext(ctx, t.Name), fs.ErrNotExist)
+	case vfs.OpRemoveAll:
+		return dst.RemoveAllContext(ctx, t.Name)
+	case vfs.OpRename:
+		err := dst.RenameContext(ctx, t.Name, t.NewName)
+		if err == nil || f.isFailure(err) {
+			return err
+		}
+		// the replica diverged, e.g. missing oldname, copy the renamed file instead.
+		// Directories are left to Repair.
+		src := f.replicas[t.Src].fs
+		if info, statErr := src.StatContext(ctx, t.NewName); statErr != nil || info.IsDir() {
+			return err
+		}
+		if err := copyFile(ctx, src, dst, t.NewName); err != nil {
+			return err
+		}
+		return dst.RemoveAllContext(ctx, t.Name)
+	case vfs.OpChmod:
+		return ignore(dst.ChmodContext(ctx, t.Name, t.Perm), vfs.ErrNotSupported)
+	case vfs.OpChown:
+		return ignore(dst.ChownContext(ctx, t.Name, t.UID, t.GID), vfs.ErrNotSupported)
+	case vfs.OpChtimes:
+		return ignore(dst.ChtimesContext(ctx, t.Name, t.Atime, t.Mtime), vfs.ErrNotSupported)
+	case vfs.OpCreate:
+		return copyFile(ctx, f.replicas[t.Src].fs, dst, t.Name)
+	}
+	return &fs.PathError{Op: t.Op, Path: t.Name, Err: vfs.ErrNotSupported}
+}
+
+// Status of a replica
+type Status struct {
+	Healthy bool
+	// Pending is the number of queued writes
+	Pending   int
+	LastError error
+}
+
+// Status reports the status of replicas in order
+func (f *FS) Status() []Status {
+	res := make([]Status, len(f.replicas))
+	now := f.now()
+	for i, r := range f.replicas {
+		r.mu.Lock()
+		res[i] = Status{Healthy: !now.Before(r.downUntil), Pending: len(r.queue), LastError: r.lastErr}
+		r.mu.Unlock()
+	}
+	return res
+}
+
+// Pending returns the number of queued writes of all replicas
+func (f *FS) Pending() int {
+	n := 0
+	for _, s := range f.Status() {
+		n += s.Pending
+	}
+	return n
+}
+
+// Flush waits until all queued writes are replicated
+func (f *FS) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for f.Pending() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// result records the outcome of an operation on r, it reports whether r failed
+func (f *FS) result(r *replica, err error) bool {
+	if err != nil && f.isFailure(err) {
+		r.mu.Lock()
+		r.downUntil = f.now().Add(f.downTime)
+		r.lastErr = err
+		r.mu.Unlock()
+		return true
+	}
+	r.mu.Lock()
+	r.downUntil = time.Time{}
+	r.mu.Unlock()
+	return false
+}
+
+func (f *FS) healthy(r *replica) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !f.now().Before(r.downUntil)
+}
+
+// candidates returns healthy replicas first, then the ones marked down as last resort
+func (f *FS) candidates() []*replica {
+	var up, down []*replica
+	for _, r := range f.replicas {
+		if f.healthy(r) {
+			up = append(up, r)
+		} else {
+			down = append(down, r)
+		}
+	}
+	return append(up, down...)
+}
+
+// first calls fn with replicas in order of preference until one does not fail
+func first[T any](f *FS, fn func(r *replica) (T, error)) (T, *replica, error) {
+	var (
+		res T
+		err error
+	)
+	for _, r := range f.candidates() {
+		res, err = fn(r)
+		if !f.result(r, err) {
+			return res, r, err
+		}
+	}
+	return res, nil, err
+}
+
+// write calls fn with the first healthy replica and replicates t to the others
+func (f *FS) write(ctx context.Context, fn func(fsys vfs.ContextFS) error, t task) error {
+	_, r, err := first(f, func(r *replica) (struct{}, error) {
+		return struct{}{}, fn(r.fs)
+	})
+	if err != nil {
+		return err
+	}
+	f.replicate(ctx, r, t)
+	return nil
+}
+
+// replicate applies t to all replicas but src. Writes to replicas marked down,
+// failing, or with queued writes to apply first, are queued.
+func (f *FS) replicate(ctx context.Context, src *replica, t task) {
+	t.Src = src.index
+	for _, r := range f.replicas {
+		if r == src {
+			continue
+		}
+		if f.async || !f.healthy(r) || f.queued(r) {
+			f.enqueue(r, t)
+			continue
+		}
+		err := f.apply(ctx, t, r.fs)
+		if f.result(r, err) {
+			f.enqueue(r, t)
+		} else if err != nil {
+			f.onError(r.index, t.Op, t.Name, err)
+		}
+	}
+}
+
+func (f *FS) queued(r *replica) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue) > 0
+}
+
+func (f *FS) enqueue(r *replica, t task) {
+	r.mu.Lock()
+	r.seq++
+	t.seq = r.seq
+	var err error
+	if r.journal != nil {
+		err = r.journal.append(record{Seq: t.seq, Task: &t})
+	}
+	r.queue = append(r.queue, t)
+	r.mu.Unlock()
+	if err != nil {
+		f.onError(r.index, OpJournal, t.Name, err)
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work applies queued writes of r in order, retrying on backend failures
+func (f *FS) work(r *replica) {
+	defer f.wg.Done()
+	for {
+		r.mu.Lock()
+		var t task
+		pending := len(r.queue) > 0
+		if pending {
+			t = r.queue[0]
+		}
+		r.mu.Unlock()
+		if !pending {
+			select {
+			case <-f.ctx.Done():
+				return
+			case <-r.wake:
+			}
+			continue
+		}
+		err := f.apply(f.ctx, t, r.fs)
+		if f.ctx.Err() != nil {
+			return
+		}
+		if f.result(r, err) {
+			select {
+			case <-f.ctx.Done():
+				return
+			case <-time.After(f.retryInterval):
+			}
+			continue
+		}
+		if err != nil {
+			f.onError(r.index, t.Op, t.Name, err)
+		}
+		r.mu.Lock()
+		r.queue = r.queue[1:]
+		if r.journal != nil {
+			if len(r.queue) == 0 {
+				err = r.journal.reset()
+			} else {
+				err = r.journal.append(record{Seq: t.seq})
+			}
+		}
+		r.mu.Unlock()
+		if r.journal != nil && err != nil {
+			f.onError(r.index, OpJournal, t.Name, err)
+		}
+	}
+}
+
+// copyFile copies name from src to dst, creating missing parents
+func copyFile(ctx context.Context, src, dst vfs.ContextFS, name string) error {
+	in, err := src.OpenContext(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return dst.MkdirAllContext(ctx, name, info.Mode().Perm())
+	}
+	if dir := path.Dir(name); dir != "/" && dir != "." {
+		if err := dst.MkdirAllContext(ctx, dir, 0777); err != nil {
+			return err
+		}
+	}
+	out, err := dst.OpenFileContext(ctx, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// best effort, for Repair to compare modification times
+	_ = dst.ChtimesContext(ctx, name, info.ModTime(), info.ModTime())
+	return nil
+}
+
+// ignore returns nil if err is one of targets
+func ignore(err error, targets ...error) error {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return nil
+		}
+	}
+	return err
+}
+
+func (f *FS) Name() string {
+	return "ReplicaFS"
+}
+
+func (f *FS) Create(name string) (vfs.File, error) {
+	return f.CreateContext(context.Background(), name)
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	return f.MkdirContext(context.Background(), name, perm)
+}
+
+func (f *FS) MkdirAll(path string, perm os.FileMode) error {
+	return f.MkdirAllContext(context.Background(), path, perm)
+}
+
+func (f *FS) Open(name string) (vfs.File, error) {
+	return f.OpenContext(context.Background(), name)
+}
+
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	return f.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (f *FS) Remove(name string) error {
+	return f.RemoveContext(context.Background(), name)
+}
+
+func (f *FS) RemoveAll(path string) error {
+	return f.RemoveAllContext(context.Background(), path)
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	return f.RenameContext(context.Background(), oldname, newname)
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	return f.StatContext(context.Background(), name)
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return f.ChmodContext(context.Background(), name, mode)
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	return f.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (f *FS) CreateContext(ctx context.Context, name string) (vfs.File, error) {
+	return f.OpenFileContext(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *FS) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.MkdirContext(ctx, name, perm)
+	}, task{Op: vfs.OpMkdir, Name: name, Perm: perm})
+}
+
+func (f *FS) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.MkdirAllContext(ctx, path, perm)
+	}, task{Op: vfs.OpMkdirAll, Name: path, Perm: perm})
+}
+
+func (f *FS) OpenContext(ctx context.Context, name string) (vfs.File, error) {
+	return f.OpenFileContext(ctx, name, os.O_RDONLY, 0)
+}
+
+func (f *FS) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (vfs.File, error) {
+	file, r, err := first(f, func(r *replica) (vfs.File, error) {
+		return r.fs.OpenFileContext(ctx, name, flag, perm)
+	})
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return file, err
+	}
+	// an opened file may be written without calling Write, e.g. by O_TRUNC
+	written := flag&(os.O_CREATE|os.O_TRUNC) != 0
+	return &writeFile{File: file, ctx: ctx, fs: f, src: r, name: name, written: written}, nil
+}
+
+func (f *FS) RemoveContext(ctx context.Context, name string) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.RemoveContext(ctx, name)
+	}, task{Op: vfs.OpRemove, Name: name})
+}
+
+func (f *FS) RemoveAllContext(ctx context.Context, path string) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.RemoveAllContext(ctx, path)
+	}, task{Op: vfs.OpRemoveAll, Name: path})
+}
+
+func (f *FS) RenameContext(ctx context.Context, oldname, newname string) error {
+	_, r, err := first(f, func(r *replica) (struct{}, error) {
+		return struct{}{}, r.fs.RenameContext(ctx, oldname, newname)
+	})
+	if err != nil {
+		return err
+	}
+	f.replicate(ctx, r, task{Op: vfs.OpRename, Name: oldname, NewName: newname})
+	return nil
+}
+
+func (f *FS) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	info, _, err := first(f, func(r *replica) (os.FileInfo, error) {
+		return r.fs.StatContext(ctx, name)
+	})
+	return info, err
+}
+
+func (f *FS) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.ChmodContext(ctx, name, mode)
+	}, task{Op: vfs.OpChmod, Name: name, Perm: mode})
+}
+
+func (f *FS) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.ChownContext(ctx, name, uid, gid)
+	}, task{Op: vfs.OpChown, Name: name, UID: uid, GID: gid})
+}
+
+func (f *FS) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	return f.write(ctx, func(fsys vfs.ContextFS) error {
+		return fsys.ChtimesContext(ctx, name, atime, mtime)
+	}, task{Op: vfs.OpChtimes, Name: name, Atime: atime, Mtime: mtime})
+}
+
+// linker calls fn with the first healthy replica implementing vfs.Linker.
+// Uploads to pre signed urls are not replicated until Repair.
+func (f *FS) linker(fn func(l vfs.Linker) (*vfs.Link, error)) (*vfs.Link, error) {
+	link, _, err := first(f, func(r *replica) (*vfs.Link, error) {
+		l, ok := r.inner.(vfs.Linker)
+		if !ok {
+			return nil, vfs.ErrNotSupported
+		}
+		return fn(l)
+	})
+	return link, err
+}
+
+func (f *FS) PreSignedURL(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	return f.linker(func(l vfs.Linker) (*vfs.Link, error) {
+		return l.PreSignedURL(ctx, name, args...)
+	})
+}
+
+func (f *FS) PublicUrl(ctx context.Context, name string) (*vfs.Link, error) {
+	return f.linker(func(l vfs.Linker) (*vfs.Link, error) {
+		return l.PublicUrl(ctx, name)
+	})
+}
+
+func (f *FS) InternalUrl(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	return f.linker(func(l vfs.Linker) (*vfs.Link, error) {
+		return l.InternalUrl(ctx, name, args...)
+	})
+}
+
+// writeFile replicates the file on Close if it was written
+type writeFile struct {
+	vfs.File
+	ctx     context.Context
+	fs      *FS
+	src     *replica
+	name    string
+	written bool
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	f.written = true
+	return f.File.Write(p)
+}
+
+func (f *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	f.written = true
+	return f.File.WriteAt(p, off)
+}
+
+func (f *writeFile) WriteString(s string) (int, error) {
+	f.written = true
+	return f.File.WriteString(s)
+}
+
+func (f *writeFile) Truncate(size int64) error {
+	f.written = true
+	return f.File.Truncate(size)
+}
+
+func (f *writeFile) Close() error {
+	if err := f.File.Close(); err != nil || !f.written {
+		return err
+	}
+	f.fs.replicate(f.ctx, f.src, task{Op: vfs.OpCreate, Name: f.name})
+	return nil
+}
@@ -0,0 +1,206 @@
+package replica
+
+import (
+	"context"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/faultfs"
+	"github.com/goxiaoy/vfs/vfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+		return New([]vfs.FS{afero.NewMemMapFs(), afero.NewMemMapFs()})
+	}, vfstest.Skip("Linker"))
+}
+
+func assertFile(t *testing.T, fsys vfs.FS, name, content string) {
+	t.Helper()
+	b, err := afero.ReadFile(fsys, name)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(b))
+}
+
+func TestSync(t *testing.T) {
+	a, b := afero.NewMemMapFs(), afero.NewMemMapFs()
+	f := New([]vfs.FS{a, b})
+	defer f.Close()
+
+	assert.NoError(t, f.MkdirAll("/dir", 0755))
+	assert.NoError(t, afero.WriteFile(f, "/dir/1.txt", []byte("1"), 0644))
+	assertFile(t, a, "/dir/1.txt", "1")
+	assertFile(t, b, "/dir/1.txt", "1")
+
+	assert.NoError(t, f.Rename("/dir/1.txt", "/dir/2.txt"))
+	assertFile(t, b, "/dir/2.txt", "1")
+	// diverged replicas are fixed by copying
+	assert.NoError(t, afero.WriteFile(f, "/dir/3.txt", []byte("3"), 0644))
+	assert.NoError(t, b.Remove("/dir/3.txt"))
+	assert.NoError(t, f.Rename("/dir/3.txt", "/dir/4.txt"))
+	assertFile(t, b, "/dir/4.txt", "3")
+
+	assert.NoError(t, f.RemoveAll("/dir"))
+	_, err := b.Stat("/dir")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestFailover(t *testing.T) {
+	primary := faultfs.New(afero.NewMemMapFs())
+	secondary := afero.NewMemMapFs()
+	var errs []error
+	f := New([]vfs.FS{primary, secondary}, WithRetryInterval(10*time.Millisecond),
+		WithErrorHandler(func(replica int, op, name string, err error) { errs = append(errs, err) }))
+	defer f.Close()
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("1"), 0644))
+
+	primary.Add(faultfs.Rule{Err: syscall.EIO})
+	assertFile(t, f, "/1.txt", "1")
+	status := f.Status()
+	assert.False(t, status[0].Healthy)
+	assert.ErrorIs(t, status[0].LastError, syscall.EIO)
+	assert.True(t, status[1].Healthy)
+
+	// errors caused by the request do not fail over
+	_, err := f.Stat("/2.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.True(t, f.Status()[1].Healthy)
+
+	// writes succeed in sync mode while a replica is down, which catches up once back
+	assert.NoError(t, afero.WriteFile(f, "/3.txt", []byte("3"), 0644))
+	assertFile(t, secondary, "/3.txt", "3")
+	assert.Equal(t, 1, f.Pending())
+	primary.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, f.Flush(ctx))
+	assertFile(t, primary, "/3.txt", "3")
+	assert.Empty(t, errs)
+}
+
+func TestAsync(t *testing.T) {
+	primary := afero.NewMemMapFs()
+	secondary := faultfs.New(afero.NewMemMapFs())
+	f := New([]vfs.FS{primary, secondary}, WithAsync(), WithRetryInterval(10*time.Millisecond), WithDownTime(time.Millisecond))
+	defer f.Close()
+
+	secondary.Add(faultfs.Rule{Err: syscall.EIO})
+	assert.NoError(t, f.Mkdir("/dir", 0755))
+	assert.NoError(t, afero.WriteFile(f, "/dir/1.txt", []byte("1"), 0644))
+	assert.NoError(t, f.Rename("/dir/1.txt", "/dir/2.txt"))
+	assertFile(t, f, "/dir/2.txt", "1")
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 3, f.Pending())
+
+	secondary.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, f.Flush(ctx))
+	assertFile(t, secondary, "/dir/2.txt", "1")
+	_, err := secondary.Stat("/dir/1.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestRepair(t *testing.T) {
+	a, b := afero.NewMemMapFs(), afero.NewMemMapFs()
+	f := New([]vfs.FS{a, b})
+	defer f.Close()
+	assert.NoError(t, afero.WriteFile(a, "/dir/sub/1.txt", []byte("1"), 0644))
+	assert.NoError(t, afero.WriteFile(a, "/2.txt", []byte("2"), 0644))
+	assert.NoError(t, afero.WriteFile(a, "/3.txt", []byte("3"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/2.txt", []byte("x"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/3.txt", []byte("3"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/extra.txt", []byte("extra"), 0644))
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"/2.txt", "/extra.txt"} {
+		assert.NoError(t, b.Chtimes(name, past, past))
+	}
+
+	ctx := context.Background()
+	actions, err := f.Repair(ctx, RepairOptions{DryRun: true, DeleteExtra: true})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []RepairAction{
+		{Replica: 1, Path: "/dir", Action: ActionMkdir},
+		{Replica: 1, Path: "/dir/sub", Action: ActionMkdir},
+		{Replica: 1, Path: "/dir/sub/1.txt", Action: ActionCopy},
+		{Replica: 1, Path: "/2.txt", Action: ActionCopy},
+		{Replica: 1, Path: "/extra.txt", Action: ActionRemove},
+	}, actions)
+
+	actions, err = f.Repair(ctx, RepairOptions{Checksum: true, DeleteExtra: true})
+	assert.NoError(t, err)
+	assert.Len(t, actions, 5)
+	assertFile(t, b, "/dir/sub/1.txt", "1")
+	assertFile(t, b, "/2.txt", "2")
+	_, err = b.Stat("/extra.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	actions, err = f.Repair(ctx, RepairOptions{Checksum: true, DeleteExtra: true})
+	assert.NoError(t, err)
+	assert.Empty(t, actions)
+
+	// without checksum, files of the same size modified in the source are copied
+	assert.NoError(t, afero.WriteFile(a, "/3.txt", []byte("4"), 0644))
+	assert.NoError(t, a.Chtimes("/3.txt", time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+	actions, err = f.Repair(ctx, RepairOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []RepairAction{{Replica: 1, Path: "/3.txt", Action: ActionCopy}}, actions)
+	assertFile(t, b, "/3.txt", "4")
+	actions, err = f.Repair(ctx, RepairOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
+func TestRepairNewer(t *testing.T) {
+	a, b := afero.NewMemMapFs(), afero.NewMemMapFs()
+	f := New([]vfs.FS{a, b})
+	defer f.Close()
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, afero.WriteFile(a, "/1.txt", []byte("stale"), 0644))
+	assert.NoError(t, a.Chtimes("/1.txt", past, past))
+	assert.NoError(t, afero.WriteFile(b, "/1.txt", []byte("new"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/dir/old.txt", []byte("old"), 0644))
+	assert.NoError(t, b.Chtimes("/dir/old.txt", past, past))
+	assert.NoError(t, b.Chtimes("/dir", past, past))
+	assert.NoError(t, afero.WriteFile(b, "/dir/new.txt", []byte("new"), 0644))
+
+	// newer files are neither overwritten, even with checksums, nor removed
+	actions, err := f.Repair(context.Background(), RepairOptions{Checksum: true, DeleteExtra: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []RepairAction{{Replica: 1, Path: "/dir/old.txt", Action: ActionRemove}}, actions)
+	assertFile(t, b, "/1.txt", "new")
+	assertFile(t, b, "/dir/new.txt", "new")
+}
+
+func TestJournal(t *testing.T) {
+	primary := afero.NewMemMapFs()
+	secondary := faultfs.New(afero.NewMemMapFs())
+	journal := afero.NewMemMapFs()
+	f := New([]vfs.FS{primary, secondary}, WithAsync(), WithJournal(journal, "/journal"), WithRetryInterval(time.Hour))
+
+	secondary.Add(faultfs.Rule{Err: syscall.EIO})
+	assert.NoError(t, f.Mkdir("/dir", 0755))
+	assert.NoError(t, afero.WriteFile(f, "/dir/1.txt", []byte("1"), 0644))
+	assert.NoError(t, f.Rename("/dir/1.txt", "/dir/2.txt"))
+	assert.Equal(t, 3, f.Pending())
+	// pending writes survive Close
+	assert.NoError(t, f.Close())
+
+	secondary.Reset()
+	f = New([]vfs.FS{primary, secondary}, WithAsync(), WithJournal(journal, "/journal"))
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, f.Flush(ctx))
+	assertFile(t, secondary, "/dir/2.txt", "1")
+	_, err := secondary.Stat("/dir/1.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	info, err := journal.Stat("/journal/1.jsonl")
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
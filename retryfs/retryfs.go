@@ -0,0 +1,436 @@
+// Package retryfs retries idempotent operations of flaky backends with exponential backoff
+package retryfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// Operations on opened files passed to Policy.OnRetry
+const (
+	OpRead    = "read"
+	OpReaddir = "readdir"
+)
+
+// Policy of retrying an operation
+type Policy struct {
+	// MaxAttempts including the first one
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt, multiplied by Multiplier for every further attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomly reduces delays by up to this fraction
+	Jitter float64
+	// Retryable classifies errors, IsRetryable if nil
+	Retryable func(err error) bool
+	// OnRetry is called before sleeping for the next attempt if not nil
+	OnRetry func(op string, attempt int, err error)
+}
+
+var DefaultPolicy = Policy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// IsRetryable reports whether err is likely transient: temporary network errors,
+// timeouts, connection resets, EIO and responses with status 429 or 5xx
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, e := range []error{fs.ErrNotExist, fs.ErrExist, fs.ErrPermission, fs.ErrInvalid, fs.ErrClosed, vfs.ErrNotSupported} {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EIO, syscall.EAGAIN, syscall.EBUSY, syscall.ETIMEDOUT, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE:
+			return true
+		}
+		return false
+	}
+	// e.g. awserr.RequestFailure
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) {
+		code := status.StatusCode()
+		return code == 429 || code >= 500
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// FS retries idempotent operations of the wrapped FS. Operations with side
+// effects depending on the number of calls, Rename, Create and opening files
+// for writing, and writes to files are never retried.
+type FS struct {
+	fs     vfs.ContextFS
+	inner  vfs.FS
+	policy Policy
+	mu     sync.Mutex
+	rand   *rand.Rand
+}
+
+var (
+	_ vfs.FS        = (*FS)(nil)
+	_ vfs.ContextFS = (*FS)(nil)
+	_ vfs.Linker    = (*FS)(nil)
+)
+
+// New wraps fsys, zero fields of policy are taken from DefaultPolicy
+func New(fsys vfs.FS, policy Policy) *FS {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultPolicy.MaxAttempts
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultPolicy.InitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultPolicy.MaxBackoff
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = DefaultPolicy.Multiplier
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsRetryable
+	}
+	return &FS{fs: vfs.AsContextFS(fsys), inner: fsys, policy: policy, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// backoff returns the delay after the failed attempt
+func (f *FS) backoff(attempt int) time.Duration {
+	d := float64(f.policy.InitialBackoff) * math.Pow(f.policy.Multiplier, float64(attempt-1))
+	if d > float64(f.policy.MaxBackoff) {
+		d = float64(f.policy.MaxBackoff)
+	}
+	if f.policy.Jitter > 0 {
+		f.mu.Lock()
+		d -= d * f.policy.Jitter * f.rand.Float64()
+		f.mu.Unlock()
+	}
+	return time.Duration(d)
+}
+
+// do calls fn until it succeeds, fails with a non retryable error, attempts
+// are exhausted or the next attempt would exceed the deadline of ctx
+func do[T any](ctx context.Context, f *FS, op string, fn func(attempt int) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		res, err := fn(attempt)
+		if err == nil || attempt >= f.policy.MaxAttempts || !f.policy.Retryable(err) {
+			return res, err
+		}
+		d := f.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return res, err
+		}
+		if f.policy.OnRetry != nil {
+			f.policy.OnRetry(op, attempt, err)
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res, err
+		case <-t.C:
+		}
+	}
+}
+
+func (f *FS) retry(ctx context.Context, op string, fn func(attempt int) error) error {
+	_, err := do(ctx, f, op, func(attempt int) (struct{}, error) {
+		return struct{}{}, fn(attempt)
+	})
+	return err
+}
+
+func (f *FS) Name() string {
+	return "RetryFS"
+}
+
+func (f *FS) Create(name string) (vfs.File, error) {
+	return f.CreateContext(context.Background(), name)
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	return f.MkdirContext(context.Background(), name, perm)
+}
+
+func (f *FS) MkdirAll(path string, perm os.FileMode) error {
+	return f.MkdirAllContext(context.Background(), path, perm)
+}
+
+func (f *FS) Open(name string) (vfs.File, error) {
+	return f.OpenContext(context.Background(), name)
+}
+
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	return f.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (f *FS) Remove(name string) error {
+	return f.RemoveContext(context.Background(), name)
+}
+
+func (f *FS) RemoveAll(path string) error {
+	return f.RemoveAllContext(context.Background(), path)
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	return f.RenameContext(context.Background(), oldname, newname)
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	return f.StatContext(context.Background(), name)
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return f.ChmodContext(context.Background(), name, mode)
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	return f.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (f *FS) CreateContext(ctx context.Context, name string) (vfs.File, error) {
+	file, err := f.fs.CreateContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(ctx, file), nil
+}
+
+func (f *FS) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	return f.retry(ctx, vfs.OpMkdir, func(attempt int) error {
+		err := f.fs.MkdirContext(ctx, name, perm)
+		// a previous attempt may have succeeded without response
+		if attempt > 1 && errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (f *FS) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	return f.retry(ctx, vfs.OpMkdirAll, func(int) error {
+		return f.fs.MkdirAllContext(ctx, path, perm)
+	})
+}
+
+func (f *FS) OpenContext(ctx context.Context, name string) (vfs.File, error) {
+	return f.OpenFileContext(ctx, name, os.O_RDONLY, 0)
+}
+
+func (f *FS) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		file, err := f.fs.OpenFileContext(ctx, name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return f.wrap(ctx, file), nil
+	}
+	file, err := do(ctx, f, vfs.OpOpenFile, func(int) (vfs.File, error) {
+		return f.fs.OpenFileContext(ctx, name, flag, perm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(ctx, file), nil
+}
+
+func (f *FS) RemoveContext(ctx context.Context, name string) error {
+	return f.retry(ctx, vfs.OpRemove, func(attempt int) error {
+		err := f.fs.RemoveContext(ctx, name)
+		// a previous attempt may have succeeded without response
+		if attempt > 1 && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (f *FS) RemoveAllContext(ctx context.Context, path string) error {
+	return f.retry(ctx, vfs.OpRemoveAll, func(int) error {
+		return f.fs.RemoveAllContext(ctx, path)
+	})
+}
+
+func (f *FS) RenameContext(ctx context.Context, oldname, newname string) error {
+	return f.fs.RenameContext(ctx, oldname, newname)
+}
+
+func (f *FS) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	return do(ctx, f, vfs.OpStat, func(int) (os.FileInfo, error) {
+		return f.fs.StatContext(ctx, name)
+	})
+}
+
+func (f *FS) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	return f.retry(ctx, vfs.OpChmod, func(int) error {
+		return f.fs.ChmodContext(ctx, name, mode)
+	})
+}
+
+func (f *FS) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	return f.retry(ctx, vfs.OpChown, func(int) error {
+		return f.fs.ChownContext(ctx, name, uid, gid)
+	})
+}
+
+func (f *FS) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	return f.retry(ctx, vfs.OpChtimes, func(int) error {
+		return f.fs.ChtimesContext(ctx, name, atime, mtime)
+	})
+}
+
+func (f *FS) linker() (vfs.Linker, error) {
+	l, ok := f.inner.(vfs.Linker)
+	if !ok {
+		return nil, vfs.ErrNotSupported
+	}
+	return l, nil
+}
+
+func (f *FS) PreSignedURL(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	l, err := f.linker()
+	if err != nil {
+		return nil, err
+	}
+	return do(ctx, f, vfs.OpPreSignedURL, func(int) (*vfs.Link, error) {
+		return l.PreSignedURL(ctx, name, args...)
+	})
+}
+
+func (f *FS) PublicUrl(ctx context.Context, name string) (*vfs.Link, error) {
+	l, err := f.linker()
+	if err != nil {
+		return nil, err
+	}
+	return do(ctx, f, vfs.OpPublicUrl, func(int) (*vfs.Link, error) {
+		return l.PublicUrl(ctx, name)
+	})
+}
+
+func (f *FS) InternalUrl(ctx context.Context, name string, args ...vfs.LinkOptions) (*vfs.Link, error) {
+	l, err := f.linker()
+	if err != nil {
+		return nil, err
+	}
+	return do(ctx, f, vfs.OpInternalUrl, func(int) (*vfs.Link, error) {
+		return l.InternalUrl(ctx, name, args...)
+	})
+}
+
+func (f *FS) wrap(ctx context.Context, inner vfs.File) vfs.File {
+	return &file{File: inner, ctx: ctx, fs: f}
+}
+
+// file retries reads and directory listings with the context it was opened with
+type file struct {
+	vfs.File
+	ctx    context.Context
+	fs     *FS
+	mu     sync.Mutex
+	offset int64 // of sequential reads
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.File.Read(p)
+	if err == nil || err == io.EOF || n > 0 || !f.fs.policy.Retryable(err) {
+		f.offset += int64(n)
+		return n, err
+	}
+	// retry at the offset, the stream of the file may be broken
+	n, err = do(f.ctx, f.fs, OpRead, func(attempt int) (int, error) {
+		if attempt == 1 {
+			return 0, err
+		}
+		return f.File.ReadAt(p, f.offset)
+	})
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	f.offset += int64(n)
+	if _, serr := f.File.Seek(f.offset, io.SeekStart); serr != nil {
+		return n, serr
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	return do(f.ctx, f.fs, OpRead, func(int) (int, error) {
+		return f.File.ReadAt(p, off)
+	})
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.offset = pos
+	}
+	return pos, err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.File.Write(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Readdir is retried only when reading all entries, a failed page may have
+// consumed entries which a retry would skip
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if count > 0 {
+		return f.File.Readdir(count)
+	}
+	return do(f.ctx, f.fs, OpReaddir, func(int) ([]os.FileInfo, error) {
+		return f.File.Readdir(count)
+	})
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	if n > 0 {
+		return f.File.Readdirnames(n)
+	}
+	return do(f.ctx, f.fs, OpReaddir, func(int) ([]string, error) {
+		return f.File.Readdirnames(n)
+	})
+}
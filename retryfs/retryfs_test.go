@@ -0,0 +1,132 @@
+package retryfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/faultfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFS(retries *int) (*FS, *faultfs.FS) {
+	faults := faultfs.New(afero.NewMemMapFs())
+	return New(faults, Policy{
+		InitialBackoff: time.Millisecond,
+		OnRetry: func(op string, attempt int, err error) {
+			*retries++
+		},
+	}), faults
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(&fs.PathError{Op: "stat", Path: "/a", Err: syscall.ECONNRESET}))
+	assert.True(t, IsRetryable(io.ErrUnexpectedEOF))
+	assert.False(t, IsRetryable(&fs.PathError{Op: "stat", Path: "/a", Err: syscall.ENOENT}))
+	assert.False(t, IsRetryable(context.DeadlineExceeded))
+	assert.False(t, IsRetryable(vfs.ErrNotSupported))
+}
+
+func TestRetry(t *testing.T) {
+	retries := 0
+	f, faults := newFS(&retries)
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("0123456789"), 0644))
+
+	faults.Add(faultfs.Rule{Ops: []string{vfs.OpStat}, Err: syscall.EIO, Times: 2})
+	_, err := f.Stat("/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, retries)
+
+	// attempts exhausted
+	retries = 0
+	faults.Reset()
+	faults.Add(faultfs.Rule{Ops: []string{vfs.OpStat}, Err: syscall.EIO})
+	_, err = f.Stat("/1.txt")
+	assert.ErrorIs(t, err, syscall.EIO)
+	assert.Equal(t, 2, retries)
+
+	// not retryable
+	retries = 0
+	faults.Reset()
+	_, err = f.Stat("/none.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, 0, retries)
+
+	faults.Add(faultfs.Rule{Ops: []string{vfs.OpRemove}, Err: syscall.ECONNRESET, Nth: 1})
+	assert.NoError(t, f.Remove("/1.txt"))
+	assert.Equal(t, 1, retries)
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("0123456789"), 0644))
+
+	// never retried
+	retries = 0
+	faults.Reset()
+	faults.Add(faultfs.Rule{Ops: []string{vfs.OpRename, faultfs.OpWrite}, Err: syscall.EIO})
+	assert.ErrorIs(t, f.Rename("/1.txt", "/2.txt"), syscall.EIO)
+	assert.ErrorIs(t, afero.WriteFile(f, "/2.txt", []byte("2"), 0644), syscall.EIO)
+	assert.Equal(t, 0, retries)
+}
+
+func TestRead(t *testing.T) {
+	retries := 0
+	f, faults := newFS(&retries)
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("0123456789"), 0644))
+	faults.Add(faultfs.Rule{Ops: []string{faultfs.OpRead}, Err: syscall.ECONNRESET, Nth: 2})
+
+	file, err := f.Open("/1.txt")
+	assert.NoError(t, err)
+	defer file.Close()
+	buf := make([]byte, 4)
+	n, err := file.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(buf[:n]))
+	n, err = file.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "4567", string(buf[:n]))
+	rest, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(rest))
+	assert.Equal(t, 1, retries)
+}
+
+func TestReaddir(t *testing.T) {
+	retries := 0
+	f, faults := newFS(&retries)
+	assert.NoError(t, afero.WriteFile(f, "/dir/1.txt", []byte("1"), 0644))
+	assert.NoError(t, afero.WriteFile(f, "/dir/2.txt", []byte("2"), 0644))
+	faults.Add(faultfs.Rule{Ops: []string{faultfs.OpReaddir}, Err: syscall.ECONNRESET, Nth: 1})
+
+	file, err := f.Open("/dir")
+	assert.NoError(t, err)
+	names, err := file.Readdirnames(-1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.txt", "2.txt"}, names)
+	assert.NoError(t, file.Close())
+	assert.Equal(t, 1, retries)
+
+	// pages are not retried
+	faults.Add(faultfs.Rule{Ops: []string{faultfs.OpReaddir}, Err: syscall.ECONNRESET, Nth: 1})
+	file, err = f.Open("/dir")
+	assert.NoError(t, err)
+	defer file.Close()
+	_, err = file.Readdir(1)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, 1, retries)
+}
+
+func TestDeadline(t *testing.T) {
+	faults := faultfs.New(afero.NewMemMapFs())
+	faults.Add(faultfs.Rule{Ops: []string{vfs.OpStat}, Err: syscall.EIO})
+	f := New(faults, Policy{MaxAttempts: 10, InitialBackoff: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := f.StatContext(ctx, "/1.txt")
+	assert.ErrorIs(t, err, syscall.EIO)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,297 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	as3 "github.com/fclairamb/afero-s3"
+	"github.com/goxiaoy/vfs"
+)
+
+var _ vfs.ContextFS = (*Blob)(nil)
+
+func (b *Blob) Create(name string) (vfs.File, error) {
+	return b.CreateContext(context.Background(), name)
+}
+
+func (b *Blob) Mkdir(name string, perm os.FileMode) error {
+	return b.MkdirContext(context.Background(), name, perm)
+}
+
+func (b *Blob) MkdirAll(path string, perm os.FileMode) error {
+	return b.MkdirAllContext(context.Background(), path, perm)
+}
+
+func (b *Blob) Open(name string) (vfs.File, error) {
+	return b.OpenContext(context.Background(), name)
+}
+
+func (b *Blob) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	return b.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (b *Blob) Remove(name string) error {
+	return b.RemoveContext(context.Background(), name)
+}
+
+func (b *Blob) RemoveAll(path string) error {
+	return b.RemoveAllContext(context.Background(), path)
+}
+
+func (b *Blob) Rename(oldname, newname string) error {
+	return b.RenameContext(context.Background(), oldname, newname)
+}
+
+func (b *Blob) Stat(name string) (os.FileInfo, error) {
+	return b.StatContext(context.Background(), name)
+}
+
+func (b *Blob) Chmod(name string, mode os.FileMode) error {
+	return b.ChmodContext(context.Background(), name, mode)
+}
+
+func (b *Blob) Chown(name string, uid, gid int) error {
+	return b.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (b *Blob) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return b.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (b *Blob) CreateContext(ctx context.Context, name string) (vfs.File, error) {
+	// It's faster to trigger an explicit empty put object than opening a file for write
+	_, err := b.s3Api.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(name),
+		Body:        bytes.NewReader([]byte{}),
+		ContentType: aws.String(mime.TypeByExtension(filepath.Ext(name))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.OpenFileContext(ctx, name, os.O_WRONLY, 0750)
+}
+
+func (b *Blob) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	if _, err := b.StatContext(ctx, name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return b.mkdir(ctx, name)
+}
+
+func (b *Blob) mkdir(ctx context.Context, name string) error {
+	_, err := b.s3Api.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(path.Clean(name) + "/"),
+		Body:   bytes.NewReader([]byte{}),
+	})
+	return err
+}
+
+func (b *Blob) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	if info, err := b.StatContext(ctx, path); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	return b.mkdir(ctx, path)
+}
+
+func (b *Blob) OpenContext(ctx context.Context, name string) (vfs.File, error) {
+	return b.OpenFileContext(ctx, name, os.O_RDONLY, 0777)
+}
+
+func (b *Blob) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (vfs.File, error) {
+	info, err := b.StatContext(ctx, name)
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, err
+	}
+	if err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	// Reading and writing is technically supported but can't lead to anything that makes sense,
+	// appending is not supported by S3
+	if flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: vfs.ErrNotSupported}
+	}
+	// Creating is basically a write
+	if flag&(os.O_CREATE|os.O_WRONLY) != 0 {
+		return newWriteFile(ctx, b, name), nil
+	}
+	if info.IsDir() {
+		// listing is served by the underlying afero fs
+		return &dirFile{File: as3.NewFile(b.FS.(*as3.Fs), name), info: info}, nil
+	}
+	return newReadFile(ctx, b, name, info), nil
+}
+
+func (b *Blob) RemoveContext(ctx context.Context, name string) error {
+	if _, err := b.StatContext(ctx, name); err != nil {
+		return err
+	}
+	_, err := b.s3Api.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	return err
+}
+
+func (b *Blob) RemoveAllContext(ctx context.Context, name string) error {
+	prefix := strings.TrimPrefix(path.Clean(name), "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	var keys []*s3.ObjectIdentifier
+	dir := prefix
+	if prefix != "" {
+		// like os.RemoveAll, name itself goes too, be it a file or a directory marker
+		dir += "/"
+		keys = append(keys, &s3.ObjectIdentifier{Key: aws.String(prefix)}, &s3.ObjectIdentifier{Key: aws.String(dir)})
+	}
+	err := b.s3Api.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(dir),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range out.Contents {
+			if aws.StringValue(o.Key) != dir {
+				keys = append(keys, &s3.ObjectIdentifier{Key: o.Key})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	// DeleteObjects accepts at most 1000 keys per request
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 1000 {
+			n = 1000
+		}
+		_, err = b.s3Api.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(b.bucket),
+			Delete: &s3.Delete{Objects: keys[:n], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (b *Blob) RenameContext(ctx context.Context, oldname, newname string) error {
+	if _, err := b.StatContext(ctx, oldname); err != nil {
+		return err
+	}
+	if oldname == newname {
+		return nil
+	}
+	_, err := b.s3Api.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		CopySource: aws.String(copySource(b.bucket, oldname)),
+		Key:        aws.String(newname),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.s3Api.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(oldname),
+	})
+	return err
+}
+
+func (b *Blob) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	if path.Clean("/"+name) == "/" {
+		// the bucket itself, e.g. the root of a mount point
+		return vfs.NewFileInfo("/", true, 0, time.Unix(0, 0)), nil
+	}
+	out, err := b.s3Api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		var errRequestFailure awserr.RequestFailure
+		if errors.As(err, &errRequestFailure) && errRequestFailure.StatusCode() == 404 {
+			return b.statDirectory(ctx, name)
+		}
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if strings.HasSuffix(name, "/") {
+		// user asked for a directory, but this is a file
+		return vfs.NewFileInfo(name, true, 0, time.Unix(0, 0)), nil
+	}
+	return vfs.NewFileInfo(name, false, aws.Int64Value(out.ContentLength), aws.TimeValue(out.LastModified)), nil
+}
+
+func (b *Blob) statDirectory(ctx context.Context, name string) (os.FileInfo, error) {
+	prefix := strings.TrimPrefix(path.Clean(name), "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix != "" {
+		// a sibling such as name+"-suffix" does not make name a directory
+		prefix += "/"
+	}
+	out, err := b.s3Api.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(b.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if aws.Int64Value(out.KeyCount) == 0 && name != "" {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return vfs.NewFileInfo(name, true, 0, time.Unix(0, 0)), nil
+}
+
+func (b *Blob) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	acl := "private"
+	otherRead := mode&(1<<2) != 0
+	otherWrite := mode&(1<<1) != 0
+	switch {
+	case otherRead && otherWrite:
+		acl = "public-read-write"
+	case otherRead:
+		acl = "public-read"
+	}
+	_, err := b.s3Api.PutObjectAclWithContext(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+		ACL:    aws.String(acl),
+	})
+	return err
+}
+
+func (b *Blob) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: vfs.ErrNotSupported}
+}
+
+func (b *Blob) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: vfs.ErrNotSupported}
+}
+
+// copySource returns the url encoded source of CopyObject
+func copySource(bucket, key string) string {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return bucket + "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,270 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	as3 "github.com/fclairamb/afero-s3"
+	"github.com/goxiaoy/vfs"
+)
+
+// readFile streams an object with the context it was opened with
+type readFile struct {
+	ctx    context.Context
+	b      *Blob
+	name   string
+	info   os.FileInfo
+	body   io.ReadCloser
+	offset int64
+	// version of the object to read, the latest if empty
+	version string
+}
+
+func newReadFile(ctx context.Context, b *Blob, name string, info os.FileInfo) *readFile {
+	return &readFile{ctx: ctx, b: b, name: name, info: info}
+}
+
+var _ vfs.File = (*readFile)(nil)
+
+func (f *readFile) get(ctx context.Context, rng string) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(f.b.bucket),
+		Key:    aws.String(f.name),
+		Range:  aws.String(rng),
+	}
+	if f.version != "" {
+		in.VersionId = aws.String(f.version)
+	}
+	out, err := f.b.s3Api.GetObjectWithContext(ctx, in)
+	if err != nil {
+		var errRequestFailure awserr.RequestFailure
+		if errors.As(err, &errRequestFailure) && errRequestFailure.StatusCode() == 416 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.body == nil {
+		if f.offset >= f.info.Size() {
+			return 0, io.EOF
+		}
+		body, err := f.get(f.ctx, fmt.Sprintf("bytes=%d-", f.offset))
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err != nil && err != io.EOF {
+		// reopen the stream at the offset on the next read
+		f.body.Close()
+		f.body = nil
+	}
+	return n, err
+}
+
+func (f *readFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	body, err := f.get(f.ctx, fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, as3.ErrInvalidSeek
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	defer func() {
+		f.body = nil
+	}()
+	return f.body.Close()
+}
+
+func (f *readFile) Name() string {
+	return f.name
+}
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (f *readFile) Readdirnames(n int) ([]string, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *readFile) Sync() error {
+	return nil
+}
+
+func (f *readFile) Truncate(size int64) error {
+	return syscall.EBADF
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, syscall.EBADF
+}
+
+func (f *readFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, syscall.EBADF
+}
+
+func (f *readFile) WriteString(s string) (int, error) {
+	return 0, syscall.EBADF
+}
+
+// writeFile uploads everything written to it with the context it was opened with
+type writeFile struct {
+	name    string
+	pw      *io.PipeWriter
+	done    chan error
+	written int64
+	closed  bool
+}
+
+func newWriteFile(ctx context.Context, b *Blob, name string) *writeFile {
+	pr, pw := io.Pipe()
+	f := &writeFile{name: name, pw: pw, done: make(chan error, 1)}
+	uploader := s3manager.NewUploaderWithClient(b.s3Api, func(u *s3manager.Uploader) {
+		u.Concurrency = 1
+	})
+	go func() {
+		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(b.bucket),
+			Key:         aws.String(name),
+			Body:        pr,
+			ContentType: aws.String(mime.TypeByExtension(filepath.Ext(name))),
+		})
+		// unblock pending writes if the upload failed
+		pr.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+var _ vfs.File = (*writeFile)(nil)
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.pw.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	if off != f.written {
+		return 0, as3.ErrNotSupported
+	}
+	return f.Write(p)
+}
+
+func (f *writeFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence != io.SeekStart || offset == f.written && whence == io.SeekStart {
+		return f.written, nil
+	}
+	return 0, as3.ErrNotSupported
+}
+
+func (f *writeFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if err := f.pw.Close(); err != nil {
+		return err
+	}
+	return <-f.done
+}
+
+func (f *writeFile) Name() string {
+	return f.name
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	return vfs.NewFileInfo(f.name, false, f.written, time.Now()), nil
+}
+
+func (f *writeFile) Sync() error {
+	return nil
+}
+
+func (f *writeFile) Truncate(size int64) error {
+	return as3.ErrNotImplemented
+}
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	return 0, syscall.EBADF
+}
+
+func (f *writeFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, syscall.EBADF
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (f *writeFile) Readdirnames(n int) ([]string, error) {
+	return nil, syscall.ENOTDIR
+}
+
+// dirFile lists a directory, the bucket root included, with the underlying afero fs
+type dirFile struct {
+	*as3.File
+	info os.FileInfo
+}
+
+func (f *dirFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
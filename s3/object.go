@@ -0,0 +1,146 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/goxiaoy/vfs"
+)
+
+var (
+	_ vfs.Copier    = (*Blob)(nil)
+	_ vfs.Lister    = (*Blob)(nil)
+	_ vfs.Metadater = (*Blob)(nil)
+)
+
+// pathError wraps err of the object name, missing objects are reported as os.ErrNotExist
+func pathError(op, name string, err error) error {
+	var errRequestFailure awserr.RequestFailure
+	if errors.As(err, &errRequestFailure) && errRequestFailure.StatusCode() == 404 {
+		err = os.ErrNotExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Copy copies the object src to dest on the server side, keeping its metadata
+func (b *Blob) Copy(ctx context.Context, src, dest string) error {
+	_, err := b.s3Api.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		CopySource: aws.String(copySource(b.bucket, src)),
+		Key:        aws.String(dest),
+	})
+	if err != nil {
+		return pathError("copy", src, err)
+	}
+	return nil
+}
+
+// objectInfo carries the metadata of a listed object in Sys
+type objectInfo struct {
+	*vfs.FileInfo
+	md *vfs.Metadata
+}
+
+func (i objectInfo) Sys() any {
+	return i.md
+}
+
+// ListPage lists a page of ListObjectsV2, the page token is the continuation token.
+// Common prefixes are listed as directories.
+func (b *Blob) ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *vfs.ListOptions) ([]*fs.FileInfo, []byte, error) {
+	if opts == nil {
+		opts = &vfs.ListOptions{}
+	}
+	prefix := strings.TrimPrefix(opts.Prefix, "/")
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if opts.Delimiter != "" {
+		in.Delimiter = aws.String(opts.Delimiter)
+	}
+	if pageSize > 0 {
+		in.MaxKeys = aws.Int64(int64(pageSize))
+	}
+	if len(pageToken) > 0 {
+		in.ContinuationToken = aws.String(string(pageToken))
+	}
+	out, err := b.s3Api.ListObjectsV2WithContext(ctx, in)
+	if err != nil {
+		return nil, nil, pathError("list", opts.Prefix, err)
+	}
+	var infos []*fs.FileInfo
+	for _, p := range out.CommonPrefixes {
+		var info fs.FileInfo = vfs.NewFileInfo(strings.TrimSuffix(aws.StringValue(p.Prefix), opts.Delimiter), true, 0, time.Unix(0, 0))
+		infos = append(infos, &info)
+	}
+	for _, o := range out.Contents {
+		key := aws.StringValue(o.Key)
+		if key == prefix {
+			// directory marker of the listed prefix
+			continue
+		}
+		var info fs.FileInfo
+		if strings.HasSuffix(key, "/") {
+			info = vfs.NewFileInfo(key, true, 0, time.Unix(0, 0))
+		} else {
+			info = objectInfo{
+				FileInfo: vfs.NewFileInfo(key, false, aws.Int64Value(o.Size), aws.TimeValue(o.LastModified)),
+				md:       &vfs.Metadata{ETag: aws.StringValue(o.ETag)},
+			}
+		}
+		infos = append(infos, &info)
+	}
+	var next []byte
+	if aws.BoolValue(out.IsTruncated) {
+		next = []byte(aws.StringValue(out.NextContinuationToken))
+	}
+	return infos, next, nil
+}
+
+func (b *Blob) GetMetadata(ctx context.Context, name string) (*vfs.Metadata, error) {
+	out, err := b.s3Api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, pathError("getMetadata", name, err)
+	}
+	md := &vfs.Metadata{
+		ContentType: aws.StringValue(out.ContentType),
+		ETag:        aws.StringValue(out.ETag),
+		User:        map[string]string{},
+	}
+	for k, v := range out.Metadata {
+		md.User[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return md, nil
+}
+
+// SetMetadata replaces the metadata by copying the object onto itself
+func (b *Blob) SetMetadata(ctx context.Context, name string, md *vfs.Metadata) error {
+	in := &s3.CopyObjectInput{
+		Bucket:            aws.String(b.bucket),
+		CopySource:        aws.String(copySource(b.bucket, name)),
+		Key:               aws.String(name),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		Metadata:          map[string]*string{},
+	}
+	if md.ContentType != "" {
+		in.ContentType = aws.String(md.ContentType)
+	}
+	for k, v := range md.User {
+		in.Metadata[k] = aws.String(v)
+	}
+	if _, err := b.s3Api.CopyObjectWithContext(ctx, in); err != nil {
+		return pathError("setMetadata", name, err)
+	}
+	return nil
+}
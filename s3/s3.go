@@ -21,11 +21,15 @@ type Blob struct {
 	publicAccessUrl   url.URL
 	internalAccessUrl url.URL
 	defaultExpire     time.Duration
+	pollInterval      time.Duration
 
 	s3Api *s3.S3
 }
 
-var _ vfs.Blob = (*Blob)(nil)
+var (
+	_ vfs.Blob     = (*Blob)(nil)
+	_ vfs.Notifier = (*Blob)(nil)
+)
 
 func NewBlob(session *session.Session, bucket string, publicAccessUrl url.URL, internalAccessUrl url.URL, defaultExpire time.Duration) *Blob {
 	// Initialize the file system
@@ -38,6 +42,7 @@ func NewBlob(session *session.Session, bucket string, publicAccessUrl url.URL, i
 		publicAccessUrl:   publicAccessUrl,
 		internalAccessUrl: internalAccessUrl,
 		defaultExpire:     defaultExpire,
+		pollInterval:      vfs.DefaultPollInterval,
 		s3Api:             s3Api,
 	}
 }
@@ -47,11 +52,12 @@ func (b *Blob) PreSignedURL(ctx context.Context, name string, args ...vfs.LinkOp
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(strings.TrimPrefix(name, "/")),
 	})
+	r.SetContext(ctx)
 	t := b.defaultExpire
 	if len(args) > 0 && args[0].Expire != nil {
 		t = *args[0].Expire
 	}
-	res = &vfs.Link{}
+	res = &vfs.Link{Expiration: &t}
 	res.URL, err = r.Presign(t)
 	return
 }
@@ -71,3 +77,13 @@ func (b *Blob) InternalUrl(ctx context.Context, name string, args ...vfs.LinkOpt
 	res.URL = url.String()
 	return
 }
+
+// SetPollInterval sets the interval of listing the bucket to detect changes
+func (b *Blob) SetPollInterval(d time.Duration) {
+	b.pollInterval = d
+}
+
+// Notify polls the bucket since S3 does not push notifications to clients
+func (b *Blob) Notify(ctx context.Context, fn func(vfs.Event)) error {
+	return vfs.Poll(ctx, b, b.pollInterval, fn)
+}
@@ -0,0 +1,193 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/s3/s3test"
+	"github.com/goxiaoy/vfs/versioning"
+	"github.com/goxiaoy/vfs/vfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBlob(t *testing.T) (*Blob, *s3test.Server) {
+	srv := s3test.NewServer(afero.NewMemMapFs(), "bucket")
+	t.Cleanup(srv.Close)
+	public, _ := url.Parse(srv.URL + "/bucket")
+	return NewBlob(srv.Session(), "bucket", *public, *public, time.Hour), srv
+}
+
+func TestConformance(t *testing.T) {
+	vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+		b, _ := newBlob(t)
+		return b
+	}, vfstest.Skip("Append", "Truncate", "Chmod", "Chtimes"))
+}
+
+func TestMultipart(t *testing.T) {
+	b, _ := newBlob(t)
+	data := bytes.Repeat([]byte("0123456789"), 600*1024)
+	assert.NoError(t, afero.WriteFile(b, "/large.bin", data, 0644))
+	info, err := b.Stat("/large.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+	got, err := afero.ReadFile(b, "/large.bin")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+}
+
+func TestPreSignedURL(t *testing.T) {
+	b, srv := newBlob(t)
+	expire := time.Minute
+	link, err := b.PreSignedURL(context.Background(), "/1.txt", vfs.LinkOptions{Expire: &expire})
+	assert.NoError(t, err)
+	assert.Equal(t, expire, *link.Expiration)
+
+	req, _ := http.NewRequest(http.MethodPut, link.URL, strings.NewReader("hello"))
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	got, err := afero.ReadFile(b, "/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	// tampered
+	req, _ = http.NewRequest(http.MethodPut, strings.Replace(link.URL, "1.txt", "2.txt", 1), strings.NewReader("hello"))
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// unsigned
+	res, err = http.Get(srv.URL + "/bucket/1.txt")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Contains(t, string(body), "AccessDenied")
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	ctx := context.Background()
+	srv := s3test.NewServer(versioning.New(afero.NewMemMapFs(), afero.NewMemMapFs()), "bucket")
+	t.Cleanup(srv.Close)
+	public, _ := url.Parse(srv.URL + "/bucket")
+	b := NewBlob(srv.Session(), "bucket", *public, *public, time.Hour)
+
+	assert.NoError(t, afero.WriteFile(b, "/doc.txt", []byte("v1"), 0644))
+	// versions written after the snapshot started, while listing, are not captured
+	var once sync.Once
+	b.s3Api.Handlers.Send.PushFront(func(r *request.Request) {
+		if r.Operation.Name != "ListObjectVersions" {
+			return
+		}
+		once.Do(func() {
+			// the server dates the snapshot and versions to the second
+			time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+			assert.NoError(t, afero.WriteFile(b, "/doc.txt", []byte("v2"), 0644))
+			assert.NoError(t, afero.WriteFile(b, "/new.txt", []byte("new"), 0644))
+		})
+	})
+	s, err := b.Snapshot(ctx, "/")
+	assert.NoError(t, err)
+	got, err := afero.ReadFile(s, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(got))
+	_, err = s.Stat("/new.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestPrefixes(t *testing.T) {
+	b, _ := newBlob(t)
+	assert.NoError(t, afero.WriteFile(b, "/dir-1/1.txt", []byte("1"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/dir/2.txt", []byte("2"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/3.txt", []byte("3"), 0644))
+
+	// a sibling sharing the name as prefix is not the directory
+	_, err := b.Stat("/dir-")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	info, err := b.Stat("/dir")
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	assert.NoError(t, b.RemoveAll("/3.txt"))
+	_, err = b.Stat("/3.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, b.RemoveAll("/dir"))
+	_, err = b.Stat("/dir")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = b.Stat("/dir-1/1.txt")
+	assert.NoError(t, err)
+}
+
+func TestSnapshotUnversioned(t *testing.T) {
+	b, _ := newBlob(t)
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", b))
+	_, err := v.Snapshot("/")
+	assert.ErrorIs(t, err, vfs.ErrNotSupported)
+}
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	srv := s3test.NewServer(versioning.New(afero.NewMemMapFs(), afero.NewMemMapFs()), "bucket")
+	t.Cleanup(srv.Close)
+	public, _ := url.Parse(srv.URL + "/bucket")
+	b := NewBlob(srv.Session(), "bucket", *public, *public, time.Hour)
+
+	assert.NoError(t, afero.WriteFile(b, "/doc.txt", []byte("v1"), 0644))
+	s, err := b.Snapshot(ctx, "/")
+	assert.NoError(t, err)
+	assert.NoError(t, afero.WriteFile(b, "/doc.txt", []byte("v2"), 0644))
+	assert.NoError(t, afero.WriteFile(b, "/doc.txt.bak", []byte("bak"), 0644))
+
+	versions, err := b.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	if !assert.Len(t, versions, 2) {
+		t.FailNow()
+	}
+	assert.True(t, versions[0].IsLatest)
+	f, err := b.OpenVersion(ctx, "/doc.txt", versions[1].ID)
+	assert.NoError(t, err)
+	got, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.Equal(t, "v1", string(got))
+
+	// the snapshot keeps reading the version it captured
+	got, err = afero.ReadFile(s, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(got))
+
+	assert.NoError(t, b.RestoreVersion(ctx, "/doc.txt", versions[1].ID))
+	got, err = afero.ReadFile(b, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(got))
+	versions, err = b.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 3)
+	// restoring the latest version changes nothing
+	assert.NoError(t, b.RestoreVersion(ctx, "/doc.txt", versions[0].ID))
+	got, err = afero.ReadFile(b, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(got))
+
+	assert.NoError(t, b.Remove("/doc.txt"))
+	versions, err = b.ListVersions(ctx, "/doc.txt.bak")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	_, err = b.ListVersions(ctx, "/missing.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,56 @@
+// Package s3test runs an in-process S3 compatible server for tests. Buckets
+// are top level directories of a FS and objects are files below them.
+//
+//	srv := s3test.NewServer(afero.NewMemMapFs(), "bucket")
+//	defer srv.Close()
+//	blob := s3.NewBlob(srv.Session(), "bucket", url.URL{}, url.URL{}, time.Hour)
+package s3test
+
+import (
+	"net/http/httptest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/s3gateway"
+	"github.com/spf13/afero"
+)
+
+// Server serves a s3gateway.Gateway accepting the credentials of the server
+type Server struct {
+	*httptest.Server
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+// NewServer starts a server storing buckets in fsys, creating the buckets if they do not exist
+func NewServer(fsys vfs.FS, buckets ...string) *Server {
+	s := &Server{
+		AccessKey: "access",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+	}
+	for _, b := range buckets {
+		if err := fsys.MkdirAll("/"+b, 0755); err != nil {
+			panic(err)
+		}
+	}
+	s.Server = httptest.NewServer(s3gateway.New(fsys,
+		s3gateway.WithCredentials(s3gateway.Credential{AccessKey: s.AccessKey, SecretKey: s.SecretKey}),
+		s3gateway.WithRegion(s.Region),
+		s3gateway.WithStagingFS(afero.NewMemMapFs())))
+	return s
+}
+
+// Session returns a session using the server as endpoint
+func (s *Server) Session() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(s.URL),
+		Region:           aws.String(s.Region),
+		Credentials:      credentials.NewStaticCredentials(s.AccessKey, s.SecretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+}
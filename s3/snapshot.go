@@ -0,0 +1,325 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/goxiaoy/vfs"
+)
+
+var _ vfs.Snapshotter = (*Blob)(nil)
+
+// Snapshot captures the objects below name as of the time it is called, by
+// the newest version of each key modified until then. The time is the Date
+// of the server, which dates versions too, so that the clock of the client
+// does not matter. Both have a resolution of a second: versions written in
+// the second the snapshot is taken may be captured. The bucket must have
+// versioning enabled, so that the versions are kept when objects are
+// overwritten or deleted, vfs.ErrNotSupported is returned otherwise.
+func (b *Blob) Snapshot(ctx context.Context, name string) (vfs.FS, error) {
+	req, out := b.s3Api.GetBucketVersioningRequest(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(b.bucket),
+	})
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		return nil, pathError("snapshot", name, err)
+	}
+	if aws.StringValue(out.Status) != s3.BucketVersioningStatusEnabled {
+		return nil, pathError("snapshot", name, vfs.ErrNotSupported)
+	}
+	prefix := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	// versions written while listing are newer than t, IsLatest would pick them up
+	t, err := http.ParseTime(req.HTTPResponse.Header.Get("Date"))
+	if err != nil {
+		// servers without clock do not send Date
+		t = time.Now()
+	}
+	selected := map[string]*selectedVersion{}
+	pick := func(key string, v *selectedVersion) {
+		if v.modTime.Truncate(time.Second).After(t) {
+			return
+		}
+		if old, ok := selected[key]; ok && (old.modTime.After(v.modTime) || old.modTime.Equal(v.modTime) && !v.latest) {
+			return
+		}
+		selected[key] = v
+	}
+	err = b.s3Api.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, o := range page.Versions {
+			pick(aws.StringValue(o.Key), &selectedVersion{
+				id:      aws.StringValue(o.VersionId),
+				size:    aws.Int64Value(o.Size),
+				modTime: aws.TimeValue(o.LastModified),
+				latest:  aws.BoolValue(o.IsLatest),
+			})
+		}
+		for _, o := range page.DeleteMarkers {
+			pick(aws.StringValue(o.Key), &selectedVersion{
+				modTime:      aws.TimeValue(o.LastModified),
+				latest:       aws.BoolValue(o.IsLatest),
+				deleteMarker: true,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, pathError("snapshot", name, err)
+	}
+	s := &snapshot{b: b, entries: map[string]*snapshotEntry{}}
+	s.add("/", &snapshotEntry{info: vfs.NewFileInfo(name, true, 0, time.Unix(0, 0))})
+	keys := make([]string, 0, len(selected))
+	for key := range selected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := selected[key]
+		// objects deleted at t are not listed
+		if v.deleteMarker {
+			continue
+		}
+		p := path.Clean("/" + strings.TrimPrefix(key, prefix))
+		if strings.HasSuffix(key, "/") {
+			s.add(p, &snapshotEntry{info: vfs.NewFileInfo(p, true, 0, time.Unix(0, 0))})
+			continue
+		}
+		s.add(p, &snapshotEntry{
+			info:    vfs.NewFileInfo(p, false, v.size, v.modTime),
+			key:     key,
+			version: v.id,
+		})
+	}
+	for _, e := range s.entries {
+		sort.Strings(e.children)
+	}
+	return s, nil
+}
+
+// selectedVersion is the version of a key captured by a snapshot
+type selectedVersion struct {
+	id           string
+	size         int64
+	modTime      time.Time
+	latest       bool
+	deleteMarker bool
+}
+
+type snapshotEntry struct {
+	info     os.FileInfo
+	key      string
+	version  string
+	children []string // names of directory entries
+}
+
+// snapshot is a read-only FS of object versions
+type snapshot struct {
+	b       *Blob
+	entries map[string]*snapshotEntry
+}
+
+// add adds e at p and the directories leading to it
+func (s *snapshot) add(p string, e *snapshotEntry) {
+	if old, ok := s.entries[p]; ok {
+		if old.info.IsDir() && !e.info.IsDir() {
+			// a file and a directory of the same name, keep the directory
+			return
+		}
+		e.children = old.children
+		s.entries[p] = e
+		return
+	}
+	s.entries[p] = e
+	if p == "/" {
+		return
+	}
+	dir := path.Dir(p)
+	if _, ok := s.entries[dir]; !ok {
+		s.add(dir, &snapshotEntry{info: vfs.NewFileInfo(dir, true, 0, time.Unix(0, 0))})
+	}
+	s.entries[dir].children = append(s.entries[dir].children, path.Base(p))
+}
+
+func (s *snapshot) lookup(op, name string) (*snapshotEntry, error) {
+	e, ok := s.entries[path.Clean("/"+filepath.ToSlash(name))]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (s *snapshot) Open(name string) (vfs.File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s *snapshot) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	e, err := s.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.info.IsDir() {
+		infos := make([]os.FileInfo, len(e.children))
+		dir := path.Clean("/" + filepath.ToSlash(name))
+		for i, child := range e.children {
+			infos[i] = s.entries[path.Join(dir, child)].info
+		}
+		return &snapshotDir{name: name, info: e.info, infos: infos}, nil
+	}
+	f := newReadFile(context.Background(), s.b, e.key, e.info)
+	f.version = e.version
+	return &snapshotFile{readFile: f, name: name}, nil
+}
+
+func (s *snapshot) Stat(name string) (os.FileInfo, error) {
+	e, err := s.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+func (s *snapshot) Name() string {
+	return "s3snapshot"
+}
+
+func (s *snapshot) Create(name string) (vfs.File, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) Mkdir(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) MkdirAll(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) RemoveAll(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EROFS}
+}
+
+func (s *snapshot) Chmod(name string, mode os.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) Chown(name string, uid, gid int) error {
+	return &fs.PathError{Op: "chown", Path: name, Err: syscall.EROFS}
+}
+
+func (s *snapshot) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: syscall.EROFS}
+}
+
+// snapshotFile reads an object version
+type snapshotFile struct {
+	*readFile
+	name string
+}
+
+func (f *snapshotFile) Name() string {
+	return f.name
+}
+
+// snapshotDir lists a directory of a snapshot
+type snapshotDir struct {
+	name   string
+	info   os.FileInfo
+	infos  []os.FileInfo
+	offset int
+}
+
+var _ vfs.File = (*snapshotDir)(nil)
+
+func (d *snapshotDir) Close() error {
+	return nil
+}
+
+func (d *snapshotDir) Read(p []byte) (int, error) {
+	return 0, syscall.EISDIR
+}
+
+func (d *snapshotDir) ReadAt(p []byte, off int64) (int, error) {
+	return 0, syscall.EISDIR
+}
+
+func (d *snapshotDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, syscall.EISDIR
+}
+
+func (d *snapshotDir) Name() string {
+	return d.name
+}
+
+func (d *snapshotDir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.infos[d.offset:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(rest) {
+			rest = rest[:count]
+		}
+	}
+	d.offset += len(rest)
+	return rest, nil
+}
+
+func (d *snapshotDir) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (d *snapshotDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *snapshotDir) Sync() error {
+	return nil
+}
+
+func (d *snapshotDir) Truncate(size int64) error {
+	return syscall.EROFS
+}
+
+func (d *snapshotDir) Write(p []byte) (int, error) {
+	return 0, syscall.EROFS
+}
+
+func (d *snapshotDir) WriteAt(p []byte, off int64) (int, error) {
+	return 0, syscall.EROFS
+}
+
+func (d *snapshotDir) WriteString(s string) (int, error) {
+	return 0, syscall.EROFS
+}
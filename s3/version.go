@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/goxiaoy/vfs"
+)
+
+var _ vfs.Versioner = (*Blob)(nil)
+
+// ListVersions lists the versions and delete markers of the object name, newest first
+func (b *Blob) ListVersions(ctx context.Context, name string) ([]*vfs.Version, error) {
+	key := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	var res []*vfs.Version
+	err := b.s3Api.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, o := range page.Versions {
+			if aws.StringValue(o.Key) == key {
+				res = append(res, &vfs.Version{
+					ID:       aws.StringValue(o.VersionId),
+					Size:     aws.Int64Value(o.Size),
+					ModTime:  aws.TimeValue(o.LastModified),
+					IsLatest: aws.BoolValue(o.IsLatest),
+				})
+			}
+		}
+		for _, o := range page.DeleteMarkers {
+			if aws.StringValue(o.Key) == key {
+				res = append(res, &vfs.Version{
+					ID:           aws.StringValue(o.VersionId),
+					ModTime:      aws.TimeValue(o.LastModified),
+					IsLatest:     aws.BoolValue(o.IsLatest),
+					DeleteMarker: true,
+				})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, pathError("listVersions", name, err)
+	}
+	if len(res) == 0 {
+		return nil, &os.PathError{Op: "listVersions", Path: name, Err: os.ErrNotExist}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].IsLatest != res[j].IsLatest {
+			return res[i].IsLatest
+		}
+		return res[i].ModTime.After(res[j].ModTime)
+	})
+	return res, nil
+}
+
+// OpenVersion opens the version id of the object name for reading
+func (b *Blob) OpenVersion(ctx context.Context, name, id string) (vfs.File, error) {
+	out, err := b.s3Api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(b.bucket),
+		Key:       aws.String(name),
+		VersionId: aws.String(id),
+	})
+	if err != nil {
+		return nil, pathError("openVersion", name, err)
+	}
+	info := vfs.NewFileInfo(name, false, aws.Int64Value(out.ContentLength), aws.TimeValue(out.LastModified))
+	f := newReadFile(ctx, b, name, info)
+	f.version = id
+	return f, nil
+}
+
+// RestoreVersion copies the version id of the object name over its latest version
+func (b *Blob) RestoreVersion(ctx context.Context, name, id string) error {
+	_, err := b.s3Api.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		CopySource: aws.String(copySource(b.bucket, name) + "?versionId=" + url.QueryEscape(id)),
+		Key:        aws.String(name),
+	})
+	if err != nil {
+		return pathError("restoreVersion", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+// Package s3gateway serves a vfs.FS, usually a Vfs, over a subset of the S3 REST
+// API so that S3 tools can access any mount point. Buckets are the top level
+// directories, i.e. the top level mount points of a Vfs, and objects are files
+// below them.
+//
+//	gw := s3gateway.New(v, s3gateway.WithCredentials(s3gateway.Credential{
+//		AccessKey: "access",
+//		SecretKey: "secret",
+//		Identity:  &vfs.Identity{ID: "backup"},
+//	}))
+//	http.ListenAndServe(":9000", gw)
+package s3gateway
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+const (
+	xmlns      = "http://s3.amazonaws.com/doc/2006-03-01/"
+	timeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// Credential signs requests with AWS signature version 4
+type Credential struct {
+	AccessKey string
+	SecretKey string
+	// Identity of the signed requests, passed to the FS with vfs.WithIdentity
+	Identity *vfs.Identity
+}
+
+// Gateway is a http.Handler supporting bucket create/head/list/delete, object
+// put/get/head/delete/copy, ListObjectsV2, DeleteObjects and multipart uploads.
+// Requests must be signed by one of its credentials, by header or presigned query.
+//
+// Listings, copies and metadata are delegated to the FS when it implements
+// vfs.Lister, vfs.Copier and vfs.Metadater, and done by walking, streaming
+// and keeping metadata in memory otherwise. Buckets are versioned when the FS
+// implements vfs.Versioner.
+type Gateway struct {
+	fs          vfs.ContextFS
+	versions    vfs.Versioner // nil if the FS does not keep versions
+	region      string
+	credentials map[string]Credential
+	meta        metaStore
+	staging     vfs.ContextFS
+	stageBodies bool // signed request bodies are staged in staging
+	mu          sync.Mutex
+	uploads     map[string]*upload // by upload id
+	now         func() time.Time
+}
+
+type Option func(g *Gateway)
+
+// WithCredentials adds credentials accepted by the gateway
+func WithCredentials(creds ...Credential) Option {
+	return func(g *Gateway) {
+		for _, c := range creds {
+			g.credentials[c.AccessKey] = c
+		}
+	}
+}
+
+// WithRegion sets the region of the signatures, us-east-1 by default
+func WithRegion(region string) Option {
+	return func(g *Gateway) {
+		g.region = region
+	}
+}
+
+// WithStagingFS stores the parts of multipart uploads in fsys instead of
+// memory, and the bodies of requests signing their payload until the hash is
+// verified. Without it, requests with a signed non-empty body are rejected and
+// clients must send UNSIGNED-PAYLOAD, e.g. over https.
+func WithStagingFS(fsys vfs.FS) Option {
+	return func(g *Gateway) {
+		g.staging = vfs.AsContextFS(fsys)
+		g.stageBodies = true
+	}
+}
+
+// New serves fsys, all requests are denied without WithCredentials
+func New(fsys vfs.FS, opts ...Option) *Gateway {
+	g := &Gateway{
+		fs:          vfs.AsContextFS(fsys),
+		region:      "us-east-1",
+		credentials: map[string]Credential{},
+		meta:        metaStore{entries: map[string]*metaEntry{}},
+		staging:     vfs.AsContextFS(afero.NewMemMapFs()),
+		uploads:     map[string]*upload{},
+		now:         time.Now,
+	}
+	if v, ok := fsys.(vfs.Versioner); ok {
+		g.versions = v
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// s3Error is an error response
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+	status   int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func errNoSuchKey(key string) *s3Error {
+	return &s3Error{Code: "NoSuchKey", Message: "The specified key does not exist.", Resource: key, status: http.StatusNotFound}
+}
+
+func errNoSuchBucket(bucket string) *s3Error {
+	return &s3Error{Code: "NoSuchBucket", Message: "The specified bucket does not exist.", Resource: bucket, status: http.StatusNotFound}
+}
+
+func errInvalidRequest(msg string) *s3Error {
+	return &s3Error{Code: "InvalidRequest", Message: msg, status: http.StatusBadRequest}
+}
+
+func errNotImplemented() *s3Error {
+	return &s3Error{Code: "NotImplemented", Message: "not implemented", status: http.StatusNotImplemented}
+}
+
+// toS3Error maps errors of the FS to S3 errors
+func toS3Error(r *http.Request, err error) *s3Error {
+	var e *s3Error
+	var ae *authError
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.As(err, &ae):
+		return &s3Error{Code: ae.code, Message: ae.message, status: http.StatusForbidden}
+	case errors.Is(err, fs.ErrNotExist):
+		return errNoSuchKey(r.URL.Path)
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return &s3Error{Code: "AccessDenied", Message: "Access Denied", Resource: r.URL.Path, status: http.StatusForbidden}
+	case errors.Is(err, vfs.ErrNotSupported), errors.Is(err, syscall.ENOTSUP):
+		return errNotImplemented()
+	case errors.Is(err, vfs.ErrUnhealthy):
+		return &s3Error{Code: "ServiceUnavailable", Message: err.Error(), status: http.StatusServiceUnavailable}
+	}
+	return &s3Error{Code: "InternalError", Message: err.Error(), status: http.StatusInternalServerError}
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e := toS3Error(r, err)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	g.writeXML(w, e.status, e)
+}
+
+func (g *Gateway) writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cred, err := g.verify(r, g.now())
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+	// the server closes the body it read, not the staged one
+	defer r.Body.Close()
+	if cred.Identity != nil {
+		r = r.WithContext(vfs.WithIdentity(r.Context(), cred.Identity))
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		err = g.listBuckets(w, r)
+	} else if key == "" {
+		err = g.serveBucket(w, r, bucket)
+	} else {
+		err = g.serveObject(w, r, bucket, key)
+	}
+	if err != nil {
+		g.writeError(w, r, err)
+	}
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx, q := r.Context(), r.URL.Query()
+	if r.Method == http.MethodPut && q.Has("versioning") {
+		return errNotImplemented()
+	}
+	if r.Method == http.MethodPut {
+		return g.fs.MkdirContext(ctx, "/"+bucket, 0755)
+	}
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	switch {
+	case r.Method == http.MethodHead:
+		return nil
+	case r.Method == http.MethodDelete:
+		if err := g.fs.RemoveContext(ctx, "/"+bucket); err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+			return &s3Error{Code: "BucketNotEmpty", Message: err.Error(), status: http.StatusConflict}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case r.Method == http.MethodGet && q.Has("versioning"):
+		return g.getBucketVersioning(w)
+	case r.Method == http.MethodGet && q.Has("versions"):
+		return g.listObjectVersions(w, r, bucket)
+	case r.Method == http.MethodGet:
+		return g.listObjects(w, r, bucket)
+	case r.Method == http.MethodPost && q.Has("delete"):
+		return g.deleteObjects(w, r, bucket)
+	}
+	return errNotImplemented()
+}
+
+func (g *Gateway) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		return g.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		return g.uploadPart(w, r, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		return g.completeMultipartUpload(w, r, q.Get("uploadId"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		if err := g.abortMultipartUpload(ctx, q.Get("uploadId")); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case r.Method == http.MethodPut && q.Has("acl"):
+		_, err := g.statObject(ctx, bucket, key)
+		return err
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		return g.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		return g.putObject(w, r, bucket, key)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && q.Get("versionId") != "":
+		return g.getObjectVersion(w, r, bucket, key, q.Get("versionId"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return g.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		if err := g.deleteObject(ctx, bucket, key); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errNotImplemented()
+}
+
+func (g *Gateway) checkBucket(ctx context.Context, bucket string) error {
+	info, err := g.fs.StatContext(ctx, "/"+bucket)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err != nil || !info.IsDir() {
+		return errNoSuchBucket(bucket)
+	}
+	return nil
+}
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return &s3Error{Code: "MethodNotAllowed", Message: "method not allowed", status: http.StatusMethodNotAllowed}
+	}
+	infos, err := g.readDir(r.Context(), "/")
+	if err != nil {
+		return err
+	}
+	res := &listAllMyBucketsResult{Xmlns: xmlns}
+	for _, info := range infos {
+		if info.IsDir() {
+			res.Buckets = append(res.Buckets, bucketEntry{info.Name(), info.ModTime().UTC().Format(timeFormat)})
+		}
+	}
+	g.writeXML(w, http.StatusOK, res)
+	return nil
+}
+
+// readDir returns the entries of dir sorted by name
+func (g *Gateway) readDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	f, err := g.fs.OpenContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
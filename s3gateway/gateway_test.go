@@ -0,0 +1,273 @@
+package s3gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	as3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/s3"
+	"github.com/goxiaoy/vfs/s3/s3test"
+	"github.com/goxiaoy/vfs/s3gateway"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// newGateway serves a Vfs mounting memory at /mem, a s3.Blob at /blob and a read-only FS at /x/ro
+func newGateway(t *testing.T, opts ...s3gateway.Option) (*vfs.Vfs, *as3.S3) {
+	backend := s3test.NewServer(afero.NewMemMapFs(), "bucket")
+	t.Cleanup(backend.Close)
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/mem", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/blob", s3.NewBlob(backend.Session(), "bucket", url.URL{}, url.URL{}, time.Hour)))
+	ro := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(ro, "1.txt", []byte("1"), 0644))
+	assert.NoError(t, v.Mount("/x/ro", afero.NewReadOnlyFs(ro)))
+
+	opts = append([]s3gateway.Option{
+		s3gateway.WithCredentials(s3gateway.Credential{AccessKey: "access", SecretKey: "secret"}),
+		s3gateway.WithStagingFS(afero.NewMemMapFs()),
+	}, opts...)
+	srv := httptest.NewServer(s3gateway.New(v, opts...))
+	t.Cleanup(srv.Close)
+	return v, client(srv.URL, "access", "secret")
+}
+
+func client(endpoint, accessKey, secretKey string) *as3.S3 {
+	return as3.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})))
+}
+
+func put(t *testing.T, c *as3.S3, bucket, key, body string) {
+	t.Helper()
+	_, err := c.PutObject(&as3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: strings.NewReader(body)})
+	assert.NoError(t, err)
+}
+
+func get(t *testing.T, c *as3.S3, bucket, key string) string {
+	t.Helper()
+	out, err := c.GetObject(&as3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer out.Body.Close()
+	b, _ := io.ReadAll(out.Body)
+	return string(b)
+}
+
+func TestListBuckets(t *testing.T) {
+	_, c := newGateway(t)
+	out, err := c.ListBuckets(&as3.ListBucketsInput{})
+	assert.NoError(t, err)
+	var names []string
+	for _, b := range out.Buckets {
+		names = append(names, aws.StringValue(b.Name))
+	}
+	assert.Equal(t, []string{"blob", "mem", "x"}, names)
+}
+
+func TestObjects(t *testing.T) {
+	_, c := newGateway(t)
+	for _, bucket := range []string{"mem", "blob"} {
+		t.Run(bucket, func(t *testing.T) {
+			_, err := c.PutObject(&as3.PutObjectInput{
+				Bucket:      aws.String(bucket),
+				Key:         aws.String("dir/1.txt"),
+				Body:        strings.NewReader("hello"),
+				ContentType: aws.String("text/x-hello"),
+				Metadata:    map[string]*string{"Color": aws.String("blue")},
+			})
+			assert.NoError(t, err)
+			head, err := c.HeadObject(&as3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("dir/1.txt")})
+			assert.NoError(t, err)
+			assert.Equal(t, "text/x-hello", aws.StringValue(head.ContentType))
+			assert.Equal(t, "blue", aws.StringValue(head.Metadata["Color"]))
+			assert.Equal(t, `"5d41402abc4b2a76b9719d911017c592"`, aws.StringValue(head.ETag))
+			assert.Equal(t, "hello", get(t, c, bucket, "dir/1.txt"))
+
+			// within the bucket and to the other one
+			for _, dst := range []string{bucket, "mem", "blob"} {
+				_, err = c.CopyObject(&as3.CopyObjectInput{Bucket: aws.String(dst), Key: aws.String("copy/" + bucket + ".txt"), CopySource: aws.String(bucket + "/dir/1.txt")})
+				assert.NoError(t, err)
+				assert.Equal(t, "hello", get(t, c, dst, "copy/"+bucket+".txt"))
+				head, err := c.HeadObject(&as3.HeadObjectInput{Bucket: aws.String(dst), Key: aws.String("copy/" + bucket + ".txt")})
+				assert.NoError(t, err)
+				assert.Equal(t, "blue", aws.StringValue(head.Metadata["Color"]))
+			}
+
+			_, err = c.DeleteObject(&as3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String("dir/1.txt")})
+			assert.NoError(t, err)
+			_, err = c.HeadObject(&as3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("dir/1.txt")})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	_, c := newGateway(t)
+	for _, tc := range []struct {
+		bucket string
+		lister bool
+	}{{"mem", false}, {"blob", true}} {
+		t.Run(tc.bucket, func(t *testing.T) {
+			for _, key := range []string{"dir/1.txt", "dir/2.txt", "dir/3.txt", "dir/sub/4.txt", "other.txt"} {
+				put(t, c, tc.bucket, key, key)
+			}
+			var keys []string
+			in := &as3.ListObjectsV2Input{Bucket: aws.String(tc.bucket), Prefix: aws.String("dir/"), Delimiter: aws.String("/"), MaxKeys: aws.Int64(2)}
+			for i := 0; i < 5; i++ {
+				out, err := c.ListObjectsV2(in)
+				if !assert.NoError(t, err) {
+					return
+				}
+				for _, o := range out.Contents {
+					keys = append(keys, aws.StringValue(o.Key))
+					assert.NotEmpty(t, aws.StringValue(o.ETag))
+				}
+				for _, p := range out.CommonPrefixes {
+					keys = append(keys, aws.StringValue(p.Prefix))
+				}
+				if !aws.BoolValue(out.IsTruncated) {
+					break
+				}
+				token, _ := base64.StdEncoding.DecodeString(aws.StringValue(out.NextContinuationToken))
+				// pages of listers are continued by them
+				assert.Equal(t, tc.lister, token[0] == 'l')
+				in.ContinuationToken = out.NextContinuationToken
+			}
+			assert.ElementsMatch(t, []string{"dir/1.txt", "dir/2.txt", "dir/3.txt", "dir/sub/"}, keys)
+
+			out, err := c.ListObjectsV2(&as3.ListObjectsV2Input{Bucket: aws.String(tc.bucket)})
+			assert.NoError(t, err)
+			assert.Len(t, out.Contents, 5)
+		})
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	_, c := newGateway(t, s3gateway.WithStagingFS(afero.NewMemMapFs()))
+	data := bytes.Repeat([]byte("0123456789"), 600*1024)
+	uploader := s3manager.NewUploaderWithClient(c)
+	_, err := uploader.Upload(&s3manager.UploadInput{Bucket: aws.String("mem"), Key: aws.String("large.bin"), Body: bytes.NewReader(data)})
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, []byte(get(t, c, "mem", "large.bin"))))
+}
+
+func TestAuth(t *testing.T) {
+	v, _ := newGateway(t)
+	var identities []string
+	v.Use(func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		if id, ok := vfs.IdentityFromContext(ctx); ok {
+			identities = append(identities, id.ID)
+		}
+		return next(ctx, op)
+	})
+	srv := httptest.NewServer(s3gateway.New(v, s3gateway.WithStagingFS(afero.NewMemMapFs()), s3gateway.WithCredentials(s3gateway.Credential{
+		AccessKey: "bob", SecretKey: "bob-secret", Identity: &vfs.Identity{ID: "bob"},
+	})))
+	defer srv.Close()
+
+	put(t, client(srv.URL, "bob", "bob-secret"), "mem", "1.txt", "1")
+	assert.Contains(t, identities, "bob")
+
+	_, err := client(srv.URL, "bob", "wrong").ListBuckets(&as3.ListBucketsInput{})
+	assert.ErrorContains(t, err, "SignatureDoesNotMatch")
+	_, err = client(srv.URL, "alice", "bob-secret").ListBuckets(&as3.ListBucketsInput{})
+	assert.ErrorContains(t, err, "InvalidAccessKeyId")
+
+	// denied by the FS
+	c := client(srv.URL, "bob", "bob-secret")
+	assert.Equal(t, "1", get(t, c, "x", "ro/1.txt"))
+	_, err = c.PutObject(&as3.PutObjectInput{Bucket: aws.String("x"), Key: aws.String("ro/2.txt"), Body: strings.NewReader("2")})
+	assert.ErrorContains(t, err, "AccessDenied")
+}
+
+func TestReplay(t *testing.T) {
+	v, c := newGateway(t)
+	endpoint := c.Endpoint
+	signer := v4.NewSigner(credentials.NewStaticCredentials("access", "secret", ""))
+	send := func(body string, signed time.Time, sign func(r *http.Request) error) int {
+		req, _ := http.NewRequest(http.MethodPut, endpoint+"/mem/1.txt", strings.NewReader(body))
+		if sign != nil {
+			assert.NoError(t, sign(req))
+		} else {
+			_, err := signer.Sign(req, strings.NewReader(body), "s3", "us-east-1", signed)
+			assert.NoError(t, err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, send("1", time.Now(), nil))
+
+	// a captured request with another body
+	status := send("2", time.Now(), func(r *http.Request) error {
+		_, err := signer.Sign(r, strings.NewReader("1"), "s3", "us-east-1", time.Now())
+		r.Body = io.NopCloser(strings.NewReader("2"))
+		return err
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	b, err := afero.ReadFile(v, "/mem/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(b))
+
+	// replayed later
+	assert.Equal(t, http.StatusForbidden, send("3", time.Now().Add(-time.Hour), nil))
+
+	// presigned for too long
+	status = send("4", time.Now(), func(r *http.Request) error {
+		_, err := signer.Presign(r, nil, "s3", "us-east-1", 8*24*time.Hour, time.Now())
+		return err
+	})
+	assert.Equal(t, http.StatusForbidden, status)
+}
+
+func TestPayload(t *testing.T) {
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/mem", afero.NewMemMapFs()))
+	srv := httptest.NewServer(s3gateway.New(v, s3gateway.WithCredentials(s3gateway.Credential{AccessKey: "access", SecretKey: "secret"})))
+	defer srv.Close()
+	creds := credentials.NewStaticCredentials("access", "secret", "")
+	send := func(signer *v4.Signer, service, payload string) int {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/mem/1.txt", strings.NewReader("1"))
+		if payload != "" {
+			req.Header.Set("X-Amz-Content-Sha256", payload)
+		}
+		_, err := signer.Sign(req, strings.NewReader("1"), service, "us-east-1", time.Now())
+		assert.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	unsigned := v4.NewSigner(creds, func(s *v4.Signer) { s.UnsignedPayload = true })
+
+	// signed bodies are not buffered in memory without staging FS
+	assert.Equal(t, http.StatusNotImplemented, send(v4.NewSigner(creds), "s3", ""))
+	assert.Equal(t, http.StatusOK, send(unsigned, "s3", ""))
+	assert.Equal(t, http.StatusNotImplemented, send(v4.NewSigner(creds), "s3", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"))
+	assert.Equal(t, http.StatusForbidden, send(unsigned, "sqs", ""))
+}
@@ -0,0 +1,270 @@
+package s3gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goxiaoy/vfs"
+)
+
+type listObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []listObject   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// Continuation tokens are either the last key of a walk or the page token of a vfs.Lister
+const (
+	tokenKey    = 'k'
+	tokenLister = 'l'
+)
+
+func encodeToken(kind byte, value []byte) string {
+	return base64.StdEncoding.EncodeToString(append([]byte{kind}, value...))
+}
+
+func decodeToken(token string) (byte, []byte, error) {
+	b, err := base64.StdEncoding.DecodeString(token)
+	if err != nil || len(b) == 0 || b[0] != tokenKey && b[0] != tokenLister {
+		return 0, nil, errInvalidRequest("invalid continuation token")
+	}
+	return b[0], b[1:], nil
+}
+
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	q := r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errInvalidRequest("invalid max-keys")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	res := &listBucketResult{
+		Xmlns:             xmlns,
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+		StartAfter:        q.Get("start-after"),
+	}
+	start := res.StartAfter
+	var kind byte = tokenKey
+	var token []byte
+	if res.ContinuationToken != "" {
+		var err error
+		if kind, token, err = decodeToken(res.ContinuationToken); err != nil {
+			return err
+		}
+		if kind == tokenKey {
+			start = string(token)
+		}
+	}
+
+	// listers page hierarchies from the start only
+	l, ok := g.fs.(vfs.Lister)
+	if ok && delimiter == "/" && maxKeys > 0 && start == "" && isClean(prefix) {
+		err := g.listPage(r.Context(), res, l, bucket, token)
+		if err == nil {
+			g.writeXML(w, http.StatusOK, res)
+			return nil
+		}
+		if !errors.Is(err, vfs.ErrNotSupported) {
+			return err
+		}
+	}
+	if kind == tokenLister {
+		return errInvalidRequest("invalid continuation token")
+	}
+	if err := g.walkPage(r.Context(), res, bucket, start); err != nil {
+		return err
+	}
+	g.writeXML(w, http.StatusOK, res)
+	return nil
+}
+
+// isClean reports whether the path of prefix is not changed by cleaning it
+func isClean(prefix string) bool {
+	p := "/" + prefix
+	return !strings.Contains(p, "//") && !strings.Contains(p+"/", "/./") && !strings.Contains(p+"/", "/../")
+}
+
+// listPage fills res with a page of l
+func (g *Gateway) listPage(ctx context.Context, res *listBucketResult, l vfs.Lister, bucket string, token []byte) error {
+	infos, next, err := l.ListPage(ctx, token, res.MaxKeys, &vfs.ListOptions{Prefix: "/" + bucket + "/" + res.Prefix, Delimiter: "/"})
+	if err != nil {
+		return err
+	}
+	dir := res.Prefix[:strings.LastIndex(res.Prefix, "/")+1]
+	sort.Slice(infos, func(i, j int) bool {
+		return (*infos[i]).Name() < (*infos[j]).Name()
+	})
+	for _, info := range infos {
+		info := *info
+		key := dir + info.Name()
+		res.KeyCount++
+		if info.IsDir() {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: key + "/"})
+			continue
+		}
+		res.Contents = append(res.Contents, g.listObject(ctx, key, "/"+bucket+"/"+key, info))
+	}
+	if len(next) > 0 {
+		res.IsTruncated = true
+		res.NextContinuationToken = encodeToken(tokenLister, next)
+	}
+	return nil
+}
+
+func (g *Gateway) listObject(ctx context.Context, key, p string, info os.FileInfo) listObject {
+	size := info.Size()
+	if info.IsDir() {
+		size = 0
+	}
+	return listObject{
+		Key:          key,
+		LastModified: info.ModTime().UTC().Format(timeFormat),
+		ETag:         g.etag(ctx, p, info),
+		Size:         size,
+		StorageClass: "STANDARD",
+	}
+}
+
+type entry struct {
+	key  string
+	p    string
+	info os.FileInfo
+}
+
+// entries walks the sorted objects of bucket with prefix
+func (g *Gateway) entries(ctx context.Context, bucket, prefix string) ([]entry, error) {
+	root := "/" + bucket
+	dir := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		if dir, _ = objectPath(bucket, prefix[:i+1]); dir == "" {
+			return nil, nil
+		}
+	}
+	var res []entry
+	err := g.walk(ctx, dir, func(p string, info os.FileInfo) {
+		if p == root {
+			return
+		}
+		key := strings.TrimPrefix(p, root+"/")
+		if info.IsDir() {
+			if !g.isEmptyDir(ctx, p) {
+				return
+			}
+			key += "/"
+		}
+		if strings.HasPrefix(key, prefix) {
+			res = append(res, entry{key: key, p: p, info: info})
+		}
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].key < res[j].key
+	})
+	return res, err
+}
+
+// walk calls fn for p and its descendants, missing directories are skipped
+func (g *Gateway) walk(ctx context.Context, p string, fn func(p string, info os.FileInfo)) error {
+	info, err := g.fs.StatContext(ctx, p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	fn(p, info)
+	if !info.IsDir() {
+		return nil
+	}
+	infos, err := g.readDir(ctx, p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, info := range infos {
+		if err := g.walk(ctx, p+"/"+info.Name(), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkPage fills res with the objects after start
+func (g *Gateway) walkPage(ctx context.Context, res *listBucketResult, bucket, start string) error {
+	entries, err := g.entries(ctx, bucket, res.Prefix)
+	if err != nil {
+		return err
+	}
+	prefix, delimiter := res.Prefix, res.Delimiter
+	startIsPrefix := delimiter != "" && strings.HasSuffix(start, delimiter)
+	last := ""
+	for _, e := range entries {
+		if e.key <= start || startIsPrefix && strings.HasPrefix(e.key, start) {
+			continue
+		}
+		name := e.key
+		isPrefix := false
+		if delimiter != "" {
+			if i := strings.Index(e.key[len(prefix):], delimiter); i >= 0 {
+				name = e.key[:len(prefix)+i+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if isPrefix && name == last {
+			continue
+		}
+		if res.KeyCount == res.MaxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = encodeToken(tokenKey, []byte(last))
+			break
+		}
+		last = name
+		res.KeyCount++
+		if isPrefix {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: name})
+			continue
+		}
+		res.Contents = append(res.Contents, g.listObject(ctx, e.key, e.p, e.info))
+	}
+	return nil
+}
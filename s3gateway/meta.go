@@ -0,0 +1,132 @@
+package s3gateway
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// metaStore keeps metadata of files of FS which are not vfs.Metadater, and
+// ETags computed by the gateway. Entries are dropped when the size or the
+// modification time of their file changed.
+type metaStore struct {
+	mu      sync.Mutex
+	entries map[string]*metaEntry // by path
+}
+
+type metaEntry struct {
+	md      *vfs.Metadata // nil if stored by the FS
+	etag    string
+	size    int64
+	modTime time.Time
+}
+
+func (s *metaStore) get(p string, info os.FileInfo) *metaEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[p]
+	if !ok {
+		return nil
+	}
+	if e.size != info.Size() || !e.modTime.Equal(info.ModTime()) {
+		delete(s.entries, p)
+		return nil
+	}
+	return e
+}
+
+func (s *metaStore) put(p string, info os.FileInfo, e *metaEntry) {
+	e.size, e.modTime = info.Size(), info.ModTime()
+	s.mu.Lock()
+	s.entries[p] = e
+	s.mu.Unlock()
+}
+
+func (s *metaStore) remove(p string) {
+	s.mu.Lock()
+	delete(s.entries, p)
+	s.mu.Unlock()
+}
+
+func cloneMetadata(md *vfs.Metadata) *vfs.Metadata {
+	res := &vfs.Metadata{ContentType: md.ContentType, ETag: md.ETag, User: map[string]string{}}
+	for k, v := range md.User {
+		res.User[k] = v
+	}
+	return res
+}
+
+// metadata returns the metadata of the file p, the ETag may be empty
+func (g *Gateway) metadata(ctx context.Context, p string, info os.FileInfo) *vfs.Metadata {
+	if m, ok := g.fs.(vfs.Metadater); ok && !info.IsDir() {
+		if md, err := m.GetMetadata(ctx, p); err == nil {
+			return md
+		}
+	}
+	if e := g.meta.get(p, info); e != nil && e.md != nil {
+		return cloneMetadata(e.md)
+	}
+	return &vfs.Metadata{User: map[string]string{}}
+}
+
+// setMetadata stores md of the file p written with etag, in the FS if it is a vfs.Metadater
+func (g *Gateway) setMetadata(ctx context.Context, p string, md *vfs.Metadata, etag string) error {
+	e := &metaEntry{md: md, etag: etag}
+	if m, ok := g.fs.(vfs.Metadater); ok && (md.ContentType != "" || len(md.User) > 0) {
+		err := m.SetMetadata(ctx, p, md)
+		if err == nil {
+			e.md = nil
+		} else if !errors.Is(err, vfs.ErrNotSupported) {
+			return err
+		}
+	}
+	info, err := g.fs.StatContext(ctx, p)
+	if err != nil {
+		return err
+	}
+	g.meta.put(p, info, e)
+	return nil
+}
+
+// etag returns the ETag of the file p, computing the md5 of its content if
+// neither the FS nor the gateway know it
+func (g *Gateway) etag(ctx context.Context, p string, info os.FileInfo) string {
+	if md, ok := info.Sys().(*vfs.Metadata); ok && md.ETag != "" {
+		return md.ETag
+	}
+	if info.IsDir() {
+		return emptyETag
+	}
+	e := g.meta.get(p, info)
+	if e != nil && e.etag != "" {
+		return e.etag
+	}
+	if md := g.metadata(ctx, p, info); md.ETag != "" {
+		return md.ETag
+	}
+	h := md5.New()
+	f, err := g.fs.OpenContext(ctx, p)
+	if err != nil {
+		return emptyETag
+	}
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return emptyETag
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	if e == nil {
+		e = &metaEntry{}
+	}
+	g.meta.put(p, info, &metaEntry{md: e.md, etag: etag})
+	return etag
+}
+
+var emptyETag = `"` + hex.EncodeToString(md5.New().Sum(nil)) + `"`
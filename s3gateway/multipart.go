@@ -0,0 +1,154 @@
+package s3gateway
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// upload is a multipart upload, parts are staged as /<id>/<part number>
+type upload struct {
+	bucket, key string
+	md          *vfs.Metadata
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+func (g *Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if _, err := objectPath(bucket, key); err != nil {
+		return err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+	if err := g.staging.MkdirAllContext(r.Context(), "/"+id, 0755); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.uploads[id] = &upload{bucket: bucket, key: key, md: requestMeta(r)}
+	g.mu.Unlock()
+	g.writeXML(w, http.StatusOK, &initiateMultipartUploadResult{Xmlns: xmlns, Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+func errNoSuchUpload(id string) *s3Error {
+	return &s3Error{Code: "NoSuchUpload", Message: "The specified upload does not exist.", Resource: id, status: http.StatusNotFound}
+}
+
+func (g *Gateway) upload(id string) (*upload, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	u, ok := g.uploads[id]
+	if !ok {
+		return nil, errNoSuchUpload(id)
+	}
+	return u, nil
+}
+
+func partPath(id string, n int) string {
+	return path.Join("/", id, strconv.Itoa(n))
+}
+
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, id, partNumber string) error {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > 10000 {
+		return errInvalidRequest("invalid part number")
+	}
+	if _, err := g.upload(id); err != nil {
+		return err
+	}
+	f, err := g.staging.OpenFileContext(r.Context(), partPath(id, n), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(h.Sum(nil))+`"`)
+	return nil
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (g *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, id string) error {
+	ctx := r.Context()
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &s3Error{Code: "MalformedXML", Message: err.Error(), status: http.StatusBadRequest}
+	}
+	u, err := g.upload(id)
+	if err != nil {
+		return err
+	}
+	var readers []io.Reader
+	closeAll := func() {
+		for _, r := range readers {
+			r.(vfs.File).Close()
+		}
+	}
+	for _, p := range req.Parts {
+		f, err := g.staging.OpenContext(ctx, partPath(id, p.PartNumber))
+		if err != nil {
+			closeAll()
+			return &s3Error{Code: "InvalidPart", Message: fmt.Sprintf("part %d not uploaded", p.PartNumber), status: http.StatusBadRequest}
+		}
+		readers = append(readers, f)
+	}
+	etag, err := g.write(ctx, u.bucket, u.key, io.MultiReader(readers...), u.md)
+	closeAll()
+	if err != nil {
+		return err
+	}
+	if err := g.abortMultipartUpload(ctx, id); err != nil {
+		return err
+	}
+	g.writeXML(w, http.StatusOK, &completeMultipartUploadResult{Xmlns: xmlns, Bucket: u.bucket, Key: u.key, ETag: etag})
+	return nil
+}
+
+// abortMultipartUpload removes the upload and its staged parts
+func (g *Gateway) abortMultipartUpload(ctx context.Context, id string) error {
+	if _, err := g.upload(id); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	delete(g.uploads, id)
+	g.mu.Unlock()
+	return g.staging.RemoveAllContext(ctx, "/"+id)
+}
@@ -0,0 +1,303 @@
+package s3gateway
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// objectPath maps a key to a path of the FS, keys ending with "/" are directory markers
+func objectPath(bucket, key string) (string, error) {
+	p := path.Join("/", bucket, key)
+	if !strings.HasPrefix(p, "/"+bucket+"/") {
+		return "", errInvalidRequest("invalid key " + key)
+	}
+	return p, nil
+}
+
+func (g *Gateway) statObject(ctx context.Context, bucket, key string) (os.FileInfo, error) {
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := g.fs.StatContext(ctx, p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil || info.IsDir() != strings.HasSuffix(key, "/") {
+		return nil, errNoSuchKey(key)
+	}
+	if info.IsDir() && !g.isEmptyDir(ctx, p) {
+		// non empty directories are implied by their children
+		return nil, errNoSuchKey(key)
+	}
+	return info, nil
+}
+
+func (g *Gateway) isEmptyDir(ctx context.Context, p string) bool {
+	f, err := g.fs.OpenContext(ctx, p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	names, _ := f.Readdirnames(1)
+	return len(names) == 0
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	info, err := g.statObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	p, _ := objectPath(bucket, key)
+	md := g.metadata(ctx, p, info)
+	for k, v := range md.User {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	contentType := md.ContentType
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", g.etag(ctx, p, info))
+	if info.IsDir() {
+		http.ServeContent(w, r, "", info.ModTime(), strings.NewReader(""))
+		return nil
+	}
+	f, err := g.fs.OpenContext(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	http.ServeContent(w, r, "", info.ModTime(), f)
+	return nil
+}
+
+func requestMeta(r *http.Request) *vfs.Metadata {
+	md := &vfs.Metadata{ContentType: r.Header.Get("Content-Type"), User: map[string]string{}}
+	for k := range r.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-meta-") {
+			md.User[strings.TrimPrefix(k, "x-amz-meta-")] = r.Header.Get(k)
+		}
+	}
+	return md
+}
+
+// write stores body as key and returns its ETag
+func (g *Gateway) write(ctx context.Context, bucket, key string, body io.Reader, md *vfs.Metadata) (string, error) {
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(key, "/") {
+		if err := g.fs.MkdirAllContext(ctx, p, 0755); err != nil {
+			return "", err
+		}
+		return emptyETag, nil
+	}
+	if err := g.fs.MkdirAllContext(ctx, path.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	f, err := g.fs.OpenFileContext(ctx, p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), body); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	return etag, g.setMetadata(ctx, p, md, etag)
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	etag, err := g.write(r.Context(), bucket, key, r.Body, requestMeta(r))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag)
+	return nil
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+func (g *Gateway) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return errInvalidRequest("invalid copy source")
+	}
+	source, versionID, _ := strings.Cut(source, "?versionId=")
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if err := g.checkBucket(ctx, srcBucket); err != nil {
+		return err
+	}
+	if versionID != "" {
+		return g.copyObjectVersion(w, r, srcBucket, srcKey, versionID, bucket, key)
+	}
+	info, err := g.statObject(ctx, srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	src, _ := objectPath(srcBucket, srcKey)
+	replace := r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE"
+	md := requestMeta(r)
+	if !replace {
+		md = g.metadata(ctx, src, info)
+		md.ETag = ""
+	}
+
+	var etag string
+	if src == dst {
+		// only the metadata of an object copied onto itself changes
+		if !replace {
+			return errInvalidRequest("This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.")
+		}
+		etag = g.etag(ctx, src, info)
+		err = g.setMetadata(ctx, dst, md, etag)
+	} else {
+		etag, err = g.copy(ctx, src, dst, info, md, replace)
+	}
+	if errors.Is(err, vfs.ErrNotSupported) {
+		var body io.Reader = strings.NewReader("")
+		if !info.IsDir() {
+			f, err := g.fs.OpenContext(ctx, src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			body = f
+		}
+		etag, err = g.write(ctx, bucket, key, body, md)
+	}
+	if err != nil {
+		return err
+	}
+	g.writeXML(w, http.StatusOK, &copyObjectResult{LastModified: g.now().UTC().Format(timeFormat), ETag: etag})
+	return nil
+}
+
+// copy copies the file src by the vfs.Copier of the FS, keeping its metadata unless replaced by md
+func (g *Gateway) copy(ctx context.Context, src, dst string, info os.FileInfo, md *vfs.Metadata, replace bool) (string, error) {
+	c, ok := g.fs.(vfs.Copier)
+	if !ok || info.IsDir() {
+		return "", vfs.ErrNotSupported
+	}
+	if err := g.fs.MkdirAllContext(ctx, path.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if err := c.Copy(ctx, src, dst); err != nil {
+		return "", err
+	}
+	dstInfo, err := g.fs.StatContext(ctx, dst)
+	if err != nil {
+		return "", err
+	}
+	etag := g.etag(ctx, src, info)
+	if replace {
+		return etag, g.setMetadata(ctx, dst, md, etag)
+	}
+	if e := g.meta.get(src, info); e != nil {
+		g.meta.put(dst, dstInfo, &metaEntry{md: e.md, etag: e.etag})
+	} else {
+		g.meta.remove(dst)
+	}
+	return etag, nil
+}
+
+func (g *Gateway) deleteObject(ctx context.Context, bucket, key string) error {
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	info, err := g.fs.StatContext(ctx, p)
+	if err != nil || info.IsDir() != strings.HasSuffix(key, "/") {
+		// deleting a missing key succeeds
+		return nil
+	}
+	if info.IsDir() && !g.isEmptyDir(ctx, p) {
+		return nil
+	}
+	if err := g.fs.RemoveContext(ctx, p); err != nil {
+		return err
+	}
+	g.meta.remove(p)
+	// prefixes do not exist without objects
+	for dir := path.Dir(p); dir != "/"+bucket && g.isEmptyDir(ctx, dir); dir = path.Dir(dir) {
+		if err := g.fs.RemoveContext(ctx, dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (g *Gateway) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &s3Error{Code: "MalformedXML", Message: err.Error(), status: http.StatusBadRequest}
+	}
+	res := &deleteResult{Xmlns: xmlns}
+	// children before their directory markers
+	sort.Slice(req.Objects, func(i, j int) bool {
+		return req.Objects[i].Key > req.Objects[j].Key
+	})
+	for _, o := range req.Objects {
+		if err := g.deleteObject(r.Context(), bucket, o.Key); err != nil {
+			e := toS3Error(r, err)
+			res.Errors = append(res.Errors, deleteError{Key: o.Key, Code: e.Code, Message: e.Message})
+		} else if !req.Quiet {
+			res.Deleted = append(res.Deleted, deletedObject{Key: o.Key})
+		}
+	}
+	g.writeXML(w, http.StatusOK, res)
+	return nil
+}
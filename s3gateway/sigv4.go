@@ -0,0 +1,238 @@
+package s3gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// streamingPayload prefixes the payloads of aws-chunked bodies signed chunk by chunk
+	streamingPayload = "STREAMING-"
+	// maxSkew is the difference allowed between the date of a request and the clock
+	maxSkew = 15 * time.Minute
+	// maxExpires is the longest validity of presigned requests in seconds
+	maxExpires = 7 * 24 * 60 * 60
+)
+
+// authError is returned as an S3 error response
+type authError struct {
+	code    string
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.code + ": " + e.message
+}
+
+// verify checks the AWS signature version 4 of r, signed by the Authorization
+// header or presigned by query parameters, and returns its credential. The
+// body of a request signing its payload hash is replaced by a verified copy.
+func (g *Gateway) verify(r *http.Request, now time.Time) (*Credential, error) {
+	q := r.URL.Query()
+	var credential, signedHeaders, signature, date, payload string
+	presign := q.Get("X-Amz-Algorithm") != ""
+	if presign {
+		if q.Get("X-Amz-Algorithm") != algorithm {
+			return nil, &authError{"AuthorizationQueryParametersError", "unsupported algorithm"}
+		}
+		credential, signedHeaders, signature = q.Get("X-Amz-Credential"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		date = q.Get("X-Amz-Date")
+		payload = unsignedPayload
+		t, err := time.Parse(amzDateFormat, date)
+		if err != nil {
+			return nil, &authError{"AuthorizationQueryParametersError", "invalid X-Amz-Date"}
+		}
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || expires > maxExpires {
+			return nil, &authError{"AuthorizationQueryParametersError", "X-Amz-Expires must be between 0 and 604800 seconds"}
+		}
+		if now.After(t.Add(time.Duration(expires) * time.Second)) {
+			return nil, &authError{"AccessDenied", "Request has expired"}
+		}
+		if t.After(now.Add(maxSkew)) {
+			return nil, &authError{"AccessDenied", "Request is not valid yet"}
+		}
+	} else {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			return nil, &authError{"AccessDenied", "Access Denied"}
+		}
+		if !strings.HasPrefix(auth, algorithm+" ") {
+			return nil, &authError{"AuthorizationHeaderMalformed", "unsupported algorithm"}
+		}
+		for _, part := range strings.Split(strings.TrimPrefix(auth, algorithm+" "), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				signature = v
+			}
+		}
+		date = r.Header.Get("X-Amz-Date")
+		t, err := time.Parse(amzDateFormat, date)
+		if err != nil {
+			return nil, &authError{"AccessDenied", "invalid X-Amz-Date"}
+		}
+		if d := now.Sub(t); d > maxSkew || d < -maxSkew {
+			return nil, &authError{"RequestTimeTooSkewed", "The difference between the request time and the current time is too large."}
+		}
+		payload = r.Header.Get("X-Amz-Content-Sha256")
+		if payload == "" {
+			payload = emptySHA256
+		}
+	}
+
+	// credential is <access key>/<date>/<region>/<service>/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return nil, &authError{"AuthorizationHeaderMalformed", "invalid credential"}
+	}
+	cred, ok := g.credentials[parts[0]]
+	if !ok {
+		return nil, &authError{"InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."}
+	}
+	if parts[1] != date[:8] {
+		return nil, &authError{"AuthorizationHeaderMalformed", "the credential date " + parts[1] + " does not match X-Amz-Date"}
+	}
+	if parts[2] != g.region {
+		return nil, &authError{"AuthorizationHeaderMalformed", "the region " + parts[2] + " is wrong, expecting " + g.region}
+	}
+	if parts[3] != "s3" {
+		return nil, &authError{"AuthorizationHeaderMalformed", "the service " + parts[3] + " is wrong, expecting s3"}
+	}
+	scope := strings.Join(parts[1:], "/")
+
+	query := r.URL.Query()
+	query.Del("X-Amz-Signature")
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Replace(query.Encode(), "+", "%20", -1),
+		canonicalHeaders(r, signedHeaders) + "\n",
+		signedHeaders,
+		payload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{algorithm, date, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+cred.SecretKey), parts[1])
+	for _, p := range parts[2:] {
+		key = hmacSHA256(key, p)
+	}
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, &authError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+	}
+	if strings.HasPrefix(payload, streamingPayload) {
+		return nil, &s3Error{Code: "NotImplemented", Message: "aws-chunked payloads are not implemented, send UNSIGNED-PAYLOAD or the hash of the body", status: http.StatusNotImplemented}
+	}
+	if payload != unsignedPayload {
+		if err := g.checkPayload(r, payload); err != nil {
+			return nil, err
+		}
+	}
+	return &cred, nil
+}
+
+// checkPayload stages the body of r while hashing it, and replaces the body
+// by the staged copy if its hash is payload, so that handlers never see a
+// body which was not signed. Bodies are never buffered in memory, they are
+// rejected without staging FS.
+func (g *Gateway) checkPayload(r *http.Request, payload string) error {
+	mismatch := &s3Error{Code: "XAmzContentSHA256Mismatch", Message: "The provided 'x-amz-content-sha256' header does not match what was computed.", status: http.StatusBadRequest}
+	if r.ContentLength == 0 {
+		if payload != emptySHA256 {
+			return mismatch
+		}
+		return nil
+	}
+	if !g.stageBodies {
+		return &s3Error{Code: "NotImplemented", Message: "signed payloads require a staging FS, send UNSIGNED-PAYLOAD", status: http.StatusNotImplemented}
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	name := "/payload-" + hex.EncodeToString(b)
+	f, err := g.staging.OpenFileContext(ctx, name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r.Body)
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != payload {
+		err = mismatch
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	body := &stagedBody{File: f, remove: func() { g.staging.RemoveContext(context.Background(), name) }}
+	if err != nil {
+		body.Close()
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// stagedBody is a verified request body, removed when closed
+type stagedBody struct {
+	vfs.File
+	remove func()
+}
+
+func (b *stagedBody) Close() error {
+	err := b.File.Close()
+	b.remove()
+	return err
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func canonicalHeaders(r *http.Request, signedHeaders string) string {
+	names := strings.Split(signedHeaders, ";")
+	sort.Strings(names)
+	items := make([]string, len(names))
+	for i, name := range names {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			values := r.Header.Values(name)
+			for j := range values {
+				values[j] = strings.Join(strings.Fields(values[j]), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		items[i] = name + ":" + value
+	}
+	return strings.Join(items, "\n")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
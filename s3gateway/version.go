@@ -0,0 +1,175 @@
+package s3gateway
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// getBucketVersioning reports buckets as versioned when the FS keeps versions
+func (g *Gateway) getBucketVersioning(w http.ResponseWriter) error {
+	res := &versioningConfiguration{Xmlns: xmlns}
+	if g.versions != nil {
+		res.Status = "Enabled"
+	}
+	g.writeXML(w, http.StatusOK, res)
+	return nil
+}
+
+type objectVersion struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type deleteMarker struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
+
+type listVersionsResult struct {
+	XMLName       xml.Name        `xml:"ListVersionsResult"`
+	Xmlns         string          `xml:"xmlns,attr"`
+	Name          string          `xml:"Name"`
+	Prefix        string          `xml:"Prefix"`
+	MaxKeys       int             `xml:"MaxKeys"`
+	IsTruncated   bool            `xml:"IsTruncated"`
+	Versions      []objectVersion `xml:"Version"`
+	DeleteMarkers []deleteMarker  `xml:"DeleteMarker"`
+}
+
+// listObjectVersions lists the versions of the objects with prefix. Keys are
+// found by walking the bucket, so removed objects are not listed and results
+// are not paged.
+func (g *Gateway) listObjectVersions(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if g.versions == nil {
+		return errNotImplemented()
+	}
+	ctx := r.Context()
+	prefix := r.URL.Query().Get("prefix")
+	entries, err := g.entries(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	res := &listVersionsResult{Xmlns: xmlns, Name: bucket, Prefix: prefix, MaxKeys: 1000}
+	for _, e := range entries {
+		if e.info.IsDir() {
+			res.Versions = append(res.Versions, objectVersion{
+				Key:          e.key,
+				VersionId:    "null",
+				IsLatest:     true,
+				LastModified: e.info.ModTime().UTC().Format(timeFormat),
+				ETag:         emptyETag,
+				StorageClass: "STANDARD",
+			})
+			continue
+		}
+		versions, err := g.versions.ListVersions(ctx, e.p)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			modTime := v.ModTime.UTC().Format(timeFormat)
+			if v.DeleteMarker {
+				res.DeleteMarkers = append(res.DeleteMarkers, deleteMarker{Key: e.key, VersionId: v.ID, IsLatest: v.IsLatest, LastModified: modTime})
+				continue
+			}
+			ov := objectVersion{Key: e.key, VersionId: v.ID, IsLatest: v.IsLatest, LastModified: modTime, Size: v.Size, StorageClass: "STANDARD"}
+			if v.IsLatest {
+				ov.ETag = g.etag(ctx, e.p, e.info)
+			}
+			res.Versions = append(res.Versions, ov)
+		}
+	}
+	g.writeXML(w, http.StatusOK, res)
+	return nil
+}
+
+func errNoSuchVersion(key string) *s3Error {
+	return &s3Error{Code: "NoSuchVersion", Message: "The specified version does not exist.", Resource: key, status: http.StatusNotFound}
+}
+
+func (g *Gateway) getObjectVersion(w http.ResponseWriter, r *http.Request, bucket, key, id string) error {
+	if g.versions == nil {
+		return errNotImplemented()
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	f, err := g.versions.OpenVersion(r.Context(), p, id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNoSuchVersion(key)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Amz-Version-Id", id)
+	w.Header().Set("Content-Type", "binary/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+	return nil
+}
+
+// copyObjectVersion copies a version of srcKey, which restores it when copied onto its own key
+func (g *Gateway) copyObjectVersion(w http.ResponseWriter, r *http.Request, srcBucket, srcKey, id, bucket, key string) error {
+	if g.versions == nil {
+		return errNotImplemented()
+	}
+	ctx := r.Context()
+	src, err := objectPath(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if src == dst {
+		// the latest version would be read while being truncated
+		versions, err := g.versions.ListVersions(ctx, src)
+		if err == nil && len(versions) > 0 && versions[0].ID == id && !versions[0].DeleteMarker {
+			info, err := g.statObject(ctx, bucket, key)
+			if err != nil {
+				return err
+			}
+			g.writeXML(w, http.StatusOK, &copyObjectResult{LastModified: g.now().UTC().Format(timeFormat), ETag: g.etag(ctx, dst, info)})
+			return nil
+		}
+	}
+	f, err := g.versions.OpenVersion(ctx, src, id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNoSuchVersion(srcKey)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(key, "/") {
+		return errInvalidRequest("invalid key " + key)
+	}
+	etag, err := g.write(ctx, bucket, key, f, requestMeta(r))
+	if err != nil {
+		return err
+	}
+	g.writeXML(w, http.StatusOK, &copyObjectResult{LastModified: g.now().UTC().Format(timeFormat), ETag: etag})
+	return nil
+}
@@ -0,0 +1,214 @@
+// Package sftp serves a vfs.FS, usually a Vfs with all its mount points, over SFTP
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	psftp "github.com/pkg/sftp"
+)
+
+// handlers maps the requests of a SFTP session onto operations of a FS. All
+// operations use the context of the session, which carries its identity.
+type handlers struct {
+	ctx    context.Context
+	fs     vfs.ContextFS
+	mounts vfs.MountPather // nil if fs has no mount points
+}
+
+// NewHandlers returns the handlers of a psftp.RequestServer serving fsys. ctx
+// is used for all operations, e.g. carrying the vfs.Identity of the user.
+// Mount points can not be removed or renamed, see vfs.CopyTree for renames
+// between them.
+func NewHandlers(ctx context.Context, fsys vfs.FS) psftp.Handlers {
+	m, _ := fsys.(vfs.MountPather)
+	h := &handlers{ctx: ctx, fs: vfs.AsContextFS(fsys), mounts: m}
+	return psftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+var (
+	_ psftp.OpenFileWriter       = (*handlers)(nil)
+	_ psftp.PosixRenameFileCmder = (*handlers)(nil)
+)
+
+// toStatus maps err to an error the request server reports with a matching status code
+func toStatus(err error) error {
+	switch {
+	case err == nil, errors.Is(err, fs.ErrNotExist):
+		return err
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return psftp.ErrSSHFxPermissionDenied
+	case errors.Is(err, vfs.ErrNotSupported), errors.Is(err, syscall.ENOTSUP):
+		return psftp.ErrSSHFxOpUnsupported
+	}
+	return err
+}
+
+func (h *handlers) mounted(name string) bool {
+	return h.mounts != nil && (name == "/" || h.mounts.IsMountPath(name))
+}
+
+func (h *handlers) Fileread(r *psftp.Request) (io.ReaderAt, error) {
+	f, err := h.fs.OpenContext(h.ctx, r.Filepath)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return f, nil
+}
+
+func (h *handlers) Filewrite(r *psftp.Request) (io.WriterAt, error) {
+	return h.open(r, os.O_WRONLY)
+}
+
+func (h *handlers) OpenFile(r *psftp.Request) (psftp.WriterAtReaderAt, error) {
+	return h.open(r, os.O_RDWR)
+}
+
+func (h *handlers) open(r *psftp.Request, flag int) (vfs.File, error) {
+	pflags := r.Pflags()
+	if pflags.Append {
+		flag |= os.O_APPEND
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	f, err := h.fs.OpenFileContext(h.ctx, r.Filepath, flag, 0644)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return f, nil
+}
+
+func (h *handlers) Filecmd(r *psftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return toStatus(h.setstat(r))
+	case "Rename":
+		// unlike posix renames, SFTP renames do not replace existing files
+		if _, err := h.fs.StatContext(h.ctx, r.Target); err == nil {
+			return &fs.PathError{Op: "rename", Path: r.Target, Err: fs.ErrExist}
+		}
+		return toStatus(h.rename(r.Filepath, r.Target))
+	case "Rmdir":
+		info, err := h.fs.StatContext(h.ctx, r.Filepath)
+		if err != nil {
+			return toStatus(err)
+		}
+		if !info.IsDir() {
+			return &fs.PathError{Op: "rmdir", Path: r.Filepath, Err: syscall.ENOTDIR}
+		}
+		return toStatus(h.remove(r.Filepath))
+	case "Remove":
+		return toStatus(h.remove(r.Filepath))
+	case "Mkdir":
+		return toStatus(h.fs.MkdirContext(h.ctx, r.Filepath, 0755))
+	}
+	return psftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handlers) PosixRename(r *psftp.Request) error {
+	return toStatus(h.rename(r.Filepath, r.Target))
+}
+
+func (h *handlers) setstat(r *psftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size {
+		f, err := h.fs.OpenFileContext(h.ctx, r.Filepath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if err := f.Truncate(int64(attrs.Size)); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := h.fs.ChmodContext(h.ctx, r.Filepath, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err := h.fs.ChownContext(h.ctx, r.Filepath, int(attrs.UID), int(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := h.fs.ChtimesContext(h.ctx, r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *handlers) remove(name string) error {
+	if h.mounted(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return h.fs.RemoveContext(h.ctx, name)
+}
+
+func (h *handlers) rename(oldName, newName string) error {
+	if h.mounted(oldName) {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+	}
+	err := h.fs.RenameContext(h.ctx, oldName, newName)
+	// Vfs does not rename across mount points
+	if errors.Is(err, syscall.ENOTSUP) {
+		if err = vfs.CopyTree(h.ctx, h.fs, oldName, newName); err == nil {
+			err = h.fs.RemoveAllContext(h.ctx, oldName)
+		}
+	}
+	return err
+}
+
+func (h *handlers) Filelist(r *psftp.Request) (psftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		// a Vfs merges the mount points below the directory into its entries
+		f, err := h.fs.OpenContext(h.ctx, r.Filepath)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		defer f.Close()
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := h.fs.StatContext(h.ctx, r.Filepath)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return listerAt{info}, nil
+	}
+	return nil, psftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
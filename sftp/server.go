@@ -0,0 +1,191 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+
+	"github.com/goxiaoy/vfs"
+	psftp "github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// identityExtension carries the authenticated vfs.Identity in ssh.Permissions
+const identityExtension = "vfs-identity"
+
+// PasswordAuth returns the identity of user if password is correct
+type PasswordAuth func(user string, password []byte) (*vfs.Identity, error)
+
+// PublicKeyAuth returns the identity of user if key is authorized
+type PublicKeyAuth func(user string, key ssh.PublicKey) (*vfs.Identity, error)
+
+// Server is an SSH server serving the "sftp" subsystem from a FS. Sessions
+// carry the identity returned by the authentication callbacks, defaulting to
+// the SSH user name, and are optionally chrooted by WithRoot.
+type Server struct {
+	fs       vfs.FS
+	config   *ssh.ServerConfig
+	password PasswordAuth
+	key      PublicKeyAuth
+	root     func(id *vfs.Identity) string
+	hostKey  ssh.Signer
+}
+
+type Option func(s *Server)
+
+// WithHostKey sets the host key of the server, a new key is generated on every start by default
+func WithHostKey(key ssh.Signer) Option {
+	return func(s *Server) {
+		s.hostKey = key
+	}
+}
+
+// WithPasswordAuth enables password authentication
+func WithPasswordAuth(auth PasswordAuth) Option {
+	return func(s *Server) {
+		s.password = auth
+	}
+}
+
+// WithPublicKeyAuth enables public key authentication
+func WithPublicKeyAuth(auth PublicKeyAuth) Option {
+	return func(s *Server) {
+		s.key = auth
+	}
+}
+
+// WithRoot chroots the sessions of each identity to the directory returned by root.
+// A Vfs is chrooted by a vfs.View sharing its mount points.
+func WithRoot(root func(id *vfs.Identity) string) Option {
+	return func(s *Server) {
+		s.root = root
+	}
+}
+
+// NewServer returns a server of fsys. Without WithPasswordAuth or WithPublicKeyAuth no user can log in.
+func NewServer(fsys vfs.FS, opts ...Option) (*Server, error) {
+	s := &Server{fs: fsys}
+	for _, o := range opts {
+		o(s)
+	}
+	s.config = &ssh.ServerConfig{}
+	if s.password != nil {
+		s.config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			id, err := s.password(conn.User(), password)
+			return permissions(conn, id, err)
+		}
+	}
+	if s.key != nil {
+		s.config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			id, err := s.key(conn.User(), key)
+			return permissions(conn, id, err)
+		}
+	}
+	if s.hostKey == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if s.hostKey, err = ssh.NewSignerFromKey(key); err != nil {
+			return nil, err
+		}
+	}
+	s.config.AddHostKey(s.hostKey)
+	return s, nil
+}
+
+func permissions(conn ssh.ConnMetadata, id *vfs.Identity, err error) (*ssh.Permissions, error) {
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		id = &vfs.Identity{ID: conn.User()}
+	}
+	b, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{Extensions: map[string]string{identityExtension: string(b)}}, nil
+}
+
+// Serve accepts connections on l until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until it is closed
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return err
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	id := &vfs.Identity{}
+	if err := json.Unmarshal([]byte(sconn.Permissions.Extensions[identityExtension]), id); err != nil {
+		return err
+	}
+	fsys, err := s.sessionFS(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(vfs.WithIdentity(context.Background(), id))
+	defer cancel()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveSession(ctx, fsys, channel, requests)
+	}
+	return nil
+}
+
+// sessionFS returns the FS of the sessions of id
+func (s *Server) sessionFS(id *vfs.Identity) (vfs.FS, error) {
+	if s.root == nil {
+		return s.fs, nil
+	}
+	root := s.root(id)
+	if v, ok := s.fs.(*vfs.Vfs); ok {
+		return v.View(root)
+	}
+	return afero.NewBasePathFs(s.fs, root), nil
+}
+
+// serveSession serves the "sftp" subsystem on channel
+func (s *Server) serveSession(ctx context.Context, fsys vfs.FS, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		// the payload of a subsystem request is the length prefixed name
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		server := psftp.NewRequestServer(channel, NewHandlers(ctx, fsys))
+		server.Serve()
+		server.Close()
+		return
+	}
+}
@@ -0,0 +1,210 @@
+package sftp_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/sftp"
+	psftp "github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func passwords(user string, password []byte) (*vfs.Identity, error) {
+	if string(password) != user+"-secret" {
+		return nil, errors.New("wrong password")
+	}
+	return &vfs.Identity{ID: user}, nil
+}
+
+func serve(t *testing.T, fsys vfs.FS, opts ...sftp.Option) string {
+	srv, err := sftp.NewServer(fsys, opts...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { l.Close() })
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr, user string, auth ...ssh.AuthMethod) (*psftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := psftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() {
+		c.Close()
+		conn.Close()
+	})
+	return c, nil
+}
+
+func names(t *testing.T, c *psftp.Client, dir string) []string {
+	t.Helper()
+	infos, err := c.ReadDir(dir)
+	assert.NoError(t, err)
+	var res []string
+	for _, info := range infos {
+		res = append(res, info.Name())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func readFile(t *testing.T, c *psftp.Client, name string) string {
+	t.Helper()
+	f, err := c.Open(name)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func writeFile(t *testing.T, c *psftp.Client, name, content string) {
+	t.Helper()
+	f, err := c.Create(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = f.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func newVfs(t *testing.T) *vfs.Vfs {
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/a/b", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/c", afero.NewMemMapFs()))
+	return v
+}
+
+func TestServer(t *testing.T) {
+	v := newVfs(t)
+	addr := serve(t, v, sftp.WithPasswordAuth(passwords))
+	c, err := dial(t, addr, "bob", ssh.Password("bob-secret"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, c.Mkdir("/dir"))
+	writeFile(t, c, "/dir/1.txt", "1")
+	assert.Equal(t, "1", readFile(t, c, "/dir/1.txt"))
+	// mount points are merged into listings
+	assert.Equal(t, []string{"a", "c", "dir"}, names(t, c, "/"))
+	assert.Equal(t, []string{"b"}, names(t, c, "/a"))
+
+	// within and across mount points
+	assert.NoError(t, c.Rename("/dir/1.txt", "/dir/2.txt"))
+	assert.NoError(t, c.Rename("/dir", "/c/dir"))
+	assert.Equal(t, "1", readFile(t, c, "/c/dir/2.txt"))
+	_, err = c.Stat("/dir")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	writeFile(t, c, "/c/3.txt", "3")
+	assert.Error(t, c.Rename("/c/3.txt", "/c/dir/2.txt"), "existing targets are not replaced")
+	assert.NoError(t, c.PosixRename("/c/3.txt", "/c/dir/2.txt"))
+	assert.Equal(t, "3", readFile(t, c, "/c/dir/2.txt"))
+
+	assert.NoError(t, c.Chmod("/c/dir/2.txt", 0600))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, c.Chtimes("/c/dir/2.txt", mtime, mtime))
+	info, err := c.Stat("/c/dir/2.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.True(t, mtime.Equal(info.ModTime()))
+	assert.NoError(t, c.Truncate("/c/dir/2.txt", 0))
+	assert.Equal(t, "", readFile(t, c, "/c/dir/2.txt"))
+
+	assert.NoError(t, c.Remove("/c/dir/2.txt"))
+	assert.NoError(t, c.RemoveDirectory("/c/dir"))
+	// mount points can not be removed or renamed
+	assert.Error(t, c.RemoveDirectory("/c"))
+	assert.Error(t, c.Rename("/a", "/d"))
+	assert.Equal(t, []string{"a", "c"}, names(t, c, "/"))
+}
+
+func TestAuth(t *testing.T) {
+	v := newVfs(t)
+	var identities []string
+	v.Use(func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		if id, ok := vfs.IdentityFromContext(ctx); ok {
+			identities = append(identities, id.ID)
+		}
+		return next(ctx, op)
+	})
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(key)
+	authorized, _ := ssh.NewPublicKey(pub)
+	addr := serve(t, v,
+		sftp.WithPasswordAuth(passwords),
+		sftp.WithPublicKeyAuth(func(user string, key ssh.PublicKey) (*vfs.Identity, error) {
+			if user != "alice" || string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, errors.New("unauthorized")
+			}
+			// the user name is the default identity
+			return nil, nil
+		}))
+
+	_, err := dial(t, addr, "bob", ssh.Password("wrong"))
+	assert.Error(t, err)
+	_, err = dial(t, addr, "bob", ssh.PublicKeys(signer))
+	assert.Error(t, err)
+
+	c, err := dial(t, addr, "alice", ssh.PublicKeys(signer))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = c.Stat("/a")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, identities)
+}
+
+func TestRoot(t *testing.T) {
+	v := newVfs(t)
+	assert.NoError(t, v.MkdirAll("/home/bob", 0755))
+	assert.NoError(t, v.MkdirAll("/home/alice", 0755))
+	assert.NoError(t, afero.WriteFile(v, "/home/alice/secret.txt", []byte("secret"), 0644))
+	assert.NoError(t, v.Mount("/home/bob/shared", afero.NewMemMapFs()))
+	addr := serve(t, v, sftp.WithPasswordAuth(passwords), sftp.WithRoot(func(id *vfs.Identity) string {
+		return "/home/" + id.ID
+	}))
+	c, err := dial(t, addr, "bob", ssh.Password("bob-secret"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	writeFile(t, c, "/1.txt", "1")
+	assert.Equal(t, []string{"1.txt", "shared"}, names(t, c, "/"))
+	exists, err := afero.Exists(v, "/home/bob/1.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	_, err = c.Stat("/../alice/secret.txt")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Error(t, c.RemoveDirectory("/shared"))
+}
@@ -0,0 +1,129 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Snapshot returns an immutable read-only FS of the subtree at prefix as it
+// is now, which can be mounted elsewhere, e.g. to back it up while writes
+// continue. The FS mounted at prefix must implement Snapshotter, ErrNotSupported
+// is returned otherwise. Mount points below prefix are not included.
+func (v *Vfs) Snapshot(prefix string) (FS, error) {
+	return v.SnapshotContext(context.Background(), prefix)
+}
+
+func (v *Vfs) SnapshotContext(ctx context.Context, prefix string) (FS, error) {
+	op := v.newOperation(OpSnapshot, prefix)
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		fsys, ok := op.MountPoint.fS.(Snapshotter)
+		if !ok {
+			return ErrNotSupported
+		}
+		op.Snapshot, err = fsys.Snapshot(ctx, op.Unrooted)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op.Snapshot, nil
+}
+
+// Restore makes the subtree at prefix equal to snapshot, e.g. one returned by
+// Snapshot. Entries missing from the snapshot are removed and files which
+// differ in size or modification time are copied through the Vfs, so
+// interceptors apply. Mount points below prefix are left untouched.
+func (v *Vfs) Restore(ctx context.Context, snapshot FS, prefix string) error {
+	prefix = path.Clean("/" + filepath.ToSlash(prefix))
+	target := func(p string) string {
+		return path.Join(prefix, filepath.ToSlash(p))
+	}
+	var extra []string
+	if _, err := v.StatContext(ctx, prefix); err == nil {
+		err := afero.Walk(v, prefix, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p = filepath.ToSlash(p)
+			if p != prefix && v.IsMountPath(p) {
+				if v.isMountPoint(p) {
+					return filepath.SkipDir
+				}
+				// leads to a mount point, only its content may be removed
+				return nil
+			}
+			sinfo, err := snapshot.Stat("/" + strings.TrimPrefix(p, prefix))
+			if err == nil && sinfo.IsDir() == info.IsDir() || p == prefix {
+				return nil
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			extra = append(extra, p)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, p := range extra {
+		if err := v.RemoveAllContext(ctx, p); err != nil {
+			return err
+		}
+	}
+	return afero.Walk(snapshot, "/", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dest := target(p)
+		if info.IsDir() {
+			return v.MkdirAllContext(ctx, dest, info.Mode().Perm())
+		}
+		if cur, err := v.StatContext(ctx, dest); err == nil && !cur.IsDir() &&
+			cur.Size() == info.Size() && cur.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		return v.restoreFile(ctx, snapshot, p, dest, info)
+	})
+}
+
+func (v *Vfs) restoreFile(ctx context.Context, snapshot FS, name, dest string, info os.FileInfo) error {
+	src, err := snapshot.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := v.OpenFileContext(ctx, dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// best effort, files of FS without Chtimes are copied again by the next restore
+	_ = v.ChtimesContext(ctx, dest, info.ModTime(), info.ModTime())
+	return nil
+}
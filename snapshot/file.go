@@ -0,0 +1,252 @@
+package snapshot
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// file of a snapshot. Live files are read from the FS until they are changed,
+// then from the copy preserved in the store.
+type file struct {
+	vfs.File
+	s    *Snapshot
+	p    string
+	name string
+	info os.FileInfo
+	live bool
+}
+
+func (f *file) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+// check switches a live file to its preserved copy. f.s.f.mu must be held.
+func (f *file) check() error {
+	if f.s.released {
+		return fs.ErrClosed
+	}
+	if !f.live {
+		return nil
+	}
+	if _, ok := f.s.saved[f.p]; !ok {
+		return nil
+	}
+	offset, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	c, err := f.s.f.store.Open(path.Join(f.s.dir, f.p))
+	if err != nil {
+		return err
+	}
+	if _, err := c.Seek(offset, io.SeekStart); err != nil {
+		c.Close()
+		return err
+	}
+	f.File.Close()
+	f.File, f.live = c, false
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.s.f.mu.RLock()
+	defer f.s.f.mu.RUnlock()
+	if err := f.check(); err != nil {
+		return 0, f.err("read", err)
+	}
+	return f.File.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.s.f.mu.RLock()
+	defer f.s.f.mu.RUnlock()
+	if err := f.check(); err != nil {
+		return 0, f.err("read", err)
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.s.f.mu.RLock()
+	defer f.s.f.mu.RUnlock()
+	if err := f.check(); err != nil {
+		return 0, f.err("seek", err)
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, f.err("readdir", syscall.ENOTDIR)
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	return nil, f.err("readdir", syscall.ENOTDIR)
+}
+
+func (f *file) Truncate(size int64) error {
+	return f.err("truncate", syscall.EROFS)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, f.err("write", syscall.EROFS)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	return 0, f.err("write", syscall.EROFS)
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return 0, f.err("write", syscall.EROFS)
+}
+
+// writeFile is a file of FS opened for writing. Snapshots taken while it is
+// open preserve its content before its next change.
+type writeFile struct {
+	vfs.File
+	f   *FS
+	p   string
+	seq int // f.seq when the file was last preserved, guarded by f.mu
+}
+
+// change runs fn with f.mu held for reading, once the file is preserved by
+// the snapshots taken since it was last changed
+func (w *writeFile) change(fn func() error) error {
+	for {
+		w.f.mu.RLock()
+		if w.seq == w.f.seq {
+			break
+		}
+		w.f.mu.RUnlock()
+		w.f.mu.Lock()
+		err := w.f.save(false, w.p)
+		if err == nil {
+			w.seq = w.f.seq
+		}
+		w.f.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	defer w.f.mu.RUnlock()
+	return fn()
+}
+
+func (w *writeFile) Write(p []byte) (n int, err error) {
+	err = w.change(func() error {
+		n, err = w.File.Write(p)
+		return err
+	})
+	return n, err
+}
+
+func (w *writeFile) WriteAt(p []byte, off int64) (n int, err error) {
+	err = w.change(func() error {
+		n, err = w.File.WriteAt(p, off)
+		return err
+	})
+	return n, err
+}
+
+func (w *writeFile) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *writeFile) Truncate(size int64) error {
+	return w.change(func() error {
+		return w.File.Truncate(size)
+	})
+}
+
+// dir of a snapshot, listed when opened
+type dir struct {
+	name   string
+	info   os.FileInfo
+	infos  []os.FileInfo
+	offset int
+}
+
+var _ vfs.File = (*dir)(nil)
+
+func (d *dir) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: d.name, Err: err}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, d.err("read", syscall.EISDIR)
+}
+
+func (d *dir) ReadAt(p []byte, off int64) (int, error) {
+	return 0, d.err("read", syscall.EISDIR)
+}
+
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	return 0, d.err("seek", syscall.EISDIR)
+}
+
+func (d *dir) Name() string {
+	return d.name
+}
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.infos[d.offset:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(rest) {
+			rest = rest[:count]
+		}
+	}
+	d.offset += len(rest)
+	return rest, nil
+}
+
+func (d *dir) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (d *dir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Sync() error {
+	return nil
+}
+
+func (d *dir) Truncate(size int64) error {
+	return d.err("truncate", syscall.EROFS)
+}
+
+func (d *dir) Write(p []byte) (int, error) {
+	return 0, d.err("write", syscall.EROFS)
+}
+
+func (d *dir) WriteAt(p []byte, off int64) (int, error) {
+	return 0, d.err("write", syscall.EROFS)
+}
+
+func (d *dir) WriteString(s string) (int, error) {
+	return 0, d.err("write", syscall.EROFS)
+}
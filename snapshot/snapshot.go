@@ -0,0 +1,511 @@
+// Package snapshot adds instant copy-on-write snapshots to any FS
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// FS wraps a FS taking snapshots of its subtrees. Taking a snapshot only
+// records its root, the previous state of a path is copied to the store when
+// it is first changed through FS, so unchanged files are shared with the live
+// FS. Snapshots are kept in memory until released.
+type FS struct {
+	fsys  vfs.FS
+	store vfs.FS
+	mu    sync.RWMutex // held for writing while preserving, for reading by snapshot reads of the live FS
+	snaps []*Snapshot
+	seq   int
+	now   func() time.Time
+}
+
+var (
+	_ vfs.FS          = (*FS)(nil)
+	_ vfs.Snapshotter = (*FS)(nil)
+)
+
+type Option func(f *FS)
+
+// WithClock sets the clock of snapshot times, defaults to time.Now
+func WithClock(now func() time.Time) Option {
+	return func(f *FS) {
+		f.now = now
+	}
+}
+
+// New wraps fsys, preserving previous content of changed files in store
+func New(fsys, store vfs.FS, opts ...Option) *FS {
+	f := &FS{fsys: fsys, store: store, now: time.Now}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// within reports whether p is dir or below it
+func within(dir, p string) bool {
+	return dir == "/" || p == dir || len(p) > len(dir) && p[:len(dir)] == dir && p[len(dir)] == '/'
+}
+
+// Snapshot captures the subtree at name, the returned FS is a *Snapshot
+func (f *FS) Snapshot(ctx context.Context, name string) (vfs.FS, error) {
+	p := clean(name)
+	info, err := f.fsys.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "snapshot", Path: name, Err: syscall.ENOTDIR}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	t := f.now()
+	s := &Snapshot{
+		f:     f,
+		root:  p,
+		dir:   fmt.Sprintf("/%d-%d", t.UnixNano(), f.seq),
+		time:  t,
+		saved: map[string]*entry{},
+	}
+	f.snaps = append(f.snaps, s)
+	return s, nil
+}
+
+// Snapshots returns the snapshots which are not released
+func (f *FS) Snapshots() []*Snapshot {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]*Snapshot(nil), f.snaps...)
+}
+
+// preserve saves the current state of names in the snapshots covering them,
+// tree also saves the content of directories, e.g. before removing them
+func (f *FS) preserve(tree bool, names ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.save(tree, names...)
+}
+
+// save is preserve with f.mu held for writing
+func (f *FS) save(tree bool, names ...string) error {
+	for _, s := range f.snaps {
+		for _, p := range names {
+			var err error
+			switch {
+			case within(s.root, p):
+				err = s.save(p, tree)
+			case tree && within(p, s.root):
+				// an ancestor of the snapshot is removed or renamed
+				err = s.save(s.root, true)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FS) Create(name string) (vfs.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	p := clean(name)
+	if err := f.preserve(false, p); err != nil {
+		return err
+	}
+	return f.fsys.Mkdir(p, perm)
+}
+
+func (f *FS) MkdirAll(name string, perm os.FileMode) error {
+	p := clean(name)
+	var names []string
+	for q := p; q != "/"; q = path.Dir(q) {
+		names = append(names, q)
+	}
+	if err := f.preserve(false, names...); err != nil {
+		return err
+	}
+	return f.fsys.MkdirAll(p, perm)
+}
+
+func (f *FS) Open(name string) (vfs.File, error) {
+	return f.fsys.Open(clean(name))
+}
+
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	p := clean(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return f.fsys.OpenFile(p, flag, perm)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.save(false, p); err != nil {
+		return nil, err
+	}
+	file, err := f.fsys.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return file, nil
+	}
+	return &writeFile{File: file, f: f, p: p, seq: f.seq}, nil
+}
+
+func (f *FS) Remove(name string) error {
+	p := clean(name)
+	if err := f.preserve(true, p); err != nil {
+		return err
+	}
+	return f.fsys.Remove(p)
+}
+
+func (f *FS) RemoveAll(name string) error {
+	p := clean(name)
+	if err := f.preserve(true, p); err != nil {
+		return err
+	}
+	return f.fsys.RemoveAll(p)
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	oldp, newp := clean(oldname), clean(newname)
+	if err := f.preserve(true, oldp, newp); err != nil {
+		return err
+	}
+	return f.fsys.Rename(oldp, newp)
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	return f.fsys.Stat(clean(name))
+}
+
+func (f *FS) Name() string {
+	return "snapshot"
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	p := clean(name)
+	if err := f.preserve(false, p); err != nil {
+		return err
+	}
+	return f.fsys.Chmod(p, mode)
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	p := clean(name)
+	if err := f.preserve(false, p); err != nil {
+		return err
+	}
+	return f.fsys.Chown(p, uid, gid)
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	p := clean(name)
+	if err := f.preserve(false, p); err != nil {
+		return err
+	}
+	return f.fsys.Chtimes(p, atime, mtime)
+}
+
+// entry is the state of a path at the time of a snapshot
+type entry struct {
+	info os.FileInfo // nil if the path did not exist
+	tree bool        // all entries of the directory are saved
+}
+
+// Snapshot is a read-only FS of a subtree at the time it was taken. Paths
+// are relative to the root of the snapshot.
+type Snapshot struct {
+	f        *FS
+	root     string
+	dir      string // of preserved files in the store
+	time     time.Time
+	saved    map[string]*entry // by path in the FS, guarded by f.mu
+	released bool
+}
+
+var _ vfs.FS = (*Snapshot)(nil)
+
+// Root returns the path of the snapshotted subtree
+func (s *Snapshot) Root() string {
+	return s.root
+}
+
+// Time returns when the snapshot was taken
+func (s *Snapshot) Time() time.Time {
+	return s.time
+}
+
+// Release drops the snapshot and removes its preserved files from the store
+func (s *Snapshot) Release() error {
+	s.f.mu.Lock()
+	for i, o := range s.f.snaps {
+		if o == s {
+			s.f.snaps = append(s.f.snaps[:i], s.f.snaps[i+1:]...)
+			break
+		}
+	}
+	s.released = true
+	s.f.mu.Unlock()
+	return s.f.store.RemoveAll(s.dir)
+}
+
+// decided reports whether an ancestor of p is saved as missing or with all
+// its entries, so that p did not exist unless it is saved itself
+func (s *Snapshot) decided(p string) bool {
+	for q := p; q != s.root && q != "/"; {
+		q = path.Dir(q)
+		if e, ok := s.saved[q]; ok && (e.info == nil || e.tree) {
+			return true
+		}
+	}
+	return false
+}
+
+// save records p unless it is saved already, copying files to the store.
+// f.mu must be held for writing.
+func (s *Snapshot) save(p string, tree bool) error {
+	e, ok := s.saved[p]
+	if !ok {
+		if s.decided(p) {
+			return nil
+		}
+		info, err := s.f.fsys.Stat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			s.saved[p] = &entry{}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := s.copy(p); err != nil {
+				return err
+			}
+		}
+		e = &entry{info: freeze(info)}
+		s.saved[p] = e
+	}
+	if !tree || e.info == nil || !e.info.IsDir() || e.tree {
+		return nil
+	}
+	names, err := readDirNames(s.f.fsys, p)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.save(path.Join(p, name), true); err != nil {
+			return err
+		}
+	}
+	e.tree = true
+	return nil
+}
+
+// fileInfo is a copy of a FileInfo, those of some FS change with the file
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func freeze(info os.FileInfo) os.FileInfo {
+	return &fileInfo{name: info.Name(), size: info.Size(), mode: info.Mode(), modTime: info.ModTime()}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() os.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() any           { return nil }
+
+func (s *Snapshot) copy(p string) error {
+	src, err := s.f.fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest := path.Join(s.dir, p)
+	if err := s.f.store.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := s.f.store.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readDirNames(fsys vfs.FS, p string) ([]string, error) {
+	d, err := fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
+
+// stat returns the info of p at the time of the snapshot, saved reports
+// whether it was changed since. f.mu must be held.
+func (s *Snapshot) stat(p string) (info os.FileInfo, saved bool, err error) {
+	if s.released {
+		return nil, false, fs.ErrClosed
+	}
+	if e, ok := s.saved[p]; ok {
+		if e.info == nil {
+			return nil, true, fs.ErrNotExist
+		}
+		return e.info, true, nil
+	}
+	if s.decided(p) {
+		return nil, false, fs.ErrNotExist
+	}
+	info, err = s.f.fsys.Stat(p)
+	return info, false, err
+}
+
+// readDir lists p at the time of the snapshot. f.mu must be held.
+func (s *Snapshot) readDir(p string) ([]os.FileInfo, error) {
+	seen := map[string]bool{}
+	var infos []os.FileInfo
+	if e, ok := s.saved[p]; !ok || !e.tree {
+		names, err := readDirNames(s.f.fsys, p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, name := range names {
+			seen[name] = true
+			info, _, err := s.stat(path.Join(p, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+	}
+	for q, e := range s.saved {
+		if e.info != nil && q != p && path.Dir(q) == p && !seen[path.Base(q)] {
+			infos = append(infos, e.info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+func (s *Snapshot) path(name string) string {
+	return path.Join(s.root, clean(name))
+}
+
+func (s *Snapshot) Open(name string) (vfs.File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s *Snapshot) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	p := s.path(name)
+	s.f.mu.RLock()
+	defer s.f.mu.RUnlock()
+	info, saved, err := s.stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		infos, err := s.readDir(p)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &dir{name: name, info: info, infos: infos}, nil
+	}
+	var f vfs.File
+	if saved {
+		f, err = s.f.store.Open(path.Join(s.dir, p))
+	} else {
+		f, err = s.f.fsys.Open(p)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{File: f, s: s, p: p, name: name, info: info, live: !saved}, nil
+}
+
+func (s *Snapshot) Stat(name string) (os.FileInfo, error) {
+	s.f.mu.RLock()
+	defer s.f.mu.RUnlock()
+	info, _, err := s.stat(s.path(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (s *Snapshot) Name() string {
+	return "snapshot"
+}
+
+func (s *Snapshot) Create(name string) (vfs.File, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) Mkdir(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) MkdirAll(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) RemoveAll(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) Chmod(name string, mode os.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) Chown(name string, uid, gid int) error {
+	return &fs.PathError{Op: "chown", Path: name, Err: syscall.EROFS}
+}
+
+func (s *Snapshot) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: syscall.EROFS}
+}
@@ -0,0 +1,150 @@
+package snapshot_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/snapshot"
+	"github.com/goxiaoy/vfs/vfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+		f := snapshot.New(afero.NewMemMapFs(), afero.NewMemMapFs())
+		// every change is preserved
+		_, err := f.Snapshot(context.Background(), "/")
+		assert.NoError(t, err)
+		return f
+	}, vfstest.Skip("Linker", "Lister", "Copier", "Mover"))
+}
+
+func readFile(t *testing.T, fsys vfs.FS, name string) string {
+	b, err := afero.ReadFile(fsys, name)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func names(t *testing.T, fsys vfs.FS, name string) []string {
+	infos, err := afero.ReadDir(fsys, name)
+	assert.NoError(t, err)
+	var res []string
+	for _, info := range infos {
+		res = append(res, info.Name())
+	}
+	return res
+}
+
+func TestSnapshot(t *testing.T) {
+	store := afero.NewMemMapFs()
+	f := snapshot.New(afero.NewMemMapFs(), store)
+	assert.NoError(t, f.MkdirAll("/a/docs", 0755))
+	assert.NoError(t, afero.WriteFile(f, "/a/1.txt", []byte("one"), 0644))
+	assert.NoError(t, afero.WriteFile(f, "/a/docs/2.txt", []byte("two"), 0644))
+	assert.NoError(t, afero.WriteFile(f, "/b.txt", []byte("b"), 0644))
+
+	fsys, err := f.Snapshot(context.Background(), "/a")
+	assert.NoError(t, err)
+	s := fsys.(*snapshot.Snapshot)
+	assert.Equal(t, "/a", s.Root())
+
+	open, err := s.Open("/1.txt")
+	assert.NoError(t, err)
+	defer open.Close()
+
+	assert.NoError(t, afero.WriteFile(f, "/a/1.txt", []byte("changed"), 0644))
+	assert.NoError(t, f.Rename("/a/docs", "/a/moved"))
+	assert.NoError(t, f.MkdirAll("/a/new/dir", 0755))
+	assert.NoError(t, afero.WriteFile(f, "/a/new/dir/3.txt", []byte("three"), 0644))
+	assert.NoError(t, f.Remove("/b.txt"))
+
+	// an open file keeps reading the snapshot
+	b, err := io.ReadAll(open)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(b))
+
+	assert.Equal(t, "one", readFile(t, s, "/1.txt"))
+	assert.Equal(t, "two", readFile(t, s, "/docs/2.txt"))
+	assert.Equal(t, []string{"1.txt", "docs"}, names(t, s, "/"))
+	assert.Equal(t, []string{"2.txt"}, names(t, s, "/docs"))
+	_, err = s.Stat("/new/dir/3.txt")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, "changed", readFile(t, f, "/a/1.txt"))
+	assert.Equal(t, []string{"1.txt", "moved", "new"}, names(t, f, "/a"))
+
+	// read-only
+	err = afero.WriteFile(s, "/1.txt", []byte("x"), 0644)
+	assert.True(t, errors.Is(err, syscall.EROFS))
+	assert.True(t, errors.Is(s.RemoveAll("/docs"), syscall.EROFS))
+
+	assert.NoError(t, s.Release())
+	assert.Len(t, f.Snapshots(), 0)
+	_, err = s.Stat("/1.txt")
+	assert.True(t, errors.Is(err, os.ErrClosed))
+	left, err := afero.ReadDir(store, "/")
+	assert.NoError(t, err)
+	assert.Len(t, left, 0)
+}
+
+func TestRemoveAncestor(t *testing.T) {
+	f := snapshot.New(afero.NewMemMapFs(), afero.NewMemMapFs())
+	assert.NoError(t, f.MkdirAll("/tenants/a", 0755))
+	assert.NoError(t, afero.WriteFile(f, "/tenants/a/1.txt", []byte("one"), 0644))
+	s, err := f.Snapshot(context.Background(), "/tenants/a")
+	assert.NoError(t, err)
+
+	assert.NoError(t, f.RemoveAll("/tenants"))
+	assert.Equal(t, "one", readFile(t, s, "/1.txt"))
+}
+
+func TestVfs(t *testing.T) {
+	ctx := context.Background()
+	f := snapshot.New(afero.NewMemMapFs(), afero.NewMemMapFs())
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/tenants", f))
+	assert.NoError(t, v.MkdirAll("/tenants/a/docs", 0755))
+	assert.NoError(t, afero.WriteFile(v, "/tenants/a/docs/1.txt", []byte("one"), 0644))
+
+	s, err := v.Snapshot("/tenants/a")
+	assert.NoError(t, err)
+	assert.NoError(t, v.Mount("/backups/a", s))
+
+	assert.NoError(t, afero.WriteFile(v, "/tenants/a/docs/1.txt", []byte("changed"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/tenants/a/2.txt", []byte("two"), 0644))
+	assert.Equal(t, "one", readFile(t, v, "/backups/a/docs/1.txt"))
+	err = afero.WriteFile(v, "/backups/a/docs/1.txt", []byte("x"), 0644)
+	assert.True(t, errors.Is(err, syscall.EROFS))
+
+	assert.NoError(t, v.Restore(ctx, s, "/tenants/a"))
+	assert.Equal(t, "one", readFile(t, v, "/tenants/a/docs/1.txt"))
+	assert.Equal(t, []string{"docs"}, names(t, v, "/tenants/a"))
+
+	_, err = v.Snapshot("/backups/a")
+	assert.True(t, errors.Is(err, vfs.ErrNotSupported))
+}
+
+func TestOpenWriter(t *testing.T) {
+	f := snapshot.New(afero.NewMemMapFs(), afero.NewMemMapFs())
+	assert.NoError(t, afero.WriteFile(f, "/1.txt", []byte("old"), 0644))
+	w, err := f.OpenFile("/1.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	s1, err := f.Snapshot(context.Background(), "/")
+	assert.NoError(t, err)
+	_, err = w.WriteString("-written")
+	assert.NoError(t, err)
+	s2, err := f.Snapshot(context.Background(), "/")
+	assert.NoError(t, err)
+	assert.NoError(t, w.Truncate(3))
+
+	assert.Equal(t, "old", readFile(t, s1, "/1.txt"))
+	assert.Equal(t, "old-written", readFile(t, s2, "/1.txt"))
+	assert.Equal(t, "old", readFile(t, f, "/1.txt"))
+}
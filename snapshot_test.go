@@ -0,0 +1,41 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestore(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	shared := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(shared, "s.txt", []byte("s"), 0644))
+	assert.NoError(t, v.Mount("/tenants/a/shared", shared))
+	assert.NoError(t, afero.WriteFile(v, "/tenants/a/1.txt", []byte("changed"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/tenants/a/new/2.txt", []byte("2"), 0644))
+
+	snapshot := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(snapshot, "/1.txt", []byte("one"), 0644))
+	assert.NoError(t, afero.WriteFile(snapshot, "/docs/3.txt", []byte("3"), 0644))
+
+	assert.NoError(t, v.Restore(context.Background(), snapshot, "/tenants/a"))
+	b, err := afero.ReadFile(v, "/tenants/a/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(b))
+	b, err = afero.ReadFile(v, "/tenants/a/docs/3.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(b))
+	exist, err := afero.Exists(v, "/tenants/a/new")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+	// mount points are left untouched
+	exist, err = afero.Exists(v, "/tenants/a/shared/s.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	_, err = v.Snapshot("/tenants")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,367 @@
+// Package trash turns removals of a Vfs into moves to a trash directory, from
+// where removed files and directories can be restored or purged
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+)
+
+// Trash keeps the files and directories removed from the mount points it
+// intercepts in a directory of the Vfs. Each removed item is stored as
+// /<root>/<id>/ with an item.json describing it and its content in data.
+//
+// The root may be on another mount point than the removed items, which are
+// then copied and removed only once the copy is complete. Use a Trash per
+// mount point to keep separate trash areas.
+type Trash struct {
+	v         *vfs.Vfs
+	root      string
+	retention time.Duration
+	now       func() time.Time
+	mu        sync.Mutex // guards last
+	last      int64
+}
+
+type Option func(t *Trash)
+
+// WithRetention expires items removed for longer than d, defaults to 30 days.
+// Items never expire if d is 0.
+func WithRetention(d time.Duration) Option {
+	return func(t *Trash) {
+		t.retention = d
+	}
+}
+
+// WithClock sets the clock of removal times, defaults to time.Now
+func WithClock(now func() time.Time) Option {
+	return func(t *Trash) {
+		t.now = now
+	}
+}
+
+// New returns a Trash of v keeping removed items below root. Register
+// Interceptor on the mount points whose removals are moved to the trash.
+func New(v *vfs.Vfs, root string, opts ...Option) *Trash {
+	t := &Trash{v: v, root: path.Clean("/" + filepath.ToSlash(root)), retention: 30 * 24 * time.Hour, now: time.Now}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Item is a removed file or directory
+type Item struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // original path in the Vfs
+	IsDir     bool      `json:"isDir"`
+	Size      int64     `json:"size"` // of files
+	DeletedAt time.Time `json:"deletedAt"`
+	// DeletedBy is the id of the vfs.Identity of the removal, if any
+	DeletedBy string `json:"deletedBy,omitempty"`
+}
+
+const (
+	itemFile = "item.json"
+	dataFile = "data"
+)
+
+// Interceptor moves the targets of vfs.OpRemove and vfs.OpRemoveAll to the
+// trash instead of removing them. Expired items are purged by Run.
+func (t *Trash) Interceptor() vfs.Interceptor {
+	return func(ctx context.Context, op *vfs.Operation, next vfs.Handler) error {
+		if op.Name != vfs.OpRemove && op.Name != vfs.OpRemoveAll || t.contains(op.Path) {
+			return next(ctx, op)
+		}
+		info, err := t.v.StatContext(ctx, op.Path)
+		if err != nil {
+			// nothing to keep, let the removal report it
+			return next(ctx, op)
+		}
+		if info.IsDir() && op.Name == vfs.OpRemove && !t.isEmptyDir(ctx, op.Path) {
+			return next(ctx, op)
+		}
+		return t.put(ctx, op.Path, info, func() error { return next(ctx, op) })
+	}
+}
+
+// contains reports whether p is in the trash
+func (t *Trash) contains(p string) bool {
+	return p == t.root || strings.HasPrefix(p, t.root+"/")
+}
+
+func (t *Trash) isEmptyDir(ctx context.Context, p string) bool {
+	f, err := t.v.OpenContext(ctx, p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	names, _ := f.Readdirnames(1)
+	return len(names) == 0
+}
+
+// newID returns an id ordered after the ids returned before
+func (t *Trash) newID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.now().UnixNano()
+	if n <= t.last {
+		n = t.last + 1
+	}
+	t.last = n
+	return fmt.Sprintf("%016x", n)
+}
+
+// put moves p to a new item, remove removes p if it was copied
+func (t *Trash) put(ctx context.Context, p string, info os.FileInfo, remove func() error) error {
+	item := &Item{ID: t.newID(), Path: p, IsDir: info.IsDir(), DeletedAt: t.now()}
+	if !info.IsDir() {
+		item.Size = info.Size()
+	}
+	if id, ok := vfs.IdentityFromContext(ctx); ok {
+		item.DeletedBy = id.ID
+	}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	dir := path.Join(t.root, item.ID)
+	if err := t.v.MkdirAllContext(ctx, dir, 0755); err != nil {
+		return err
+	}
+	if err := t.writeFile(ctx, path.Join(dir, itemFile), b); err != nil {
+		t.v.RemoveAllContext(ctx, dir)
+		return err
+	}
+	if err := t.move(ctx, p, path.Join(dir, dataFile), remove); err != nil {
+		t.v.RemoveAllContext(ctx, dir)
+		return err
+	}
+	return nil
+}
+
+func (t *Trash) writeFile(ctx context.Context, name string, b []byte) error {
+	f, err := t.v.OpenFileContext(ctx, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// move renames src to dst. If they are on different FS, src is copied and
+// removed by remove once the copy is complete.
+func (t *Trash) move(ctx context.Context, src, dst string, remove func() error) error {
+	err := t.v.RenameContext(ctx, src, dst)
+	if err == nil || !errors.Is(err, syscall.ENOTSUP) && !errors.Is(err, syscall.EXDEV) && !errors.Is(err, vfs.ErrNotSupported) {
+		return err
+	}
+	if err := t.copy(ctx, src, dst); err != nil {
+		return err
+	}
+	return remove()
+}
+
+// copy copies the file or directory src to dst
+func (t *Trash) copy(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	info, err := t.v.StatContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return t.copyFile(ctx, src, dst, info)
+	}
+	if err := t.v.MkdirAllContext(ctx, dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	f, err := t.v.OpenContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := t.copy(ctx, path.Join(src, name), path.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Trash) copyFile(ctx context.Context, src, dst string, info os.FileInfo) error {
+	in, err := t.v.OpenContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := t.v.OpenFileContext(ctx, dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if n != info.Size() {
+		return &fs.PathError{Op: "copy", Path: src, Err: io.ErrUnexpectedEOF}
+	}
+	// best effort, not every FS supports Chtimes
+	_ = t.v.ChtimesContext(ctx, dst, info.ModTime(), info.ModTime())
+	return nil
+}
+
+// List lists the items in the trash, most recently removed first
+func (t *Trash) List(ctx context.Context) ([]*Item, error) {
+	f, err := t.v.OpenContext(ctx, t.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Item
+	for _, name := range names {
+		item, err := t.Get(ctx, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			// being added or purged
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+	return res, nil
+}
+
+// Get returns the item id
+func (t *Trash) Get(ctx context.Context, id string) (*Item, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") || id == "." || id == ".." {
+		return nil, &fs.PathError{Op: "get", Path: id, Err: fs.ErrNotExist}
+	}
+	f, err := t.v.OpenContext(ctx, path.Join(t.root, id, itemFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	item := &Item{}
+	if err := json.NewDecoder(f).Decode(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Restore moves the item id back to its original path, or to dest if not
+// empty. fs.ErrExist is returned if the target exists.
+func (t *Trash) Restore(ctx context.Context, id, dest string) error {
+	item, err := t.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if dest == "" {
+		dest = item.Path
+	}
+	dest = path.Clean("/" + filepath.ToSlash(dest))
+	if t.contains(dest) {
+		return &fs.PathError{Op: "restore", Path: dest, Err: syscall.EINVAL}
+	}
+	if _, err := t.v.StatContext(ctx, dest); err == nil {
+		return &fs.PathError{Op: "restore", Path: dest, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := t.v.MkdirAllContext(ctx, path.Dir(dest), 0755); err != nil {
+		return err
+	}
+	data := path.Join(t.root, id, dataFile)
+	if err := t.move(ctx, data, dest, func() error { return t.v.RemoveAllContext(ctx, data) }); err != nil {
+		return err
+	}
+	return t.v.RemoveAllContext(ctx, path.Join(t.root, id))
+}
+
+// Purge removes the item id permanently
+func (t *Trash) Purge(ctx context.Context, id string) error {
+	if _, err := t.Get(ctx, id); err != nil {
+		return err
+	}
+	dir := path.Join(t.root, id)
+	// the description goes last, so that a failed purge is listed
+	if err := t.v.RemoveAllContext(ctx, path.Join(dir, dataFile)); err != nil {
+		return err
+	}
+	return t.v.RemoveAllContext(ctx, dir)
+}
+
+// Run purges expired items every interval until ctx is done. Failed purges
+// are retried at the next interval.
+func (t *Trash) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			t.PurgeExpired(ctx)
+		}
+	}
+}
+
+// PurgeExpired removes the items removed for longer than the retention and
+// returns how many were removed
+func (t *Trash) PurgeExpired(ctx context.Context) (int, error) {
+	if t.retention <= 0 {
+		return 0, nil
+	}
+	items, err := t.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, item := range items {
+		if t.now().Sub(item.DeletedAt) <= t.retention {
+			continue
+		}
+		if err := t.Purge(ctx, item.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
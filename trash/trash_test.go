@@ -0,0 +1,151 @@
+package trash_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/faultfs"
+	"github.com/goxiaoy/vfs/trash"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func readFile(t *testing.T, fsys vfs.FS, name string) string {
+	b, err := afero.ReadFile(fsys, name)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestTrash(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		root string
+	}{
+		{"same mount", "/docs/.trash"},
+		{"other mount", "/trash/docs"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := vfs.WithIdentity(context.Background(), &vfs.Identity{ID: "alice"})
+			v := vfs.New()
+			tr := trash.New(v, tt.root)
+			assert.NoError(t, v.Mount("/docs", afero.NewMemMapFs(), vfs.WithInterceptors(tr.Interceptor())))
+			assert.NoError(t, v.Mount("/trash", afero.NewMemMapFs()))
+			assert.NoError(t, v.MkdirAll("/docs/dir/sub", 0755))
+			assert.NoError(t, afero.WriteFile(v, "/docs/1.txt", []byte("one"), 0644))
+			assert.NoError(t, afero.WriteFile(v, "/docs/dir/sub/2.txt", []byte("two"), 0644))
+
+			assert.NoError(t, v.RemoveContext(ctx, "/docs/1.txt"))
+			assert.NoError(t, v.RemoveAllContext(ctx, "/docs/dir"))
+			_, err := v.Stat("/docs/dir")
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+			// missing files are reported as before
+			assert.True(t, errors.Is(v.Remove("/docs/missing.txt"), fs.ErrNotExist))
+			assert.NoError(t, v.RemoveAll("/docs/missing"))
+
+			items, err := tr.List(ctx)
+			assert.NoError(t, err)
+			if !assert.Len(t, items, 2) {
+				t.FailNow()
+			}
+			assert.Equal(t, "/docs/dir", items[0].Path)
+			assert.True(t, items[0].IsDir)
+			assert.Equal(t, "/docs/1.txt", items[1].Path)
+			assert.Equal(t, int64(3), items[1].Size)
+			assert.Equal(t, "alice", items[1].DeletedBy)
+
+			assert.NoError(t, tr.Restore(ctx, items[0].ID, ""))
+			assert.Equal(t, "two", readFile(t, v, "/docs/dir/sub/2.txt"))
+			assert.NoError(t, tr.Restore(ctx, items[1].ID, "/docs/restored/1.txt"))
+			assert.Equal(t, "one", readFile(t, v, "/docs/restored/1.txt"))
+			items, err = tr.List(ctx)
+			assert.NoError(t, err)
+			assert.Len(t, items, 0)
+
+			assert.NoError(t, v.Remove("/docs/restored/1.txt"))
+			assert.NoError(t, afero.WriteFile(v, "/docs/restored/1.txt", []byte("new"), 0644))
+			items, err = tr.List(ctx)
+			assert.NoError(t, err)
+			assert.True(t, errors.Is(tr.Restore(ctx, items[0].ID, ""), fs.ErrExist))
+			assert.NoError(t, tr.Purge(ctx, items[0].ID))
+			_, err = tr.Get(ctx, items[0].ID)
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	now := time.Unix(0, 0)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	v := vfs.New()
+	tr := trash.New(v, "/.trash", trash.WithRetention(time.Hour), trash.WithClock(clock))
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs(), vfs.WithInterceptors(tr.Interceptor())))
+	assert.NoError(t, afero.WriteFile(v, "/1.txt", []byte("one"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/2.txt", []byte("two"), 0644))
+	assert.NoError(t, v.Remove("/1.txt"))
+
+	// removals do not purge
+	advance(2 * time.Hour)
+	assert.NoError(t, v.Remove("/2.txt"))
+	items, err := tr.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	n, err := tr.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	items, err = tr.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, "/2.txt", items[0].Path)
+	}
+
+	advance(2 * time.Hour)
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- tr.Run(runCtx, 10*time.Millisecond) }()
+	assert.Eventually(t, func() bool {
+		infos, err := afero.ReadDir(v, "/.trash")
+		return err == nil && len(infos) == 0
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestFailedCopy(t *testing.T) {
+	ctx := context.Background()
+	v := vfs.New()
+	tr := trash.New(v, "/trash")
+	store := faultfs.New(afero.NewMemMapFs())
+	assert.NoError(t, v.Mount("/docs", afero.NewMemMapFs(), vfs.WithInterceptors(tr.Interceptor())))
+	assert.NoError(t, v.Mount("/trash", store))
+	assert.NoError(t, v.MkdirAll("/docs/dir", 0755))
+	assert.NoError(t, afero.WriteFile(v, "/docs/dir/1.txt", []byte("one"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/docs/dir/2.txt", []byte("two"), 0644))
+
+	store.Add(faultfs.Rule{Ops: []string{faultfs.OpWrite}, Nth: 2, Err: syscall.ENOSPC})
+	err := v.RemoveAll("/docs/dir")
+	assert.True(t, errors.Is(err, syscall.ENOSPC))
+	// nothing is lost, nor left in the trash
+	assert.Equal(t, "one", readFile(t, v, "/docs/dir/1.txt"))
+	assert.Equal(t, "two", readFile(t, v, "/docs/dir/2.txt"))
+	items, err := tr.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+}
@@ -1,10 +1,51 @@
 package vfs
 
 import (
+	"context"
 	"io"
+	"os"
+	"path"
 	"path/filepath"
 )
 
+// CopyTree copies the file or directory tree src of fsys to dst with ctx,
+// e.g. to move files across the mount points of a Vfs, which Rename refuses
+func CopyTree(ctx context.Context, fsys ContextFS, src, dst string) error {
+	in, err := fsys.OpenContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		out, err := fsys.OpenFileContext(ctx, dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	if err := fsys.MkdirContext(ctx, dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	children, err := in.Readdir(-1)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := CopyTree(ctx, fsys, path.Join(src, child.Name()), path.Join(dst, child.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CopyFile slow copy file across different FS
 func CopyFile(srcFs FS, srcFilePath string, destFs FS, destFilePath string) error {
 	// Some code from https://www.socketloop.com/tutorials/golang-copy-directory-including-sub-directories-files
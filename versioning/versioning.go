@@ -0,0 +1,666 @@
+// Package versioning keeps previous versions of files when they are
+// overwritten or removed, like the versioning of S3 buckets
+package versioning
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+// FS wraps a FS keeping the previous versions of files in a store when they
+// are overwritten, removed or renamed through it. The wrapped FS holds the
+// latest versions, removals are recorded by delete markers. Files which
+// existed before they were first changed through FS have the version "null".
+//
+// The store is laid out as /<ab>/<sha256 of path>/ with an index.json
+// listing the versions and a file per previous version.
+type FS struct {
+	fsys        vfs.FS
+	store       vfs.FS
+	maxVersions int
+	maxAge      time.Duration
+	mu          sync.Mutex // guards indexes
+	now         func() time.Time
+}
+
+var (
+	_ vfs.FS        = (*FS)(nil)
+	_ vfs.Versioner = (*FS)(nil)
+)
+
+type Option func(f *FS)
+
+// WithMaxVersions keeps at most n previous versions of a file
+func WithMaxVersions(n int) Option {
+	return func(f *FS) {
+		f.maxVersions = n
+	}
+}
+
+// WithMaxAge removes previous versions replaced for longer than d
+func WithMaxAge(d time.Duration) Option {
+	return func(f *FS) {
+		f.maxAge = d
+	}
+}
+
+// WithClock sets the clock of version times, defaults to time.Now
+func WithClock(now func() time.Time) Option {
+	return func(f *FS) {
+		f.now = now
+	}
+}
+
+// New wraps fsys, storing previous versions in store. Retention limits are
+// applied when versions are added and by Prune.
+func New(fsys, store vfs.FS, opts ...Option) *FS {
+	f := &FS{fsys: fsys, store: store, now: time.Now}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+const nullVersion = "null"
+
+type version struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Deleted bool      `json:"deleted,omitempty"`
+	// Replaced is when a newer version was added
+	Replaced time.Time `json:"replaced"`
+}
+
+type index struct {
+	Path     string     `json:"path"`
+	Versions []*version `json:"versions"` // oldest first
+}
+
+func (ix *index) latest() *version {
+	if len(ix.Versions) == 0 {
+		return nil
+	}
+	return ix.Versions[len(ix.Versions)-1]
+}
+
+func (ix *index) find(id string) *version {
+	for _, v := range ix.Versions {
+		if v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// pathError reports err of name, the store paths of PathErrors are not exposed
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// dir returns the directory of the versions of p in the store
+func dir(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	h := hex.EncodeToString(sum[:])
+	return "/" + h[:2] + "/" + h
+}
+
+func (f *FS) loadIndex(p string) (*index, error) {
+	b, err := afero.ReadFile(f.store, dir(p)+"/index.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return &index{Path: p}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ix := &index{}
+	if err := json.Unmarshal(b, ix); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (f *FS) saveIndex(ix *index) error {
+	d := dir(ix.Path)
+	if len(ix.Versions) == 0 {
+		return f.store.RemoveAll(d)
+	}
+	b, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	if err := f.store.MkdirAll(d, 0755); err != nil {
+		return err
+	}
+	return afero.WriteFile(f.store, d+"/index.json", b, 0644)
+}
+
+// newID returns an id ordered after the versions of ix
+func (f *FS) newID(ix *index) string {
+	n := f.now().UnixNano()
+	if l := ix.latest(); l != nil {
+		if last, err := strconv.ParseInt(l.ID, 16, 64); err == nil && n <= last {
+			n = last + 1
+		}
+	}
+	return fmt.Sprintf("%016x", n)
+}
+
+// current records the file at p as the latest version of ix, unless it is
+// already, and stores a copy of it before it is changed. f.mu must be held.
+func (f *FS) current(ix *index) error {
+	info, err := f.fsys.Stat(ix.Path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.IsDir() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l := ix.latest()
+	if l == nil || l.Deleted {
+		// created before versioning or outside of FS
+		id := nullVersion
+		if l != nil {
+			id = f.newID(ix)
+		}
+		l = &version{ID: id, Size: info.Size(), ModTime: info.ModTime()}
+		ix.Versions = append(ix.Versions, l)
+	}
+	if _, err := f.store.Stat(dir(ix.Path) + "/" + l.ID); err == nil {
+		return nil
+	}
+	return f.copyBody(ix.Path, l.ID)
+}
+
+// copyBody stores the file at p as the body of the version id
+func (f *FS) copyBody(p, id string) error {
+	src, err := f.fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := f.store.MkdirAll(dir(p), 0755); err != nil {
+		return err
+	}
+	dst, err := f.store.Create(dir(p) + "/" + id)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// add appends v as the latest version and applies the retention limits. f.mu must be held.
+func (f *FS) add(ix *index, v *version) error {
+	if l := ix.latest(); l != nil {
+		l.Replaced = f.now()
+	}
+	ix.Versions = append(ix.Versions, v)
+	if _, err := f.prune(ix); err != nil {
+		return err
+	}
+	return f.saveIndex(ix)
+}
+
+// prune removes previous versions beyond the retention limits and delete
+// markers left without previous versions, it returns the number of removed versions
+func (f *FS) prune(ix *index) (int, error) {
+	if len(ix.Versions) == 0 {
+		return 0, nil
+	}
+	now := f.now()
+	latest := len(ix.Versions) - 1
+	var kept []*version
+	removed := 0
+	for i, v := range ix.Versions[:latest] {
+		if f.maxVersions > 0 && latest-i > f.maxVersions || f.maxAge > 0 && now.Sub(v.Replaced) > f.maxAge {
+			if err := f.store.Remove(dir(ix.Path) + "/" + v.ID); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return removed, err
+			}
+			removed++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	kept = append(kept, ix.Versions[latest])
+	if len(kept) == 1 && kept[0].Deleted {
+		kept = nil
+		removed++
+	}
+	ix.Versions = kept
+	return removed, nil
+}
+
+// Prune applies the age limit to all files and returns the number of removed versions
+func (f *FS) Prune(ctx context.Context) (int, error) {
+	var indexes []string
+	err := afero.Walk(f.store, "/", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == "index.json" {
+			indexes = append(indexes, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, p := range indexes {
+		n, err := f.pruneIndex(p)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (f *FS) pruneIndex(name string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := afero.ReadFile(f.store, name)
+	if err != nil {
+		return 0, err
+	}
+	ix := &index{}
+	if err := json.Unmarshal(b, ix); err != nil {
+		return 0, err
+	}
+	n, err := f.prune(ix)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, f.saveIndex(ix)
+}
+
+// files returns the files at p or below it
+func (f *FS) files(p string) ([]string, error) {
+	var res []string
+	err := afero.Walk(f.fsys, p, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			res = append(res, clean(name))
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return res, err
+}
+
+// update preserves the files at paths, calls change and records their new
+// state, e.g. delete markers for removed files
+func (f *FS) update(op, name string, paths []string, change func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	indexes := make([]*index, len(paths))
+	for i, p := range paths {
+		ix, err := f.loadIndex(p)
+		if err != nil {
+			return pathError(op, name, err)
+		}
+		if err := f.current(ix); err != nil {
+			return pathError(op, name, err)
+		}
+		indexes[i] = ix
+	}
+	if err := change(); err != nil {
+		// keep the adopted versions
+		for _, ix := range indexes {
+			f.saveIndex(ix)
+		}
+		return err
+	}
+	for _, ix := range indexes {
+		if err := f.record(ix); err != nil {
+			return pathError(op, name, err)
+		}
+	}
+	return nil
+}
+
+// record adds the state of the file of ix as a new version. Its body is
+// stored right away, as writers open at the same time may replace it before
+// it is preserved by the next change. f.mu must be held.
+func (f *FS) record(ix *index) error {
+	info, err := f.fsys.Stat(ix.Path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.IsDir() {
+		if l := ix.latest(); l == nil || l.Deleted {
+			return f.saveIndex(ix)
+		}
+		return f.add(ix, &version{ID: f.newID(ix), ModTime: f.now(), Deleted: true})
+	}
+	if err != nil {
+		return err
+	}
+	v := &version{ID: f.newID(ix), Size: info.Size(), ModTime: info.ModTime()}
+	if err := f.copyBody(ix.Path, v.ID); err != nil {
+		return err
+	}
+	return f.add(ix, v)
+}
+
+func (f *FS) Create(name string) (vfs.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	return f.fsys.Mkdir(clean(name), perm)
+}
+
+func (f *FS) MkdirAll(name string, perm os.FileMode) error {
+	return f.fsys.MkdirAll(clean(name), perm)
+}
+
+func (f *FS) Open(name string) (vfs.File, error) {
+	return f.fsys.Open(clean(name))
+}
+
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (vfs.File, error) {
+	p := clean(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return f.fsys.OpenFile(p, flag, perm)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ix, err := f.loadIndex(p)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if err := f.current(ix); err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if err := f.saveIndex(ix); err != nil {
+		return nil, pathError("open", name, err)
+	}
+	_, statErr := f.fsys.Stat(p)
+	file, err := f.fsys.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		return file, nil
+	}
+	return &writeFile{File: file, f: f, p: p, changed: statErr != nil || flag&os.O_TRUNC != 0}, nil
+}
+
+func (f *FS) Remove(name string) error {
+	p := clean(name)
+	return f.update("remove", name, []string{p}, func() error {
+		return f.fsys.Remove(p)
+	})
+}
+
+func (f *FS) RemoveAll(name string) error {
+	p := clean(name)
+	paths, err := f.files(p)
+	if err != nil {
+		return err
+	}
+	return f.update("remove", name, paths, func() error {
+		return f.fsys.RemoveAll(p)
+	})
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	oldp, newp := clean(oldname), clean(newname)
+	paths, err := f.files(oldp)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		paths = append(paths, path.Join(newp, p[len(oldp):]))
+	}
+	if len(paths) == 0 {
+		// the target of a missing file may exist
+		paths = append(paths, newp)
+	}
+	return f.update("rename", oldname, paths, func() error {
+		return f.fsys.Rename(oldp, newp)
+	})
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	return f.fsys.Stat(clean(name))
+}
+
+func (f *FS) Name() string {
+	return "versioning"
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return f.fsys.Chmod(clean(name), mode)
+}
+
+func (f *FS) Chown(name string, uid, gid int) error {
+	return f.fsys.Chown(clean(name), uid, gid)
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.fsys.Chtimes(clean(name), atime, mtime)
+}
+
+// versions returns the versions of p, the file at p is included if it was
+// not changed through FS yet. f.mu must be held.
+func (f *FS) versions(p string) (*index, error) {
+	ix, err := f.loadIndex(p)
+	if err != nil {
+		return nil, err
+	}
+	if l := ix.latest(); l == nil || l.Deleted {
+		info, err := f.fsys.Stat(p)
+		if err == nil && !info.IsDir() {
+			id := nullVersion
+			if l != nil {
+				id = f.newID(ix)
+			}
+			ix.Versions = append(ix.Versions, &version{ID: id, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	if len(ix.Versions) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return ix, nil
+}
+
+// ListVersions lists the versions of name, the latest first
+func (f *FS) ListVersions(ctx context.Context, name string) ([]*vfs.Version, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ix, err := f.versions(clean(name))
+	if err != nil {
+		return nil, pathError("listVersions", name, err)
+	}
+	res := make([]*vfs.Version, len(ix.Versions))
+	for i, v := range ix.Versions {
+		res[len(res)-1-i] = &vfs.Version{
+			ID:           v.ID,
+			Size:         v.Size,
+			ModTime:      v.ModTime,
+			IsLatest:     i == len(ix.Versions)-1,
+			DeleteMarker: v.Deleted,
+		}
+	}
+	return res, nil
+}
+
+// OpenVersion opens a version of name for reading, delete markers can not be opened
+func (f *FS) OpenVersion(ctx context.Context, name, id string) (vfs.File, error) {
+	p := clean(name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ix, err := f.versions(p)
+	if err != nil {
+		return nil, pathError("openVersion", name, err)
+	}
+	v := ix.find(id)
+	if v == nil || v.Deleted {
+		return nil, &fs.PathError{Op: "openVersion", Path: name, Err: fs.ErrNotExist}
+	}
+	var file vfs.File
+	if v == ix.latest() {
+		file, err = f.fsys.Open(p)
+	} else {
+		file, err = f.store.Open(dir(p) + "/" + v.ID)
+	}
+	if err != nil {
+		return nil, pathError("openVersion", name, err)
+	}
+	return &versionFile{File: file, name: name, info: &versionInfo{name: path.Base(p), v: v}}, nil
+}
+
+// RestoreVersion writes a copy of the version id as the latest version of name
+func (f *FS) RestoreVersion(ctx context.Context, name, id string) error {
+	p := clean(name)
+	f.mu.Lock()
+	ix, err := f.versions(p)
+	f.mu.Unlock()
+	if err == nil && ix.find(id) == ix.latest() {
+		// the latest version is restored already
+		return nil
+	}
+	src, err := f.OpenVersion(ctx, name, id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := f.fsys.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	dst, err := f.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return pathError("restoreVersion", name, err)
+	}
+	return dst.Close()
+}
+
+// writeFile records a new version when closed after changing
+type writeFile struct {
+	vfs.File
+	f       *FS
+	p       string
+	changed bool
+	closed  bool
+}
+
+func (w *writeFile) Write(p []byte) (int, error) {
+	w.changed = true
+	return w.File.Write(p)
+}
+
+func (w *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	w.changed = true
+	return w.File.WriteAt(p, off)
+}
+
+func (w *writeFile) WriteString(s string) (int, error) {
+	w.changed = true
+	return w.File.WriteString(s)
+}
+
+func (w *writeFile) Truncate(size int64) error {
+	w.changed = true
+	return w.File.Truncate(size)
+}
+
+func (w *writeFile) Close() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	if w.closed || !w.changed {
+		return nil
+	}
+	w.closed = true
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	ix, err := w.f.loadIndex(w.p)
+	if err != nil {
+		return pathError("close", w.p, err)
+	}
+	return pathError("close", w.p, w.f.record(ix))
+}
+
+// versionFile is an opened version
+type versionFile struct {
+	vfs.File
+	name string
+	info os.FileInfo
+}
+
+func (f *versionFile) Name() string {
+	return f.name
+}
+
+func (f *versionFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *versionFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+}
+
+func (f *versionFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+}
+
+func (f *versionFile) WriteString(s string) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+}
+
+func (f *versionFile) Truncate(size int64) error {
+	return &fs.PathError{Op: "truncate", Path: f.name, Err: syscall.EBADF}
+}
+
+type versionInfo struct {
+	name string
+	v    *version
+}
+
+func (i *versionInfo) Name() string       { return i.name }
+func (i *versionInfo) Size() int64        { return i.v.Size }
+func (i *versionInfo) Mode() os.FileMode  { return 0444 }
+func (i *versionInfo) ModTime() time.Time { return i.v.ModTime }
+func (i *versionInfo) IsDir() bool        { return false }
+func (i *versionInfo) Sys() any           { return nil }
@@ -0,0 +1,171 @@
+package versioning_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/goxiaoy/vfs/versioning"
+	"github.com/goxiaoy/vfs/vfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+		return versioning.New(afero.NewMemMapFs(), afero.NewMemMapFs())
+	}, vfstest.Skip("Linker", "Lister", "Copier", "Mover"))
+}
+
+func readVersion(t *testing.T, v vfs.Versioner, name, id string) string {
+	f, err := v.OpenVersion(context.Background(), name, id)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	fsys := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fsys, "/doc.txt", []byte("v0"), 0644))
+	f := versioning.New(fsys, afero.NewMemMapFs())
+
+	assert.NoError(t, afero.WriteFile(f, "/doc.txt", []byte("v1"), 0644))
+	assert.NoError(t, afero.WriteFile(f, "/doc.txt", []byte("v2"), 0644))
+	assert.NoError(t, f.Remove("/doc.txt"))
+
+	versions, err := f.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	if !assert.Len(t, versions, 4) {
+		t.FailNow()
+	}
+	assert.True(t, versions[0].IsLatest)
+	assert.True(t, versions[0].DeleteMarker)
+	assert.Equal(t, "null", versions[3].ID)
+	assert.Equal(t, int64(2), versions[1].Size)
+	assert.Equal(t, "v2", readVersion(t, f, "/doc.txt", versions[1].ID))
+	assert.Equal(t, "v1", readVersion(t, f, "/doc.txt", versions[2].ID))
+	assert.Equal(t, "v0", readVersion(t, f, "/doc.txt", "null"))
+	_, err = f.OpenVersion(ctx, "/doc.txt", versions[0].ID)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	assert.NoError(t, f.RestoreVersion(ctx, "/doc.txt", versions[2].ID))
+	b, err := afero.ReadFile(f, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(b))
+	versions, err = f.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 5)
+	assert.False(t, versions[0].DeleteMarker)
+
+	// renaming removes the source and writes the target
+	assert.NoError(t, f.MkdirAll("/dir", 0755))
+	assert.NoError(t, f.Rename("/doc.txt", "/dir/doc.txt"))
+	versions, err = f.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	assert.True(t, versions[0].DeleteMarker)
+	versions, err = f.ListVersions(ctx, "/dir/doc.txt")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	assert.NoError(t, f.RemoveAll("/dir"))
+	versions, err = f.ListVersions(ctx, "/dir/doc.txt")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "v1", readVersion(t, f, "/dir/doc.txt", versions[1].ID))
+
+	_, err = f.ListVersions(ctx, "/missing.txt")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	store := afero.NewMemMapFs()
+	f := versioning.New(afero.NewMemMapFs(), store, versioning.WithMaxVersions(2), versioning.WithMaxAge(time.Hour),
+		versioning.WithClock(func() time.Time { return now }))
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		assert.NoError(t, afero.WriteFile(f, "/doc.txt", []byte(content), 0644))
+		now = now.Add(time.Minute)
+	}
+	versions, err := f.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 3)
+	assert.Equal(t, "v2", readVersion(t, f, "/doc.txt", versions[2].ID))
+
+	now = now.Add(2 * time.Hour)
+	removed, err := f.Prune(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	versions, err = f.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	// delete markers expire with the last previous version
+	assert.NoError(t, f.Remove("/doc.txt"))
+	now = now.Add(2 * time.Hour)
+	removed, err = f.Prune(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	infos, err := afero.ReadDir(store, "/")
+	assert.NoError(t, err)
+	for _, info := range infos {
+		left, _ := afero.ReadDir(store, "/"+info.Name())
+		assert.Len(t, left, 0)
+	}
+}
+
+func TestVfs(t *testing.T) {
+	ctx := context.Background()
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/docs", versioning.New(afero.NewMemMapFs(), afero.NewMemMapFs())))
+	assert.NoError(t, afero.WriteFile(v, "/docs/1.txt", []byte("one"), 0644))
+	assert.NoError(t, afero.WriteFile(v, "/docs/1.txt", []byte("changed"), 0644))
+
+	versions, err := v.ListVersions(ctx, "/docs/1.txt")
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, "one", readVersion(t, v, "/docs/1.txt", versions[1].ID))
+		assert.NoError(t, v.RestoreVersion(ctx, "/docs/1.txt", versions[1].ID))
+	}
+	b, err := afero.ReadFile(v, "/docs/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(b))
+}
+
+func TestOverlappingWriters(t *testing.T) {
+	ctx := context.Background()
+	f := versioning.New(afero.NewMemMapFs(), afero.NewMemMapFs())
+	assert.NoError(t, afero.WriteFile(f, "/doc.txt", []byte("v1"), 0644))
+	w1, err := f.OpenFile("/doc.txt", os.O_WRONLY|os.O_TRUNC, 0644)
+	assert.NoError(t, err)
+	w2, err := f.OpenFile("/doc.txt", os.O_WRONLY|os.O_TRUNC, 0644)
+	assert.NoError(t, err)
+	_, err = w1.WriteString("v2")
+	assert.NoError(t, err)
+	assert.NoError(t, w1.Close())
+	assert.NoError(t, w2.Truncate(0))
+	_, err = w2.WriteString("v3")
+	assert.NoError(t, err)
+	assert.NoError(t, w2.Close())
+
+	versions, err := f.ListVersions(ctx, "/doc.txt")
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 3) {
+		assert.Equal(t, "v3", readVersion(t, f, "/doc.txt", versions[0].ID))
+		assert.Equal(t, "v2", readVersion(t, f, "/doc.txt", versions[1].ID))
+		assert.Equal(t, "v1", readVersion(t, f, "/doc.txt", versions[2].ID))
+	}
+
+	// errors do not expose the store
+	_, err = f.OpenVersion(ctx, "/doc.txt", "missing")
+	assert.EqualError(t, err, "openVersion /doc.txt: file does not exist")
+}
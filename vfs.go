@@ -22,7 +22,9 @@ var (
 )
 
 type Vfs struct {
-	mtab mountTable
+	mtab         mountTable
+	hub          watchHub
+	interceptors []Interceptor
 }
 
 func New() *Vfs {
@@ -37,7 +39,7 @@ func New() *Vfs {
 // slash-separated path and does not have to represent an existing directory
 // (in this respect it is similar to URL path). Mounted filesystem becomes
 // available to os package.
-func (v *Vfs) Mount(prefix string, fsys FS) error {
+func (v *Vfs) Mount(prefix string, fsys FS, opts ...MountOption) error {
 	if prefix == "" || prefix[0] != '/' || fsys == nil {
 		return &fs.PathError{Op: "mount", Path: prefix, Err: syscall.EINVAL}
 	}
@@ -45,12 +47,56 @@ func (v *Vfs) Mount(prefix string, fsys FS) error {
 		return &fs.PathError{Op: "mount", Path: prefix, Err: ErrRecursive}
 	}
 	prefix = path.Clean(prefix)
+	mp := &MountPoint{prefix: prefix, fS: fsys}
+	for _, opt := range opts {
+		opt(mp)
+	}
 	v.mtab.mu.Lock()
-	v.mtab.mounts.Put(prefix, &MountPoint{prefix, fsys, 0})
+	old, _ := v.mtab.mounts.Get(prefix)
+	if old != nil && old.prefix != prefix {
+		old = nil
+	}
+	v.mtab.mounts.Put(prefix, mp)
 	v.mtab.mu.Unlock()
+
+	v.hub.mu.Lock()
+	if old != nil {
+		v.hub.stop(old)
+	}
+	v.hub.start(v, mp)
+	v.hub.mu.Unlock()
+	if old != nil && old.health != nil {
+		old.health.stop()
+	}
+	if mp.health != nil {
+		mp.health.start(fsys)
+	}
+	v.notify(Event{Op: EventMount, Path: prefix, Mount: prefix})
 	return nil
 }
 
+// MountOption configures a MountPoint
+type MountOption func(mp *MountPoint)
+
+// WithInterceptors registers interceptors wrapping the operations of the mount point
+func WithInterceptors(interceptors ...Interceptor) MountOption {
+	return func(mp *MountPoint) {
+		mp.interceptors = append(mp.interceptors, interceptors...)
+	}
+}
+
+// WithLabels attaches labels to the mount point, e.g. for matching policies
+func WithLabels(labels map[string]string) MountOption {
+	return func(mp *MountPoint) {
+		if mp.labels == nil {
+			mp.labels = map[string]string{}
+		}
+		for k, v := range labels {
+			mp.labels[k] = v
+		}
+	}
+}
+
 // Unmount unmounts the last mounted filesystem that match fsys and prefix.
 // At least one parameter must be specified (not empty or nil).
 func (v *Vfs) Unmount(prefix string, fsys FS) error {
@@ -89,6 +135,14 @@ skip:
 		return &fs.PathError{Op: "unmount", Path: prefix, Err: err}
 	}
 
+	v.hub.mu.Lock()
+	v.hub.stop(mp)
+	v.hub.mu.Unlock()
+	if mp.health != nil {
+		mp.health.stop()
+	}
+	v.notify(Event{Op: EventUnmount, Path: mp.prefix, Mount: mp.prefix})
+
 	// close the fsys if it has no another mount point
 
 	v.mtab.mu.RLock()
@@ -124,6 +178,15 @@ func (v *Vfs) Mounts() []*MountPoint {
 	return list
 }
 
+// Lookup returns the mount point serving name and the path of name passed to
+// its FS, or nil if no mount point serves name
+func (v *Vfs) Lookup(name string) (*MountPoint, string) {
+	v.mtab.mu.RLock()
+	defer v.mtab.mu.RUnlock()
+	mp, _, unrooted := v.findMountPoint(name)
+	return mp, unrooted
+}
+
 // findMountPoints find matched mount point according to name
 func (v *Vfs) findMountPoint(name string) (mp *MountPoint, fsys FS, unrooted string) {
 	name = path.Clean(name)
@@ -143,9 +206,12 @@ func (v *Vfs) findMountPoint(name string) (mp *MountPoint, fsys FS, unrooted str
 
 // A MountPoint represents a mounted file system.
 type MountPoint struct {
-	prefix    string // path to FS
-	fS        FS     // mounted file system
-	openCount int32  // number of open files
+	prefix       string        // path to FS
+	fS           FS            // mounted file system
+	openCount    int32         // number of open files
+	interceptors []Interceptor // interceptors of operations on this mount point
+	labels       map[string]string
+	health       *health // nil without health check
 }
 
 func (mp *MountPoint) closed() {
@@ -161,8 +227,12 @@ func (mp *MountPoint) GetPrefix() string {
 func (mp *MountPoint) GetFS() FS {
 	return mp.fS
 }
+func (mp *MountPoint) GetLabels() map[string]string {
+	return mp.labels
+}
+
 func (mp *MountPoint) GetOpenCount() int32 {
-	return mp.openCount
+	return atomic.LoadInt32(&mp.openCount)
 }
 
 type mountTable struct {
@@ -170,4 +240,11 @@ type mountTable struct {
 	mounts *trie.PathTrie[*MountPoint]
 }
 
-var _ Blob = (*Vfs)(nil)
+var (
+	_ Blob      = (*Vfs)(nil)
+	_ ContextFS = (*Vfs)(nil)
+	_ Copier    = (*Vfs)(nil)
+	_ Lister    = (*Vfs)(nil)
+	_ Metadater = (*Vfs)(nil)
+	_ Versioner = (*Vfs)(nil)
+)
@@ -4,104 +4,138 @@ import (
 	"context"
 	"io/fs"
 	"os"
+	"path"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 func (v *Vfs) Create(name string) (File, error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return nil, syscall.ENOENT
-	}
-	return fsys.Create(unrooted)
+	return v.CreateContext(context.Background(), name)
 }
 
-func (v *Vfs) Mkdir(name string, perm os.FileMode) (err error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		err = syscall.ENOENT
-		goto error
+func (v *Vfs) CreateContext(ctx context.Context, name string) (File, error) {
+	op := v.newOperation(OpCreate, name)
+	op.Flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	var opened *fileWrapper
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		f, err := AsContextFS(op.MountPoint.fS).CreateContext(ctx, op.Unrooted)
+		if err != nil {
+			return err
+		}
+		opened = v.wrapFile(op, f, false)
+		op.File = opened
+		return nil
+	})
+	if err != nil {
+		if opened != nil {
+			opened.Close()
+		}
+		return nil, err
 	}
-	if err = fsys.Mkdir(unrooted, perm); err != nil {
-		goto error
+	return op.File, nil
+}
+
+func (v *Vfs) Mkdir(name string, perm os.FileMode) error {
+	return v.MkdirContext(context.Background(), name, perm)
+}
+
+func (v *Vfs) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	op := v.newOperation(OpMkdir, name)
+	op.Perm = perm
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return AsContextFS(op.MountPoint.fS).MkdirContext(ctx, op.Unrooted, op.Perm)
+	})
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 	return nil
-	err = syscall.ENOTSUP
-error:
-	return &fs.PathError{Op: "mkdir", Path: name, Err: err}
 }
 
-func (v *Vfs) MkdirAll(p string, perm os.FileMode) (err error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(p)
-	v.mtab.mu.RUnlock()
+func (v *Vfs) MkdirAll(p string, perm os.FileMode) error {
+	return v.MkdirAllContext(context.Background(), p, perm)
+}
 
-	if fsys == nil {
-		err = syscall.ENOENT
-		return &fs.PathError{Op: "mkdirAll", Path: p, Err: err}
+func (v *Vfs) MkdirAllContext(ctx context.Context, p string, perm os.FileMode) error {
+	op := v.newOperation(OpMkdirAll, p)
+	op.Perm = perm
+	if op.MountPoint == nil {
+		return &fs.PathError{Op: "mkdirAll", Path: p, Err: syscall.ENOENT}
 	}
-
-	// Fast path: if we can tell whether path is a directory or file, stop with success or error.
-	dir, err := v.Stat(p)
-	if err == nil {
-		if dir.IsDir() {
-			return nil
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		// Fast path: if we can tell whether path is a directory or file, stop with success or error.
+		dir, err := v.StatContext(ctx, p)
+		if err == nil {
+			if dir.IsDir() {
+				return nil
+			}
+			return &os.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
 		}
-		return &os.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
-	}
 
-	// Slow path: make sure parent exists and then call Mkdir for path.
-	i := len(p)
-	for i > 0 && os.IsPathSeparator(p[i-1]) { // Skip trailing path separator.
-		i--
-	}
-	j := i
-	for j > 0 && !os.IsPathSeparator(p[j-1]) { // Scan backward over element.
-		j--
-	}
-	if j > 1 {
-		// Recursively Create parent
-		err = v.MkdirAll(p[0:j-1], perm)
-		if err != nil {
-			return err
+		// Slow path: make sure parent exists and then call Mkdir for path.
+		i := len(p)
+		for i > 0 && os.IsPathSeparator(p[i-1]) { // Skip trailing path separator.
+			i--
 		}
-	}
-	//call underlying
-	return fsys.MkdirAll(unrooted, perm)
+		j := i
+		for j > 0 && !os.IsPathSeparator(p[j-1]) { // Scan backward over element.
+			j--
+		}
+		if j > 1 {
+			// Recursively Create parent
+			err = v.MkdirAllContext(ctx, p[0:j-1], op.Perm)
+			if err != nil {
+				return err
+			}
+		}
+		//call underlying
+		op.created = true
+		return AsContextFS(op.MountPoint.fS).MkdirAllContext(ctx, op.Unrooted, op.Perm)
+	})
+}
 
+func (v *Vfs) Open(name string) (File, error) {
+	return v.OpenContext(context.Background(), name)
 }
 
-func (v *Vfs) Open(name string) (f File, err error) {
-	v.mtab.mu.RLock()
-	mp, fsys, unrooted := v.findMountPoint(name)
-	if mp != nil {
-		if atomic.AddInt32(&mp.openCount, 1) < 0 {
-			atomic.AddInt32(&mp.openCount, -1)
-			err = syscall.EMFILE
-		}
+func (v *Vfs) OpenContext(ctx context.Context, name string) (File, error) {
+	op, err := v.newOpenOperation(OpOpen, name)
+	if err != nil {
+		return nil, err
 	}
-	v.mtab.mu.RUnlock()
+	f, err := v.open(ctx, op, func(ctx context.Context, op *Operation) (File, error) {
+		return AsContextFS(op.MountPoint.fS).OpenContext(ctx, op.Unrooted)
+	})
+	return v.openMountDir(op, f, err)
+}
+
+func (v *Vfs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return v.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (v *Vfs) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	op, err := v.newOpenOperation(OpOpenFile, name)
 	if err != nil {
 		return nil, err
 	}
-	if mp != nil {
-		f, err = fsys.Open(unrooted)
-		if err != nil {
-			return nil, err
+	op.Flag = flag
+	op.Perm = perm
+	f, err := v.open(ctx, op, func(ctx context.Context, op *Operation) (File, error) {
+		fsys := AsContextFS(op.MountPoint.fS)
+		if op.Flag&os.O_CREATE != 0 && v.watching() {
+			_, err := fsys.StatContext(ctx, op.Unrooted)
+			op.created = err != nil
 		}
-		return newFileWrapper(f, mp.closed), nil
-	}
-	return nil, syscall.ENOENT
+		return fsys.OpenFileContext(ctx, op.Unrooted, op.Flag, op.Perm)
+	})
+	return v.openMountDir(op, f, err)
 }
 
-func (v *Vfs) OpenFile(name string, flag int, perm os.FileMode) (f File, err error) {
+// newOpenOperation resolves the mount point of name and counts the file to be opened
+func (v *Vfs) newOpenOperation(opName string, name string) (op *Operation, err error) {
 	v.mtab.mu.RLock()
-	mp, fsys, unrooted := v.findMountPoint(name)
+	mp, _, unrooted := v.findMountPoint(name)
 	if mp != nil {
 		if atomic.AddInt32(&mp.openCount, 1) < 0 {
 			atomic.AddInt32(&mp.openCount, -1)
@@ -112,61 +146,97 @@ func (v *Vfs) OpenFile(name string, flag int, perm os.FileMode) (f File, err err
 	if err != nil {
 		return nil, err
 	}
-	if mp != nil {
-		f, err = fsys.OpenFile(unrooted, flag, perm)
+	return &Operation{Name: opName, Path: fullPath(mp, unrooted, name), MountPoint: mp, Unrooted: unrooted}, nil
+}
+
+// open invokes the operation counted by newOpenOperation
+func (v *Vfs) open(ctx context.Context, op *Operation, open func(ctx context.Context, op *Operation) (File, error)) (File, error) {
+	mp := op.MountPoint
+	var opened *fileWrapper
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		f, err := open(ctx, op)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		opened = v.wrapFile(op, f, true)
+		op.File = opened
+		return nil
+	})
+	if err != nil {
+		if opened != nil {
+			opened.Close()
+		} else if mp != nil {
+			mp.closed()
 		}
-		return newFileWrapper(f, mp.closed), nil
+		return nil, err
 	}
-	return nil, syscall.ENOENT
+	return op.File, nil
 }
 
 func (v *Vfs) Remove(name string) error {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return syscall.ENOENT
-	}
-	return fsys.Remove(unrooted)
+	return v.RemoveContext(context.Background(), name)
+}
+
+func (v *Vfs) RemoveContext(ctx context.Context, name string) error {
+	op := v.newOperation(OpRemove, name)
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return AsContextFS(op.MountPoint.fS).RemoveContext(ctx, op.Unrooted)
+	})
 }
 
 func (v *Vfs) RemoveAll(path string) error {
+	return v.RemoveAllContext(context.Background(), path)
+}
+
+func (v *Vfs) RemoveAllContext(ctx context.Context, path string) error {
 	//TODO different FS under path
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(path)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return syscall.ENOENT
-	}
-	return fsys.RemoveAll(unrooted)
+	op := v.newOperation(OpRemoveAll, path)
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return AsContextFS(op.MountPoint.fS).RemoveAllContext(ctx, op.Unrooted)
+	})
 }
 
 func (v *Vfs) Rename(oldname, newname string) error {
+	return v.RenameContext(context.Background(), oldname, newname)
+}
+
+func (v *Vfs) RenameContext(ctx context.Context, oldname, newname string) error {
+	op := v.newOperation(OpRename, oldname)
+	v.resolveNewPath(op, newname)
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		if op.MountPoint.fS == op.NewMountPoint.fS {
+			return AsContextFS(op.MountPoint.fS).RenameContext(ctx, op.Unrooted, op.NewUnrooted)
+		}
+		// unsupported operation
+		// TODO should we add option for copy then delete
+		return syscall.ENOTSUP
+	})
+}
+
+// resolveNewPath resolves the mount point of the destination of op
+func (v *Vfs) resolveNewPath(op *Operation, newname string) {
 	v.mtab.mu.RLock()
-	_, oldfs, oldunrooted := v.findMountPoint(oldname)
-	_, newfs, newunrooted := v.findMountPoint(newname)
+	newmp, _, newunrooted := v.findMountPoint(newname)
 	v.mtab.mu.RUnlock()
-	if oldfs == nil || newfs == nil {
-		return syscall.ENOENT
-	}
-	if oldfs == newfs {
-		return oldfs.Rename(oldunrooted, newunrooted)
-	}
-	// unsupported operation
-	// TODO should we add option for copy then delete
-	return syscall.ENOTSUP
+	op.NewPath = fullPath(newmp, newunrooted, newname)
+	op.NewMountPoint = newmp
+	op.NewUnrooted = newunrooted
 }
 
 func (v *Vfs) Stat(name string) (os.FileInfo, error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return nil, syscall.ENOENT
+	return v.StatContext(context.Background(), name)
+}
+
+func (v *Vfs) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	op := v.newOperation(OpStat, name)
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		op.FileInfo, err = AsContextFS(op.MountPoint.fS).StatContext(ctx, op.Unrooted)
+		return err
+	})
+	if err != nil {
+		return v.statMountDir(op, err)
 	}
-	return fsys.Stat(unrooted)
+	return op.FileInfo, nil
 }
 
 func (v *Vfs) Name() string {
@@ -174,73 +244,194 @@ func (v *Vfs) Name() string {
 }
 
 func (v *Vfs) Chmod(name string, mode os.FileMode) error {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return syscall.ENOENT
-	}
-	return fsys.Chmod(unrooted, mode)
+	return v.ChmodContext(context.Background(), name, mode)
+}
+
+func (v *Vfs) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	op := v.newOperation(OpChmod, name)
+	op.Perm = mode
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return AsContextFS(op.MountPoint.fS).ChmodContext(ctx, op.Unrooted, op.Perm)
+	})
 }
 
 func (v *Vfs) Chown(name string, uid, gid int) error {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return syscall.ENOENT
-	}
-	return fsys.Chown(unrooted, uid, gid)
+	return v.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (v *Vfs) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	op := v.newOperation(OpChown, name)
+	op.Uid, op.Gid = uid, gid
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return AsContextFS(op.MountPoint.fS).ChownContext(ctx, op.Unrooted, op.Uid, op.Gid)
+	})
 }
 
 func (v *Vfs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return syscall.ENOENT
-	}
-	return fsys.Chtimes(unrooted, atime, mtime)
+	return v.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (v *Vfs) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	op := v.newOperation(OpChtimes, name)
+	op.Atime, op.Mtime = atime, mtime
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return AsContextFS(op.MountPoint.fS).ChtimesContext(ctx, op.Unrooted, op.Atime, op.Mtime)
+	})
 }
 
 func (v *Vfs) PreSignedURL(ctx context.Context, name string, args ...LinkOptions) (*Link, error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return nil, syscall.ENOENT
-	}
-	if fsys, ok := fsys.(Linker); !ok {
-		return nil, ErrNotSupported
-	} else {
-		return fsys.PreSignedURL(ctx, unrooted, args...)
-	}
+	op := v.newOperation(OpPreSignedURL, name)
+	op.LinkOptions = args
+	return v.link(ctx, op, func(ctx context.Context, fsys Linker, op *Operation) (*Link, error) {
+		return fsys.PreSignedURL(ctx, op.Unrooted, op.LinkOptions...)
+	})
 }
 
 func (v *Vfs) PublicUrl(ctx context.Context, name string) (*Link, error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return nil, syscall.ENOENT
+	op := v.newOperation(OpPublicUrl, name)
+	return v.link(ctx, op, func(ctx context.Context, fsys Linker, op *Operation) (*Link, error) {
+		return fsys.PublicUrl(ctx, op.Unrooted)
+	})
+}
+
+func (v *Vfs) InternalUrl(ctx context.Context, name string, args ...LinkOptions) (*Link, error) {
+	op := v.newOperation(OpInternalUrl, name)
+	op.LinkOptions = args
+	return v.link(ctx, op, func(ctx context.Context, fsys Linker, op *Operation) (*Link, error) {
+		return fsys.InternalUrl(ctx, op.Unrooted, op.LinkOptions...)
+	})
+}
+
+// link invokes a Linker operation
+func (v *Vfs) link(ctx context.Context, op *Operation, link func(ctx context.Context, fsys Linker, op *Operation) (*Link, error)) (*Link, error) {
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		if fsys, ok := op.MountPoint.fS.(Linker); !ok {
+			return ErrNotSupported
+		} else {
+			op.Link, err = link(ctx, fsys, op)
+			return err
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	if fsys, ok := fsys.(Linker); !ok {
-		return nil, ErrNotSupported
-	} else {
-		return fsys.PublicUrl(ctx, unrooted)
+	return op.Link, nil
+}
+
+// Copy copies src to dest within the FS of a mount point implementing Copier,
+// ErrNotSupported is returned otherwise
+func (v *Vfs) Copy(ctx context.Context, src, dest string) error {
+	op := v.newOperation(OpCopy, src)
+	v.resolveNewPath(op, dest)
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		fsys, ok := op.MountPoint.fS.(Copier)
+		if !ok || op.MountPoint.fS != op.NewMountPoint.fS {
+			return ErrNotSupported
+		}
+		return fsys.Copy(ctx, op.Unrooted, op.NewUnrooted)
+	})
+}
+
+// ListPage lists the FS of the mount point of the prefix if it implements Lister.
+// ErrNotSupported is returned otherwise or if mount points are below the prefix.
+func (v *Vfs) ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *ListOptions) ([]*fs.FileInfo, []byte, error) {
+	if opts == nil {
+		opts = &ListOptions{}
 	}
+	dir, base := "/", opts.Prefix
+	if i := strings.LastIndex(opts.Prefix, "/"); i >= 0 {
+		dir, base = opts.Prefix[:i+1], opts.Prefix[i+1:]
+	}
+	op := v.newOperation(OpListPage, dir)
+	op.PageToken, op.PageSize = pageToken, pageSize
+	if op.MountPoint != nil {
+		prefix := path.Join("/", op.Unrooted)
+		if prefix != "/" {
+			prefix += "/"
+		}
+		op.ListOptions = &ListOptions{Prefix: prefix + base, Delimiter: opts.Delimiter}
+	}
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		fsys, ok := op.MountPoint.fS.(Lister)
+		if !ok || len(v.childMounts(op.Path)) > 0 {
+			return ErrNotSupported
+		}
+		op.FileInfos, op.NextPageToken, err = fsys.ListPage(ctx, op.PageToken, op.PageSize, op.ListOptions)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return op.FileInfos, op.NextPageToken, nil
 }
 
-func (v *Vfs) InternalUrl(ctx context.Context, name string, args ...LinkOptions) (*Link, error) {
-	v.mtab.mu.RLock()
-	_, fsys, unrooted := v.findMountPoint(name)
-	v.mtab.mu.RUnlock()
-	if fsys == nil {
-		return nil, syscall.ENOENT
+func (v *Vfs) GetMetadata(ctx context.Context, name string) (*Metadata, error) {
+	op := v.newOperation(OpGetMetadata, name)
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		fsys, ok := op.MountPoint.fS.(Metadater)
+		if !ok {
+			return ErrNotSupported
+		}
+		op.Metadata, err = fsys.GetMetadata(ctx, op.Unrooted)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if fsys, ok := fsys.(Linker); !ok {
-		return nil, ErrNotSupported
-	} else {
-		return fsys.InternalUrl(ctx, unrooted, args...)
+	return op.Metadata, nil
+}
+
+func (v *Vfs) SetMetadata(ctx context.Context, name string, md *Metadata) error {
+	op := v.newOperation(OpSetMetadata, name)
+	op.Metadata = md
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		fsys, ok := op.MountPoint.fS.(Metadater)
+		if !ok {
+			return ErrNotSupported
+		}
+		return fsys.SetMetadata(ctx, op.Unrooted, op.Metadata)
+	})
+}
+
+func (v *Vfs) ListVersions(ctx context.Context, name string) ([]*Version, error) {
+	op := v.newOperation(OpListVersions, name)
+	err := v.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		fsys, ok := op.MountPoint.fS.(Versioner)
+		if !ok {
+			return ErrNotSupported
+		}
+		op.Versions, err = fsys.ListVersions(ctx, op.Unrooted)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return op.Versions, nil
+}
+
+func (v *Vfs) OpenVersion(ctx context.Context, name, id string) (File, error) {
+	op, err := v.newOpenOperation(OpOpenVersion, name)
+	if err != nil {
+		return nil, err
+	}
+	op.VersionID = id
+	return v.open(ctx, op, func(ctx context.Context, op *Operation) (File, error) {
+		fsys, ok := op.MountPoint.fS.(Versioner)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return fsys.OpenVersion(ctx, op.Unrooted, op.VersionID)
+	})
+}
+
+func (v *Vfs) RestoreVersion(ctx context.Context, name, id string) error {
+	op := v.newOperation(OpRestoreVersion, name)
+	op.VersionID = id
+	return v.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		fsys, ok := op.MountPoint.fS.(Versioner)
+		if !ok {
+			return ErrNotSupported
+		}
+		return fsys.RestoreVersion(ctx, op.Unrooted, op.VersionID)
+	})
 }
@@ -1,9 +1,11 @@
 package vfs
 
 import (
+	"context"
 	"embed"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
 	"syscall"
 	"testing"
 )
@@ -55,6 +57,18 @@ func TestMount(t *testing.T) {
 		assert.Equal(t, memFsA, mfs)
 		assert.Equal(t, "", unroot)
 
+		mp, _, unroot := vfs.findMountPoint("/a/b/no/no")
+		assert.Equal(t, "/a/b", mp.GetPrefix())
+		assert.Equal(t, "no/no", unroot)
+
+		// internal node /b/c should fall back to the root mount
+		mp, _, unroot = vfs.findMountPoint("/b/c/e")
+		assert.Equal(t, "/", mp.GetPrefix())
+		assert.Equal(t, "b/c/e", unroot)
+
+		mp, unroot = vfs.Lookup("/a/b/no/no")
+		assert.Equal(t, "/a/b", mp.GetPrefix())
+		assert.Equal(t, "no/no", unroot)
 	}
 
 	{
@@ -132,3 +146,86 @@ func TestUnmount(t *testing.T) {
 
 	assert.ErrorIs(t, vfs.Unmount("/non-exist", nil), syscall.ENOENT)
 }
+
+func TestMountDirs(t *testing.T) {
+	vfs := New()
+	assert.NoError(t, vfs.Mount("/a", afero.NewMemMapFs()))
+	assert.NoError(t, vfs.Mount("/a/b/c", afero.NewMemMapFs()))
+	assert.NoError(t, vfs.Mount("/d/e", afero.NewMemMapFs()))
+	assert.NoError(t, afero.WriteFile(vfs, "/a/1.txt", []byte("1"), 0644))
+
+	// parents of mount points are directories
+	info, err := vfs.Stat("/")
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = vfs.Stat("/a/b")
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	_, err = vfs.Stat("/x")
+	assert.ErrorIs(t, err, syscall.ENOENT)
+
+	names := func(name string) []string {
+		f, err := vfs.Open(name)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		assert.NoError(t, err)
+		return names
+	}
+	assert.Equal(t, []string{"a", "d"}, names("/"))
+	assert.Equal(t, []string{"1.txt", "b"}, names("/a"))
+	assert.Equal(t, []string{"c"}, names("/a/b"))
+	assert.Empty(t, names("/d/e"))
+}
+
+// capFS records the names passed to Copier, Lister and Metadater
+type capFS struct {
+	afero.Fs
+	calls []string
+}
+
+func (c *capFS) Copy(ctx context.Context, src, dest string) error {
+	c.calls = append(c.calls, "copy "+src+" "+dest)
+	return nil
+}
+
+func (c *capFS) ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *ListOptions) ([]*fs.FileInfo, []byte, error) {
+	c.calls = append(c.calls, "list "+opts.Prefix)
+	return nil, nil, nil
+}
+
+func (c *capFS) GetMetadata(ctx context.Context, name string) (*Metadata, error) {
+	c.calls = append(c.calls, "get "+name)
+	return &Metadata{}, nil
+}
+
+func (c *capFS) SetMetadata(ctx context.Context, name string, md *Metadata) error {
+	c.calls = append(c.calls, "set "+name)
+	return nil
+}
+
+func TestCapabilities(t *testing.T) {
+	ctx := context.Background()
+	v := New()
+	c := &capFS{Fs: afero.NewMemMapFs()}
+	assert.NoError(t, v.Mount("/c", c))
+	assert.NoError(t, v.Mount("/c/sub", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/mem", afero.NewMemMapFs()))
+
+	assert.NoError(t, v.Copy(ctx, "/c/1.txt", "/c/dir/2.txt"))
+	_, _, err := v.ListPage(ctx, nil, 10, &ListOptions{Prefix: "/c/dir/pre", Delimiter: "/"})
+	assert.NoError(t, err)
+	_, err = v.GetMetadata(ctx, "/c/1.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, v.SetMetadata(ctx, "/c/1.txt", &Metadata{}))
+	assert.Equal(t, []string{"copy 1.txt dir/2.txt", "list /dir/pre", "get 1.txt", "set 1.txt"}, c.calls)
+
+	// across mount points, listing mount points or not supported by the FS
+	assert.ErrorIs(t, v.Copy(ctx, "/c/1.txt", "/mem/1.txt"), ErrNotSupported)
+	_, _, err = v.ListPage(ctx, nil, 10, &ListOptions{Prefix: "/c/", Delimiter: "/"})
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = v.GetMetadata(ctx, "/mem/1.txt")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
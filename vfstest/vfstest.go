@@ -0,0 +1,487 @@
+// Package vfstest verifies that FS backends behave like afero filesystems
+//
+//	func TestMyFS(t *testing.T) {
+//		vfstest.TestFS(t, func(t *testing.T) vfs.FS {
+//			return NewMyFS(t.TempDir())
+//		}, vfstest.Skip("Chown"))
+//	}
+package vfstest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+type config struct {
+	skip map[string]bool
+}
+
+type Option func(c *config)
+
+// Skip disables subtests the backend does not support, e.g. "Append" for object stores
+func Skip(names ...string) Option {
+	return func(c *config) {
+		for _, name := range names {
+			c.skip[name] = true
+		}
+	}
+}
+
+// TestFS runs the conformance suite. newFS returns an empty FS for every
+// subtest. Linker, Lister, Copier and Mover contracts are verified when the FS
+// implements them, a FS which implements them but returns ErrNotSupported,
+// e.g. a wrapper of a FS without links, opts out with Skip.
+func TestFS(t *testing.T, newFS func(t *testing.T) vfs.FS, opts ...Option) {
+	c := &config{skip: map[string]bool{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, tc := range []struct {
+		name string
+		fn   func(t *testing.T, fsys vfs.FS)
+	}{
+		{"CreateOpen", testCreateOpen},
+		{"Exclusive", testExclusive},
+		{"Append", testAppend},
+		{"Truncate", testTruncate},
+		{"Seek", testSeek},
+		{"Mkdir", testMkdir},
+		{"Readdir", testReaddir},
+		{"Rename", testRename},
+		{"Remove", testRemove},
+		{"Chmod", testChmod},
+		{"Chtimes", testChtimes},
+		{"Linker", testLinker},
+		{"Lister", testLister},
+		{"Copier", testCopier},
+		{"Mover", testMover},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if c.skip[tc.name] {
+				t.Skip("skipped by option")
+			}
+			tc.fn(t, newFS(t))
+		})
+	}
+}
+
+func writeFile(t *testing.T, fsys vfs.FS, name string, data string) {
+	t.Helper()
+	if err := afero.WriteFile(fsys, name, []byte(data), 0644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func checkContent(t *testing.T, fsys vfs.FS, name string, want string) {
+	t.Helper()
+	b, err := afero.ReadFile(fsys, name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	if string(b) != want {
+		t.Errorf("content of %s is %q, want %q", name, b, want)
+	}
+}
+
+func checkNotExist(t *testing.T, fsys vfs.FS, name string) {
+	t.Helper()
+	if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat %s: got %v, want fs.ErrNotExist", name, err)
+	}
+}
+
+func testCreateOpen(t *testing.T, fsys vfs.FS) {
+	f, err := fsys.Create("/a.txt")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	checkContent(t, fsys, "/a.txt", "hello")
+
+	info, err := fsys.Stat("/a.txt")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Name() != "a.txt" || info.Size() != 5 || info.IsDir() {
+		t.Errorf("stat: got name %q size %d dir %v", info.Name(), info.Size(), info.IsDir())
+	}
+
+	// create truncates existing files
+	writeFile(t, fsys, "/a.txt", "hi")
+	checkContent(t, fsys, "/a.txt", "hi")
+
+	if _, err := fsys.Open("/none.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open missing file: got %v, want fs.ErrNotExist", err)
+	}
+	if _, err := fsys.OpenFile("/none.txt", os.O_RDWR, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open missing file without O_CREATE: got %v, want fs.ErrNotExist", err)
+	}
+	checkNotExist(t, fsys, "/none.txt")
+}
+
+func testExclusive(t *testing.T, fsys vfs.FS) {
+	f, err := fsys.OpenFile("/a.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("create exclusive: %v", err)
+	}
+	f.Close()
+	if _, err := fsys.OpenFile("/a.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("create exclusive existing file: got %v, want fs.ErrExist", err)
+	}
+}
+
+func testAppend(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "hello")
+	f, err := fsys.OpenFile("/a.txt", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open append: %v", err)
+	}
+	if _, err := f.WriteString(" world"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	checkContent(t, fsys, "/a.txt", "hello world")
+}
+
+func testTruncate(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "hello")
+	f, err := fsys.OpenFile("/a.txt", os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open truncate: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	checkContent(t, fsys, "/a.txt", "")
+
+	writeFile(t, fsys, "/b.txt", "hello")
+	f, err = fsys.OpenFile("/b.txt", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := f.Truncate(2); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	checkContent(t, fsys, "/b.txt", "he")
+}
+
+func testSeek(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "0123456789")
+	f, err := fsys.Open("/a.txt")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, 3)
+	for _, tc := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		want   string
+	}{
+		{2, io.SeekStart, 2, "234"},
+		{1, io.SeekCurrent, 6, "678"},
+		{-2, io.SeekEnd, 8, "89"},
+	} {
+		pos, err := f.Seek(tc.offset, tc.whence)
+		if err != nil {
+			t.Fatalf("seek %d %d: %v", tc.offset, tc.whence, err)
+		}
+		if pos != tc.pos {
+			t.Errorf("seek %d %d: got position %d, want %d", tc.offset, tc.whence, pos, tc.pos)
+		}
+		n, err := io.ReadFull(f, buf[:len(tc.want)])
+		if err != nil || string(buf[:n]) != tc.want {
+			t.Errorf("read after seek %d %d: got %q %v, want %q", tc.offset, tc.whence, buf[:n], err, tc.want)
+		}
+	}
+	if n, err := f.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("read at end: got %d %v, want io.EOF", n, err)
+	}
+	n, err := f.ReadAt(buf, 4)
+	if err != nil || string(buf[:n]) != "456" {
+		t.Errorf("read at 4: got %q %v, want %q", buf[:n], err, "456")
+	}
+}
+
+func testMkdir(t *testing.T, fsys vfs.FS) {
+	if err := fsys.Mkdir("/a", 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := fsys.Mkdir("/a", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("mkdir existing: got %v, want fs.ErrExist", err)
+	}
+	if err := fsys.MkdirAll("/a/b/c", 0755); err != nil {
+		t.Fatalf("mkdir all: %v", err)
+	}
+	if err := fsys.MkdirAll("/a/b/c", 0755); err != nil {
+		t.Errorf("mkdir all existing: %v", err)
+	}
+	for _, name := range []string{"/a", "/a/b", "/a/b/c"} {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Errorf("stat %s: %v", name, err)
+		} else if !info.IsDir() {
+			t.Errorf("stat %s: not a directory", name)
+		}
+	}
+}
+
+func testReaddir(t *testing.T, fsys vfs.FS) {
+	want := []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt", "d"}
+	if err := fsys.MkdirAll("/dir/d", 0755); err != nil {
+		t.Fatalf("mkdir all: %v", err)
+	}
+	for _, name := range want[:5] {
+		writeFile(t, fsys, "/dir/"+name, name)
+	}
+	writeFile(t, fsys, "/dir/d/nested.txt", "nested")
+
+	f, err := fsys.Open("/dir")
+	if err != nil {
+		t.Fatalf("open dir: %v", err)
+	}
+	var names []string
+	for i := 0; ; i++ {
+		infos, err := f.Readdir(4)
+		if err == io.EOF {
+			if len(infos) != 0 {
+				t.Errorf("readdir: got %d entries with io.EOF", len(infos))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("readdir: %v", err)
+		}
+		if len(infos) == 0 || len(infos) > 4 {
+			t.Fatalf("readdir(4): got %d entries", len(infos))
+		}
+		for _, info := range infos {
+			names = append(names, info.Name())
+			if isDir := info.Name() == "d"; info.IsDir() != isDir {
+				t.Errorf("readdir: %s is dir %v, want %v", info.Name(), info.IsDir(), isDir)
+			}
+		}
+		if i > len(want) {
+			t.Fatal("readdir does not return io.EOF")
+		}
+	}
+	f.Close()
+	sort.Strings(names)
+	if !equal(names, want) {
+		t.Errorf("readdir: got %v, want %v", names, want)
+	}
+
+	f, err = fsys.Open("/dir")
+	if err != nil {
+		t.Fatalf("open dir: %v", err)
+	}
+	defer f.Close()
+	names, err = f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("readdirnames: %v", err)
+	}
+	sort.Strings(names)
+	if !equal(names, want) {
+		t.Errorf("readdirnames: got %v, want %v", names, want)
+	}
+}
+
+func testRename(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "a")
+	if err := fsys.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	checkNotExist(t, fsys, "/a.txt")
+	checkContent(t, fsys, "/b.txt", "a")
+
+	// over existing
+	writeFile(t, fsys, "/c.txt", "c")
+	if err := fsys.Rename("/c.txt", "/b.txt"); err != nil {
+		t.Fatalf("rename over existing: %v", err)
+	}
+	checkNotExist(t, fsys, "/c.txt")
+	checkContent(t, fsys, "/b.txt", "c")
+
+	if err := fsys.Rename("/none.txt", "/d.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("rename missing file: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func testRemove(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "a")
+	if err := fsys.Remove("/a.txt"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	checkNotExist(t, fsys, "/a.txt")
+	if err := fsys.Remove("/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("remove missing file: got %v, want fs.ErrNotExist", err)
+	}
+
+	if err := fsys.MkdirAll("/dir/sub", 0755); err != nil {
+		t.Fatalf("mkdir all: %v", err)
+	}
+	writeFile(t, fsys, "/dir/sub/a.txt", "a")
+	if err := fsys.RemoveAll("/dir"); err != nil {
+		t.Fatalf("remove all: %v", err)
+	}
+	checkNotExist(t, fsys, "/dir")
+	checkNotExist(t, fsys, "/dir/sub/a.txt")
+	if err := fsys.RemoveAll("/dir"); err != nil {
+		t.Errorf("remove all missing directory: %v", err)
+	}
+}
+
+func testChmod(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "a")
+	if err := fsys.Chmod("/a.txt", 0600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	info, err := fsys.Stat("/a.txt")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("chmod: got mode %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+	if err := fsys.Chmod("/none.txt", 0600); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("chmod missing file: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func testChtimes(t *testing.T, fsys vfs.FS) {
+	writeFile(t, fsys, "/a.txt", "a")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := fsys.Chtimes("/a.txt", mtime, mtime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	info, err := fsys.Stat("/a.txt")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("chtimes: got mtime %v, want %v", info.ModTime(), mtime)
+	}
+}
+
+func testLinker(t *testing.T, fsys vfs.FS) {
+	l, ok := fsys.(vfs.Linker)
+	if !ok {
+		t.Skip("not a vfs.Linker")
+	}
+	ctx := context.Background()
+	writeFile(t, fsys, "/a.txt", "a")
+	expire := time.Hour
+	for name, fn := range map[string]func() (*vfs.Link, error){
+		"PreSignedURL": func() (*vfs.Link, error) { return l.PreSignedURL(ctx, "/a.txt", vfs.LinkOptions{Expire: &expire}) },
+		"PublicUrl":    func() (*vfs.Link, error) { return l.PublicUrl(ctx, "/a.txt") },
+		"InternalUrl":  func() (*vfs.Link, error) { return l.InternalUrl(ctx, "/a.txt") },
+	} {
+		link, err := fn()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if link == nil || link.URL == "" {
+			t.Errorf("%s: empty link", name)
+		}
+	}
+}
+
+func testLister(t *testing.T, fsys vfs.FS) {
+	l, ok := fsys.(vfs.Lister)
+	if !ok {
+		t.Skip("not a vfs.Lister")
+	}
+	want := []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"}
+	if err := fsys.MkdirAll("/dir", 0755); err != nil {
+		t.Fatalf("mkdir all: %v", err)
+	}
+	for _, name := range want {
+		writeFile(t, fsys, "/dir/"+name, name)
+	}
+	writeFile(t, fsys, "/other.txt", "other")
+
+	var names []string
+	var token []byte
+	for i := 0; ; i++ {
+		infos, next, err := l.ListPage(context.Background(), token, 2, &vfs.ListOptions{Prefix: "/dir/", Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("list page: %v", err)
+		}
+		if len(infos) > 2 {
+			t.Fatalf("list page of size 2: got %d entries", len(infos))
+		}
+		for _, info := range infos {
+			names = append(names, (*info).Name())
+		}
+		if len(next) == 0 {
+			break
+		}
+		if i > len(want) {
+			t.Fatal("list page does not end")
+		}
+		token = next
+	}
+	sort.Strings(names)
+	if !equal(names, want) {
+		t.Errorf("list: got %v, want %v", names, want)
+	}
+}
+
+func testCopier(t *testing.T, fsys vfs.FS) {
+	c, ok := fsys.(vfs.Copier)
+	if !ok {
+		t.Skip("not a vfs.Copier")
+	}
+	writeFile(t, fsys, "/a.txt", "a")
+	err := c.Copy(context.Background(), "/a.txt", "/b.txt")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkContent(t, fsys, "/a.txt", "a")
+	checkContent(t, fsys, "/b.txt", "a")
+}
+
+func testMover(t *testing.T, fsys vfs.FS) {
+	m, ok := fsys.(vfs.Mover)
+	if !ok {
+		t.Skip("not a vfs.Mover")
+	}
+	writeFile(t, fsys, "/a.txt", "a")
+	if err := m.Move(context.Background(), "/a.txt", "/b.txt"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	checkNotExist(t, fsys, "/a.txt")
+	checkContent(t, fsys, "/b.txt", "a")
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
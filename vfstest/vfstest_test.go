@@ -0,0 +1,51 @@
+package vfstest
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+)
+
+func TestMemMapFs(t *testing.T) {
+	TestFS(t, func(t *testing.T) vfs.FS {
+		return afero.NewMemMapFs()
+	})
+}
+
+func TestOsFs(t *testing.T) {
+	TestFS(t, func(t *testing.T) vfs.FS {
+		return vfs.NewOsFs(t.TempDir())
+	})
+}
+
+func TestVfs(t *testing.T) {
+	TestFS(t, func(t *testing.T) vfs.FS {
+		v := vfs.New()
+		if err := v.Mount("/", afero.NewMemMapFs()); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}, Skip("Linker", "Lister", "Copier"))
+}
+
+func TestView(t *testing.T) {
+	TestFS(t, func(t *testing.T) vfs.FS {
+		v := vfs.New()
+		if err := v.Mount("/", afero.NewMemMapFs()); err != nil {
+			t.Fatal(err)
+		}
+		view, err := v.View("/root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return view
+	}, Skip("Linker"))
+}
+
+func TestOptLinker(t *testing.T) {
+	TestFS(t, func(t *testing.T) vfs.FS {
+		return vfs.NewOptLinker(afero.NewMemMapFs(), url.URL{Scheme: "http", Host: "public"}, url.URL{Scheme: "http", Host: "internal"}, nil)
+	})
+}
@@ -0,0 +1,215 @@
+package vfs
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Overlay is a private mount layered on top of a View. Prefix is relative to
+// the root of the view.
+type Overlay struct {
+	Prefix string
+	FS     FS
+}
+
+// View is a chroot-like FS of a Vfs subtree. Mounts of the parent Vfs are
+// shared without copying, overlays are only visible inside the view and ".."
+// can not escape the root.
+type View struct {
+	parent  *Vfs
+	root    string
+	overlay *Vfs
+}
+
+// View returns an FS rooted at root with optional private overlays mounted on top of it
+func (v *Vfs) View(root string, overlays ...Overlay) (*View, error) {
+	if root == "" || root[0] != '/' {
+		return nil, &fs.PathError{Op: "view", Path: root, Err: syscall.EINVAL}
+	}
+	w := &View{parent: v, root: path.Clean(root)}
+	if len(overlays) > 0 {
+		w.overlay = New()
+		for _, o := range overlays {
+			if o.FS == w {
+				return nil, &fs.PathError{Op: "view", Path: o.Prefix, Err: ErrRecursive}
+			}
+			if err := w.overlay.Mount(o.Prefix, o.FS); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return w, nil
+}
+
+// GetRoot returns the root of the view in the parent Vfs
+func (w *View) GetRoot() string {
+	return w.root
+}
+
+// resolve returns the Vfs serving name and the path inside it
+func (w *View) resolve(name string) (*Vfs, string) {
+	// cleaning a rooted path drops any leading ".."
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if w.overlay != nil {
+		w.overlay.mtab.mu.RLock()
+		mp, _ := w.overlay.mtab.mounts.Get(name)
+		if mp == nil {
+			mp, _ = w.overlay.mtab.mounts.Get("/")
+		}
+		w.overlay.mtab.mu.RUnlock()
+		if mp != nil {
+			return w.overlay, name
+		}
+	}
+	return w.parent, path.Join(w.root, name)
+}
+
+// IsMountPath reports whether name is a mount point or overlay of the view or a directory leading to one
+func (w *View) IsMountPath(name string) bool {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if w.overlay != nil && w.overlay.IsMountPath(name) {
+		return true
+	}
+	return w.parent.IsMountPath(path.Join(w.root, name))
+}
+
+func (w *View) Create(name string) (File, error) {
+	return w.CreateContext(context.Background(), name)
+}
+
+func (w *View) CreateContext(ctx context.Context, name string) (File, error) {
+	v, p := w.resolve(name)
+	return v.CreateContext(ctx, p)
+}
+
+func (w *View) Mkdir(name string, perm os.FileMode) error {
+	return w.MkdirContext(context.Background(), name, perm)
+}
+
+func (w *View) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	v, p := w.resolve(name)
+	return v.MkdirContext(ctx, p, perm)
+}
+
+func (w *View) MkdirAll(name string, perm os.FileMode) error {
+	return w.MkdirAllContext(context.Background(), name, perm)
+}
+
+func (w *View) MkdirAllContext(ctx context.Context, name string, perm os.FileMode) error {
+	v, p := w.resolve(name)
+	return v.MkdirAllContext(ctx, p, perm)
+}
+
+func (w *View) Open(name string) (File, error) {
+	return w.OpenContext(context.Background(), name)
+}
+
+func (w *View) OpenContext(ctx context.Context, name string) (File, error) {
+	v, p := w.resolve(name)
+	return v.OpenContext(ctx, p)
+}
+
+func (w *View) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return w.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (w *View) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	v, p := w.resolve(name)
+	return v.OpenFileContext(ctx, p, flag, perm)
+}
+
+func (w *View) Remove(name string) error {
+	return w.RemoveContext(context.Background(), name)
+}
+
+func (w *View) RemoveContext(ctx context.Context, name string) error {
+	v, p := w.resolve(name)
+	return v.RemoveContext(ctx, p)
+}
+
+func (w *View) RemoveAll(name string) error {
+	return w.RemoveAllContext(context.Background(), name)
+}
+
+func (w *View) RemoveAllContext(ctx context.Context, name string) error {
+	v, p := w.resolve(name)
+	return v.RemoveAllContext(ctx, p)
+}
+
+func (w *View) Rename(oldname, newname string) error {
+	return w.RenameContext(context.Background(), oldname, newname)
+}
+
+func (w *View) RenameContext(ctx context.Context, oldname, newname string) error {
+	oldv, oldp := w.resolve(oldname)
+	newv, newp := w.resolve(newname)
+	if oldv != newv {
+		return syscall.ENOTSUP
+	}
+	return oldv.RenameContext(ctx, oldp, newp)
+}
+
+func (w *View) Stat(name string) (os.FileInfo, error) {
+	return w.StatContext(context.Background(), name)
+}
+
+func (w *View) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	v, p := w.resolve(name)
+	return v.StatContext(ctx, p)
+}
+
+func (w *View) Name() string {
+	return Name
+}
+
+func (w *View) Chmod(name string, mode os.FileMode) error {
+	return w.ChmodContext(context.Background(), name, mode)
+}
+
+func (w *View) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	v, p := w.resolve(name)
+	return v.ChmodContext(ctx, p, mode)
+}
+
+func (w *View) Chown(name string, uid, gid int) error {
+	return w.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (w *View) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	v, p := w.resolve(name)
+	return v.ChownContext(ctx, p, uid, gid)
+}
+
+func (w *View) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return w.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (w *View) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	v, p := w.resolve(name)
+	return v.ChtimesContext(ctx, p, atime, mtime)
+}
+
+func (w *View) PreSignedURL(ctx context.Context, name string, args ...LinkOptions) (*Link, error) {
+	v, p := w.resolve(name)
+	return v.PreSignedURL(ctx, p, args...)
+}
+
+func (w *View) PublicUrl(ctx context.Context, name string) (*Link, error) {
+	v, p := w.resolve(name)
+	return v.PublicUrl(ctx, p)
+}
+
+func (w *View) InternalUrl(ctx context.Context, name string, args ...LinkOptions) (*Link, error) {
+	v, p := w.resolve(name)
+	return v.InternalUrl(ctx, p, args...)
+}
+
+var (
+	_ Blob      = (*View)(nil)
+	_ ContextFS = (*View)(nil)
+)
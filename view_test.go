@@ -0,0 +1,61 @@
+package vfs
+
+import (
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	v := New()
+	root := afero.NewMemMapFs()
+	shared := afero.NewMemMapFs()
+	assert.NoError(t, v.Mount("/", root))
+	assert.NoError(t, v.Mount("/tenants/a/shared", shared))
+
+	_, err := v.View("tenants")
+	assert.Error(t, err)
+
+	private := afero.NewMemMapFs()
+	view, err := v.View("/tenants/a", Overlay{Prefix: "/private", FS: private})
+	assert.NoError(t, err)
+
+	// files created through the view land in the parent subtree
+	f, err := view.Create("/1.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	exist, err := afero.Exists(root, "tenants/a/1.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	// parent mounts are shared
+	assert.NoError(t, afero.WriteFile(view, "/shared/2.txt", []byte("2"), 0644))
+	exist, err = afero.Exists(shared, "2.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	// overlays are private to the view
+	assert.NoError(t, afero.WriteFile(view, "/private/3.txt", []byte("3"), 0644))
+	exist, err = afero.Exists(private, "3.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+	exist, err = afero.Exists(v, "/tenants/a/private/3.txt")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	// .. can not escape the root
+	assert.NoError(t, afero.WriteFile(root, "secret.txt", []byte("secret"), 0644))
+	_, err = view.Stat("../../secret.txt")
+	assert.Error(t, err)
+	assert.NoError(t, afero.WriteFile(view, "../../4.txt", []byte("4"), 0644))
+	exist, err = afero.Exists(root, "tenants/a/4.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	assert.ErrorIs(t, view.Rename("/1.txt", "/private/1.txt"), syscall.ENOTSUP)
+	assert.NoError(t, view.Rename("/1.txt", "/5.txt"))
+	exist, err = afero.Exists(root, "tenants/a/5.txt")
+	assert.NoError(t, err)
+	assert.True(t, exist)
+}
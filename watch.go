@@ -0,0 +1,334 @@
+package vfs
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// EventOp describes a set of file operations.
+type EventOp uint32
+
+const (
+	EventCreate EventOp = 1 << iota
+	EventWrite
+	EventRemove
+	EventRename
+	EventChmod
+	EventMount
+	EventUnmount
+	// EventOverflow precedes the next event delivered after events were dropped
+	EventOverflow
+	// EventError reports the failure of the Notifier of a mount point, Err is set
+	EventError
+)
+
+func (op EventOp) String() string {
+	var names []string
+	for _, n := range []struct {
+		op   EventOp
+		name string
+	}{
+		{EventCreate, "CREATE"},
+		{EventWrite, "WRITE"},
+		{EventRemove, "REMOVE"},
+		{EventRename, "RENAME"},
+		{EventChmod, "CHMOD"},
+		{EventMount, "MOUNT"},
+		{EventUnmount, "UNMOUNT"},
+		{EventOverflow, "OVERFLOW"},
+		{EventError, "ERROR"},
+	} {
+		if op&n.op != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Event represents a change of the Vfs
+type Event struct {
+	Op EventOp
+	// Path is the full Vfs path, or the prefix for EventMount and EventUnmount
+	Path string
+	// OldPath is the previous path of EventRename
+	OldPath string
+	// Mount is the prefix of the mount point where the change happened
+	Mount string
+	// Err is the error of EventError
+	Err error
+}
+
+// Notifier is implemented by backends which can report changes made outside the Vfs.
+// Notify blocks and calls fn until ctx is done, paths of events are relative to the backend root.
+// While it runs, changes made through the Vfs are reported by the Notifier
+// only, and an error it returns is delivered as EventError.
+type Notifier interface {
+	Notify(ctx context.Context, fn func(Event)) error
+}
+
+// DefaultPollInterval is the interval of polling backends without native notification
+const DefaultPollInterval = 10 * time.Second
+
+// Watcher delivers events of a Vfs subtree. Operations do not wait for
+// events to be read, events are dropped while the channel is full.
+type Watcher struct {
+	v         *Vfs
+	prefix    string
+	recursive bool
+	events    chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex // guards overflow and dropped
+	overflow  bool
+	dropped   uint64
+}
+
+// Watch returns a Watcher for changes under prefix. Events of operations
+// performed through the Vfs, backend Notifier and mount table are delivered.
+// If recursive is false, only prefix and its direct children are watched.
+func (v *Vfs) Watch(prefix string, recursive bool) (*Watcher, error) {
+	if prefix == "" || prefix[0] != '/' {
+		return nil, &fs.PathError{Op: "watch", Path: prefix, Err: syscall.EINVAL}
+	}
+	w := &Watcher{
+		v:         v,
+		prefix:    path.Clean(prefix),
+		recursive: recursive,
+		events:    make(chan Event, 64),
+		done:      make(chan struct{}),
+	}
+	v.hub.mu.Lock()
+	defer v.hub.mu.Unlock()
+	if v.hub.watchers == nil {
+		v.hub.watchers = map[*Watcher]struct{}{}
+	}
+	v.hub.watchers[w] = struct{}{}
+	if len(v.hub.watchers) == 1 {
+		// first watcher, start notifiers of backends
+		for _, mp := range v.Mounts() {
+			v.hub.start(v, mp)
+		}
+	}
+	return w, nil
+}
+
+// Events returns the channel of events
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Dropped returns the number of events dropped because the channel was full
+func (w *Watcher) Dropped() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+// send delivers e without blocking, an EventOverflow is delivered first if
+// events were dropped since the last delivered event
+func (w *Watcher) send(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	if w.overflow {
+		select {
+		case w.events <- Event{Op: EventOverflow, Path: w.prefix}:
+			w.overflow = false
+		default:
+			w.dropped++
+			return
+		}
+	}
+	select {
+	case w.events <- e:
+	default:
+		w.overflow = true
+		w.dropped++
+	}
+}
+
+// Close stops delivering events
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.v.hub.mu.Lock()
+		delete(w.v.hub.watchers, w)
+		if len(w.v.hub.watchers) == 0 {
+			// last watcher, stop notifiers of backends
+			for mp := range w.v.hub.cancels {
+				w.v.hub.stop(mp)
+			}
+		}
+		w.v.hub.mu.Unlock()
+	})
+	return nil
+}
+
+func (w *Watcher) match(e Event) bool {
+	if e.Op&(EventMount|EventUnmount|EventError) != 0 && within(w.prefix, e.Path) {
+		// mount changes and failures affect watchers under the mount point
+		return true
+	}
+	return w.matchPath(e.Path) || (e.OldPath != "" && w.matchPath(e.OldPath))
+}
+
+func (w *Watcher) matchPath(p string) bool {
+	if w.recursive {
+		return within(p, w.prefix)
+	}
+	return p == w.prefix || path.Dir(p) == w.prefix
+}
+
+// within reports whether p equals or is under dir
+func within(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+type watchHub struct {
+	mu       sync.RWMutex
+	watchers map[*Watcher]struct{}
+	cancels  map[*MountPoint]context.CancelFunc // running backend notifiers
+}
+
+// start runs the notifier of mp if any. hub.mu must be held.
+func (h *watchHub) start(v *Vfs, mp *MountPoint) {
+	n, ok := mp.fS.(Notifier)
+	if !ok || len(h.watchers) == 0 {
+		return
+	}
+	if _, ok := h.cancels[mp]; ok {
+		return
+	}
+	if h.cancels == nil {
+		h.cancels = map[*MountPoint]context.CancelFunc{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancels[mp] = cancel
+	go func() {
+		err := n.Notify(ctx, func(e Event) {
+			e.Path = path.Join(mp.prefix, e.Path)
+			if e.OldPath != "" {
+				e.OldPath = path.Join(mp.prefix, e.OldPath)
+			}
+			e.Mount = mp.prefix
+			v.notify(e)
+		})
+		if err != nil && ctx.Err() == nil {
+			v.notify(Event{Op: EventError, Path: mp.prefix, Mount: mp.prefix, Err: err})
+		}
+	}()
+}
+
+// stop cancels the notifier of mp. hub.mu must be held.
+func (h *watchHub) stop(mp *MountPoint) {
+	if cancel, ok := h.cancels[mp]; ok {
+		cancel()
+		delete(h.cancels, mp)
+	}
+}
+
+// watching reports whether there is any watcher
+func (v *Vfs) watching() bool {
+	v.hub.mu.RLock()
+	defer v.hub.mu.RUnlock()
+	return len(v.hub.watchers) > 0
+}
+
+// notify delivers e to matched watchers
+func (v *Vfs) notify(e Event) {
+	v.hub.mu.RLock()
+	var matched []*Watcher
+	for w := range v.hub.watchers {
+		if w.match(e) {
+			matched = append(matched, w)
+		}
+	}
+	v.hub.mu.RUnlock()
+	for _, w := range matched {
+		w.send(e)
+	}
+}
+
+// notifyPath delivers an event of op on the mount point mp
+func (v *Vfs) notifyPath(op EventOp, mp *MountPoint, unrooted string) {
+	if v.reported(mp) {
+		return
+	}
+	v.notify(Event{Op: op, Path: path.Join(mp.prefix, unrooted), Mount: mp.prefix})
+}
+
+// reported reports whether the Notifier of mp is running, which reports
+// changes made through the Vfs as well
+func (v *Vfs) reported(mp *MountPoint) bool {
+	v.hub.mu.RLock()
+	defer v.hub.mu.RUnlock()
+	_, ok := v.hub.cancels[mp]
+	return ok
+}
+
+type pollState struct {
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// Poll walks fsys every interval and calls fn with the changes until ctx is done.
+// It can be used by backends without native notification to implement Notifier.
+func Poll(ctx context.Context, fsys FS, interval time.Duration, fn func(Event)) error {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	scan := func() map[string]pollState {
+		res := map[string]pollState{}
+		afero.Walk(fsys, "", func(p string, info os.FileInfo, err error) error {
+			if err != nil || info == nil || p == "" {
+				return nil
+			}
+			res[p] = pollState{size: info.Size(), mode: info.Mode(), modTime: info.ModTime()}
+			return nil
+		})
+		return res
+	}
+	prev := scan()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		cur := scan()
+		for p, s := range cur {
+			old, ok := prev[p]
+			switch {
+			case !ok:
+				fn(Event{Op: EventCreate, Path: p})
+			case old.size != s.size || !old.modTime.Equal(s.modTime):
+				if !s.mode.IsDir() {
+					fn(Event{Op: EventWrite, Path: p})
+				}
+			case old.mode != s.mode:
+				fn(Event{Op: EventChmod, Path: p})
+			}
+		}
+		for p := range prev {
+			if _, ok := cur[p]; !ok {
+				fn(Event{Op: EventRemove, Path: p})
+			}
+		}
+		prev = cur
+	}
+}
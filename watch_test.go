@@ -0,0 +1,185 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, w *Watcher) Event {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+		return Event{}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/a", afero.NewMemMapFs()))
+
+	_, err := v.Watch("a", true)
+	assert.Error(t, err)
+
+	w, err := v.Watch("/a", true)
+	assert.NoError(t, err)
+	defer w.Close()
+	flat, err := v.Watch("/a", false)
+	assert.NoError(t, err)
+
+	f, err := v.Create("/a/b/1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, Event{Op: EventCreate, Path: "/a/b/1.txt", Mount: "/a"}, nextEvent(t, w))
+	_, err = f.WriteString("1")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.Equal(t, Event{Op: EventWrite, Path: "/a/b/1.txt", Mount: "/a"}, nextEvent(t, w))
+
+	assert.NoError(t, v.Rename("/a/b/1.txt", "/a/2.txt"))
+	e := Event{Op: EventRename, Path: "/a/2.txt", OldPath: "/a/b/1.txt", Mount: "/a"}
+	assert.Equal(t, e, nextEvent(t, w))
+	// non-recursive watcher only sees the direct child
+	assert.Equal(t, e, nextEvent(t, flat))
+	assert.NoError(t, flat.Close())
+
+	assert.NoError(t, v.Chmod("/a/2.txt", 0600))
+	assert.Equal(t, Event{Op: EventChmod, Path: "/a/2.txt", Mount: "/a"}, nextEvent(t, w))
+	assert.NoError(t, v.Remove("/a/2.txt"))
+	assert.Equal(t, Event{Op: EventRemove, Path: "/a/2.txt", Mount: "/a"}, nextEvent(t, w))
+
+	// changes outside the prefix are ignored
+	assert.NoError(t, afero.WriteFile(v, "/b.txt", []byte("b"), 0644))
+
+	assert.NoError(t, v.Mount("/a/c", afero.NewMemMapFs()))
+	assert.Equal(t, Event{Op: EventMount, Path: "/a/c", Mount: "/a/c"}, nextEvent(t, w))
+	assert.NoError(t, v.Unmount("/a", nil))
+	assert.Equal(t, Event{Op: EventUnmount, Path: "/a", Mount: "/a"}, nextEvent(t, w))
+}
+
+func TestWatchMkdirAll(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	w, err := v.Watch("/", true)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	assert.NoError(t, v.MkdirAll("/a", 0755))
+	assert.Equal(t, Event{Op: EventCreate, Path: "/a", Mount: "/"}, nextEvent(t, w))
+	// existing directories are not created again
+	assert.NoError(t, v.MkdirAll("/a", 0755))
+	assert.NoError(t, v.Remove("/a"))
+	assert.Equal(t, Event{Op: EventRemove, Path: "/a", Mount: "/"}, nextEvent(t, w))
+}
+
+func TestWatchOverflow(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/", afero.NewMemMapFs()))
+	assert.NoError(t, afero.WriteFile(v, "/1.txt", []byte("1"), 0644))
+	stalled, err := v.Watch("/", true)
+	assert.NoError(t, err)
+	defer stalled.Close()
+
+	// a watcher which does not read does not block operations
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, v.Chmod("/1.txt", 0644))
+	}
+	assert.Equal(t, uint64(100-cap(stalled.events)), stalled.Dropped())
+	for i := 0; i < cap(stalled.events); i++ {
+		nextEvent(t, stalled)
+	}
+	assert.NoError(t, v.Chmod("/1.txt", 0600))
+	assert.Equal(t, EventOverflow, nextEvent(t, stalled).Op)
+	assert.Equal(t, Event{Op: EventChmod, Path: "/1.txt", Mount: "/"}, nextEvent(t, stalled))
+}
+
+type pollFs struct {
+	FS
+}
+
+func (p pollFs) Notify(ctx context.Context, fn func(Event)) error {
+	return Poll(ctx, p.FS, 10*time.Millisecond, fn)
+}
+
+func TestWatchNotifier(t *testing.T) {
+	v := New()
+	backend := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(backend, "1.txt", []byte("1"), 0644))
+	assert.NoError(t, v.Mount("/p", pollFs{backend}))
+
+	w, err := v.Watch("/p", true)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	// give the poller a chance to take the first snapshot
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, afero.WriteFile(backend, "2.txt", []byte("2"), 0644))
+	assert.Equal(t, Event{Op: EventCreate, Path: "/p/2.txt", Mount: "/p"}, nextEvent(t, w))
+	assert.NoError(t, backend.Remove("1.txt"))
+	assert.Equal(t, Event{Op: EventRemove, Path: "/p/1.txt", Mount: "/p"}, nextEvent(t, w))
+}
+
+type failingNotifier struct {
+	FS
+}
+
+func (failingNotifier) Notify(ctx context.Context, fn func(Event)) error {
+	return errors.New("poll: access denied")
+}
+
+func TestWatchNotifierError(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/p", failingNotifier{afero.NewMemMapFs()}))
+	w, err := v.Watch("/p/dir", false)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	e := nextEvent(t, w)
+	assert.Equal(t, EventError, e.Op)
+	assert.Equal(t, "/p", e.Mount)
+	assert.EqualError(t, e.Err, "poll: access denied")
+}
+
+func TestWatchNotifierDedup(t *testing.T) {
+	v := New()
+	assert.NoError(t, v.Mount("/p", pollFs{afero.NewMemMapFs()}))
+	w, err := v.Watch("/p", true)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	// reported once, by the notifier
+	assert.NoError(t, afero.WriteFile(v, "/p/1.txt", []byte("1"), 0644))
+	assert.Equal(t, Event{Op: EventCreate, Path: "/p/1.txt", Mount: "/p"}, nextEvent(t, w))
+	select {
+	case e := <-w.Events():
+		t.Fatalf("unexpected event %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchOsFs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify is only available on linux")
+	}
+	dir := t.TempDir()
+	v := New()
+	assert.NoError(t, v.Mount("/os", NewOsFs(dir)))
+	w, err := v.Watch("/os", true)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.txt"), []byte("1"), 0644))
+	assert.Equal(t, Event{Op: EventCreate, Path: "/os/1.txt", Mount: "/os"}, nextEvent(t, w))
+	assert.Equal(t, Event{Op: EventWrite, Path: "/os/1.txt", Mount: "/os"}, nextEvent(t, w))
+}
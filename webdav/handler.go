@@ -0,0 +1,134 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"syscall"
+
+	"github.com/goxiaoy/vfs"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+type handler struct {
+	dav     xwebdav.Handler
+	props   PropStore
+	context func(r *http.Request) context.Context
+}
+
+type Option func(h *handler)
+
+// WithPrefix strips prefix from request paths
+func WithPrefix(prefix string) Option {
+	return func(h *handler) {
+		h.dav.Prefix = prefix
+	}
+}
+
+// WithLockSystem replaces the default in memory lock system
+func WithLockSystem(ls xwebdav.LockSystem) Option {
+	return func(h *handler) {
+		h.dav.LockSystem = ls
+	}
+}
+
+// WithPropStore replaces the default in memory dead property storage
+func WithPropStore(store PropStore) Option {
+	return func(h *handler) {
+		h.props = store
+	}
+}
+
+// WithLogger is called with the error of every request
+func WithLogger(logger func(r *http.Request, err error)) Option {
+	return func(h *handler) {
+		h.dav.Logger = logger
+	}
+}
+
+// WithContext derives the context of operations from requests, e.g. to attach
+// the vfs.Identity of the authenticated user
+func WithContext(fn func(r *http.Request) context.Context) Option {
+	return func(h *handler) {
+		h.context = fn
+	}
+}
+
+// NewHandler serves fsys over WebDAV. Operations denied by the FS, e.g. on a
+// read-only mount point or by a policy, respond with 403 Forbidden.
+func NewHandler(fsys vfs.FS, opts ...Option) http.Handler {
+	h := &handler{props: NewMemPropStore()}
+	h.dav.LockSystem = xwebdav.NewMemLS()
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.dav.FileSystem = NewFileSystem(fsys, h.props)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.context != nil {
+		ctx = h.context(r)
+	}
+	rec := &recorder{}
+	ctx = context.WithValue(ctx, recorderKey{}, rec)
+	h.dav.ServeHTTP(&responseWriter{ResponseWriter: w, rec: rec}, r.WithContext(ctx))
+}
+
+type recorderKey struct{}
+
+// recorder keeps the status of the first error of a request the webdav
+// handler would respond with a misleading status, e.g. 404 for a denied PUT
+type recorder struct {
+	status int
+}
+
+// record notes err in the recorder of ctx and returns it
+func record(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if rec, ok := ctx.Value(recorderKey{}).(*recorder); ok && rec.status == 0 {
+		rec.status = statusOf(err)
+	}
+	return err
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return http.StatusForbidden
+	case errors.Is(err, vfs.ErrNotSupported), errors.Is(err, syscall.ENOTSUP):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, vfs.ErrUnhealthy):
+		return http.StatusServiceUnavailable
+	}
+	return 0
+}
+
+// responseWriter replaces error statuses by the recorded one
+type responseWriter struct {
+	http.ResponseWriter
+	rec       *recorder
+	rewritten bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if code < 400 || w.rec.status == 0 || w.rec.status == code {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.rewritten = true
+	w.ResponseWriter.WriteHeader(w.rec.status)
+	w.ResponseWriter.Write([]byte(xwebdav.StatusText(w.rec.status)))
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.rewritten {
+		// drop the status text of the replaced status
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
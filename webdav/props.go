@@ -0,0 +1,206 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/goxiaoy/vfs"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+// PropStore stores dead properties of resources by path
+type PropStore interface {
+	Get(ctx context.Context, name string) (map[xml.Name]xwebdav.Property, error)
+	// Patch applies all patches or none
+	Patch(ctx context.Context, name string, patches []xwebdav.Proppatch) error
+	// Remove removes the properties of name and its descendants
+	Remove(ctx context.Context, name string) error
+	// Move moves the properties of name and its descendants
+	Move(ctx context.Context, oldName, newName string) error
+}
+
+func apply(props map[xml.Name]xwebdav.Property, patches []xwebdav.Proppatch) map[xml.Name]xwebdav.Property {
+	res := map[xml.Name]xwebdav.Property{}
+	for k, v := range props {
+		res[k] = v
+	}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			if patch.Remove {
+				delete(res, p.XMLName)
+			} else {
+				res[p.XMLName] = p
+			}
+		}
+	}
+	return res
+}
+
+// descendant reports whether name is root or below it
+func descendant(name, root string) bool {
+	return name == root || root == "/" || strings.HasPrefix(name, root+"/")
+}
+
+type memPropStore struct {
+	mu    sync.RWMutex
+	props map[string]map[xml.Name]xwebdav.Property
+}
+
+// NewMemPropStore stores dead properties in memory
+func NewMemPropStore() PropStore {
+	return &memPropStore{props: map[string]map[xml.Name]xwebdav.Property{}}
+}
+
+func (s *memPropStore) Get(ctx context.Context, name string) (map[xml.Name]xwebdav.Property, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return apply(s.props[name], nil), nil
+}
+
+func (s *memPropStore) Patch(ctx context.Context, name string, patches []xwebdav.Proppatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.props[name] = apply(s.props[name], patches)
+	return nil
+}
+
+func (s *memPropStore) Remove(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.props {
+		if descendant(k, name) {
+			delete(s.props, k)
+		}
+	}
+	return nil
+}
+
+func (s *memPropStore) Move(ctx context.Context, oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.props {
+		if descendant(k, oldName) {
+			delete(s.props, k)
+			s.props[newName+strings.TrimPrefix(k, oldName)] = v
+		}
+	}
+	return nil
+}
+
+type fsPropStore struct {
+	fs   vfs.ContextFS
+	root string
+	mu   sync.Mutex
+}
+
+// NewFSPropStore stores dead properties as json files under root of fsys,
+// mirroring the tree of resources: the properties of /a/b are stored in
+// <root>/a/b.props and the ones of its descendants under <root>/a/b/
+func NewFSPropStore(fsys vfs.FS, root string) PropStore {
+	return &fsPropStore{fs: vfs.AsContextFS(fsys), root: path.Clean("/" + root)}
+}
+
+func (s *fsPropStore) file(name string) string {
+	if name == "/" {
+		return path.Join(s.root, ".props")
+	}
+	return path.Join(s.root, name) + ".props"
+}
+
+// property is the json form of webdav.Property
+type property struct {
+	Space    string `json:"space"`
+	Local    string `json:"local"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML []byte `json:"innerXML"`
+}
+
+func (s *fsPropStore) Get(ctx context.Context, name string) (map[xml.Name]xwebdav.Property, error) {
+	f, err := s.fs.OpenContext(ctx, s.file(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[xml.Name]xwebdav.Property{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var list []property
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, err
+	}
+	props := map[xml.Name]xwebdav.Property{}
+	for _, p := range list {
+		n := xml.Name{Space: p.Space, Local: p.Local}
+		props[n] = xwebdav.Property{XMLName: n, Lang: p.Lang, InnerXML: p.InnerXML}
+	}
+	return props, nil
+}
+
+func (s *fsPropStore) Patch(ctx context.Context, name string, patches []xwebdav.Proppatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	props, err := s.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	props = apply(props, patches)
+	file := s.file(name)
+	if len(props) == 0 {
+		return ignoreNotExist(s.fs.RemoveContext(ctx, file))
+	}
+	list := make([]property, 0, len(props))
+	for n, p := range props {
+		list = append(list, property{Space: n.Space, Local: n.Local, Lang: p.Lang, InnerXML: p.InnerXML})
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := s.fs.MkdirAllContext(ctx, path.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := s.fs.OpenFileContext(ctx, file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fsPropStore) Remove(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ignoreNotExist(s.fs.RemoveContext(ctx, s.file(name))); err != nil {
+		return err
+	}
+	return s.fs.RemoveAllContext(ctx, path.Join(s.root, name))
+}
+
+func (s *fsPropStore) Move(ctx context.Context, oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fs.MkdirAllContext(ctx, path.Dir(s.file(newName)), 0755); err != nil {
+		return err
+	}
+	if err := ignoreNotExist(s.fs.RenameContext(ctx, s.file(oldName), s.file(newName))); err != nil {
+		return err
+	}
+	return ignoreNotExist(s.fs.RenameContext(ctx, path.Join(s.root, oldName), path.Join(s.root, newName)))
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
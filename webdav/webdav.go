@@ -0,0 +1,136 @@
+// Package webdav serves a vfs.FS, usually a Vfs with all its mount points, over WebDAV
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/goxiaoy/vfs"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+// FileSystem adapts vfs.FS to webdav.FileSystem. Moves across mount points of
+// a Vfs copy the tree with vfs.CopyTree then remove it, mount points
+// themselves can not be removed or moved.
+type FileSystem struct {
+	fs     vfs.ContextFS
+	mounts vfs.MountPather // nil if fs has no mount points
+	props  PropStore
+}
+
+var _ xwebdav.FileSystem = (*FileSystem)(nil)
+
+// NewFileSystem adapts fsys, dead properties are stored in props unless it is nil
+func NewFileSystem(fsys vfs.FS, props PropStore) *FileSystem {
+	m, _ := fsys.(vfs.MountPather)
+	return &FileSystem{fs: vfs.AsContextFS(fsys), mounts: m, props: props}
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// mounted reports whether name is or contains a mount point
+func (d *FileSystem) mounted(name string) bool {
+	return d.mounts != nil && (name == "/" || d.mounts.IsMountPath(name))
+}
+
+func (d *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return record(ctx, d.fs.MkdirContext(ctx, clean(name), perm))
+}
+
+func (d *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (xwebdav.File, error) {
+	name = clean(name)
+	f, err := d.fs.OpenFileContext(ctx, name, flag, perm)
+	if err != nil {
+		return nil, record(ctx, err)
+	}
+	return &file{File: f, ctx: ctx, fs: d, name: name}, nil
+}
+
+func (d *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = clean(name)
+	if d.mounted(name) {
+		return record(ctx, &fs.PathError{Op: "removeAll", Path: name, Err: fs.ErrPermission})
+	}
+	if err := d.fs.RemoveAllContext(ctx, name); err != nil {
+		return record(ctx, err)
+	}
+	if d.props != nil {
+		return d.props.Remove(ctx, name)
+	}
+	return nil
+}
+
+func (d *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	if d.mounted(oldName) {
+		return record(ctx, &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission})
+	}
+	err := d.fs.RenameContext(ctx, oldName, newName)
+	// Vfs does not rename across mount points
+	if errors.Is(err, syscall.ENOTSUP) {
+		if err = vfs.CopyTree(ctx, d.fs, oldName, newName); err == nil {
+			err = d.fs.RemoveAllContext(ctx, oldName)
+		}
+	}
+	if err != nil {
+		return record(ctx, err)
+	}
+	if d.props != nil {
+		return d.props.Move(ctx, oldName, newName)
+	}
+	return nil
+}
+
+func (d *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := d.fs.StatContext(ctx, clean(name))
+	return info, record(ctx, err)
+}
+
+// file holds the dead properties of an opened file
+type file struct {
+	vfs.File
+	ctx  context.Context
+	fs   *FileSystem
+	name string
+}
+
+var _ xwebdav.DeadPropsHolder = (*file)(nil)
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	return n, record(f.ctx, err)
+}
+
+func (f *file) DeadProps() (map[xml.Name]xwebdav.Property, error) {
+	if f.fs.props == nil {
+		return nil, nil
+	}
+	return f.fs.props.Get(f.ctx, f.name)
+}
+
+func (f *file) Patch(patches []xwebdav.Proppatch) ([]xwebdav.Propstat, error) {
+	pstat := xwebdav.Propstat{Status: http.StatusOK}
+	if f.fs.props == nil {
+		pstat.Status = http.StatusForbidden
+	} else if err := f.fs.props.Patch(f.ctx, f.name, patches); err != nil {
+		return nil, err
+	}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, xwebdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []xwebdav.Propstat{pstat}, nil
+}
+
+func (f *file) Close() error {
+	return record(f.ctx, f.File.Close())
+}
@@ -0,0 +1,145 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goxiaoy/vfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T, opts ...Option) *httptest.Server {
+	v := vfs.New()
+	assert.NoError(t, v.Mount("/a", afero.NewMemMapFs()))
+	assert.NoError(t, v.Mount("/b/c", afero.NewMemMapFs()))
+	ro := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(ro, "1.txt", []byte("1"), 0644))
+	assert.NoError(t, v.Mount("/ro", afero.NewReadOnlyFs(ro)))
+	srv := httptest.NewServer(NewHandler(v, opts...))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, srv *httptest.Server, method, name, body string, header map[string]string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+name, strings.NewReader(body))
+	assert.NoError(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestHandler(t *testing.T) {
+	srv := newServer(t)
+
+	code, _ := do(t, srv, "PUT", "/a/1.txt", "hello", nil)
+	assert.Equal(t, http.StatusCreated, code)
+	code, body := do(t, srv, "GET", "/a/1.txt", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello", body)
+
+	// mount points and their parents are listed
+	code, body = do(t, srv, "PROPFIND", "/", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, code)
+	for _, href := range []string{"<D:href>/a/</D:href>", "<D:href>/b/</D:href>", "<D:href>/ro/</D:href>"} {
+		assert.Contains(t, body, href)
+	}
+	code, body = do(t, srv, "PROPFIND", "/b", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.Contains(t, body, "<D:href>/b/c/</D:href>")
+
+	// across mount points
+	code, _ = do(t, srv, "MOVE", "/a/1.txt", "", map[string]string{"Destination": srv.URL + "/b/c/2.txt"})
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = do(t, srv, "GET", "/a/1.txt", "", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do(t, srv, "COPY", "/b/c/2.txt", "", map[string]string{"Destination": srv.URL + "/a/3.txt"})
+	assert.Equal(t, http.StatusCreated, code)
+	code, body = do(t, srv, "GET", "/a/3.txt", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello", body)
+
+	// mount points can not be removed
+	code, _ = do(t, srv, "DELETE", "/a", "", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = do(t, srv, "MOVE", "/b", "", map[string]string{"Destination": srv.URL + "/x"})
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestReadOnly(t *testing.T) {
+	srv := newServer(t)
+
+	code, body := do(t, srv, "GET", "/ro/1.txt", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", body)
+
+	code, body = do(t, srv, "PUT", "/ro/2.txt", "2", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "Forbidden", body)
+	code, _ = do(t, srv, "MKCOL", "/ro/dir", "", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = do(t, srv, "DELETE", "/ro/1.txt", "", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = do(t, srv, "MOVE", "/ro/1.txt", "", map[string]string{"Destination": srv.URL + "/a/1.txt"})
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = do(t, srv, "COPY", "/a", "", map[string]string{"Destination": srv.URL + "/ro/a"})
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+const proppatch = `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:example">
+<D:set><D:prop><Z:color>blue</Z:color></D:prop></D:set>
+</D:propertyupdate>`
+
+const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:Z="urn:example"><D:prop><Z:color/></D:prop></D:propfind>`
+
+func TestDeadProps(t *testing.T) {
+	for name, store := range map[string]PropStore{
+		"mem": NewMemPropStore(),
+		"fs":  NewFSPropStore(afero.NewMemMapFs(), "/props"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t, WithPropStore(store))
+			code, _ := do(t, srv, "PUT", "/a/1.txt", "1", nil)
+			assert.Equal(t, http.StatusCreated, code)
+			code, _ = do(t, srv, "PROPPATCH", "/a/1.txt", proppatch, nil)
+			assert.Equal(t, http.StatusMultiStatus, code)
+
+			code, _ = do(t, srv, "MOVE", "/a/1.txt", "", map[string]string{"Destination": srv.URL + "/b/c/1.txt"})
+			assert.Equal(t, http.StatusCreated, code)
+			code, body := do(t, srv, "PROPFIND", "/b/c/1.txt", propfind, map[string]string{"Depth": "0"})
+			assert.Equal(t, http.StatusMultiStatus, code)
+			assert.Contains(t, body, "blue")
+
+			code, _ = do(t, srv, "DELETE", "/b/c/1.txt", "", nil)
+			assert.Equal(t, http.StatusNoContent, code)
+			code, _ = do(t, srv, "PUT", "/b/c/1.txt", "1", nil)
+			assert.Equal(t, http.StatusCreated, code)
+			_, body = do(t, srv, "PROPFIND", "/b/c/1.txt", propfind, map[string]string{"Depth": "0"})
+			assert.NotContains(t, body, "blue")
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	srv := newServer(t)
+	lock := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	code, body := do(t, srv, "LOCK", "/a/1.txt", lock, nil)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Contains(t, body, "<D:locktoken>")
+	code, _ = do(t, srv, "PUT", "/a/1.txt", "1", nil)
+	assert.Equal(t, http.StatusLocked, code)
+}